@@ -4,10 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/arnodel/golua/lib"
 	"github.com/arnodel/golua/lib/debuglib"
-	"github.com/arnodel/golua/lib/golib"
 	"github.com/arnodel/golua/runtime"
 	"github.com/atotto/clipboard"
 )
@@ -23,17 +24,80 @@ type App struct {
 	logWindow     *Window
 	cmdCallback   func(string)
 	eventHandlers map[string]*EventHandler
+
+	macroRecorder *macroRecorder
+	lastMacro     *Macro
+	macros        map[string]*Macro
+	macroDepth    int
+
+	mode                 string
+	viBindingsRegistered bool
+	register             viRegister
+
+	pendingSince  time.Time
+	whichKeyDelay time.Duration
+
+	bufferEventHandlers map[Buffer]*EventHandler
+	minorModes          []string
+	keyReader           *keyReader
+	stringReader        *stringReader
+	finder              *fileFinder
+	message             string
+
+	commands map[string]*Command
+	luaAPI   *luaAPI
+	windows  []*Window // windows laid out on the screen, top to bottom
+
+	hooks         map[string][]hook
+	lastHookID    HookID
+	runningHooks  map[string]bool
+	openBuffers   map[Buffer]bool
+	openedBuffers []Buffer // buffers waiting for the buffer-opened hook
+
+	console *luaConsole
+
+	configDir          string
+	configSnapshot     *configSnapshot
+	defaultPackagePath string
+	enabledPlugins     map[string]bool // nil means all plugins are enabled
+	disabledPlugins    map[string]bool
+
+	posted    postQueue
+	jobs      map[int]*Job
+	lastJobID int
+
+	shellTimeout time.Duration
+	compilation  *compilation
+	grep         *grepSearch
+
+	completion        *completionPopup
+	completionSources []namedCompletionSource
+
+	snippets       map[string]map[string]Snippet // by kind and trigger
+	snippet        *snippetSession
+	snippetBuffers map[Buffer]bool // buffers listened to by snippets
+
+	foldProviders map[string]FoldProvider
+	syntaxes      map[string]*Syntax
+	fileKinds     map[string]string
+
+	marks           map[Buffer]map[string][]Mark
+	languageServers map[string]*LanguageServer
+	lspClients      map[string]*lspClient
+
+	luaLimits      LuaLimits
+	safeMode       bool
+	trustedPlugins map[string]bool
+	luaSandboxed   bool            // sandboxed Lua code is running
+	sandboxSources map[string]bool // names of the chunks compiled in a sandbox
 }
 
 func NewApp(win *Window) *App {
 
 	logBuf := &FileBuffer{
-		lines: make([]Line, 1),
-	}
-	logWin := &Window{
-		buffer:  logBuf,
-		tabSize: 4,
+		kind: consoleKind,
 	}
+	logWin := NewWindow(logBuf)
 
 	cmdBuf := &FileBuffer{
 		// 		lines: []Line{nil},
@@ -45,12 +109,9 @@ func NewApp(win *Window) *App {
 	// 		a.cmdCallback(cmd)
 	// 	}
 	// })
-	cmdWin := &Window{
-		buffer:  cmdBuf,
-		tabSize: 4,
-		//		eventHandler: cmdEventHandler,
-	}
+	cmdWin := NewWindow(cmdBuf)
 	evtHandler := NewEventHandler()
+	evtHandler.Name = globalKeymap
 
 	win.MoveCursorToEnd()
 	app := &App{
@@ -59,22 +120,63 @@ func NewApp(win *Window) *App {
 		focusedWindow: win,
 		cmdWindow:     cmdWin,
 		eventHandlers: map[string]*EventHandler{
-			"app": evtHandler,
+			globalKeymap: evtHandler,
 		},
-		eventHandler: evtHandler,
-		running:      true,
+		eventHandler:        evtHandler,
+		macros:              map[string]*Macro{},
+		bufferEventHandlers: map[Buffer]*EventHandler{},
+		whichKeyDelay:       time.Second,
+		commands:            map[string]*Command{},
+		hooks:               map[string][]hook{},
+		runningHooks:        map[string]bool{},
+		openBuffers:         map[Buffer]bool{},
+		jobs:                map[int]*Job{},
+		shellTimeout:        DefaultShellTimeout,
+		marks:               map[Buffer]map[string][]Mark{},
+		languageServers:     map[string]*LanguageServer{},
+		lspClients:          map[string]*lspClient{},
+		snippets:            map[string]map[string]Snippet{},
+		snippetBuffers:      map[Buffer]bool{},
+		foldProviders:       map[string]FoldProvider{},
+		syntaxes:            map[string]*Syntax{},
+		fileKinds:           map[string]string{},
+		luaLimits:           DefaultLuaLimits,
+		trustedPlugins:      map[string]bool{},
+		sandboxSources:      map[string]bool{},
+		windows:             []*Window{win},
+		running:             true,
+	}
+	for ext, kind := range defaultFileKinds {
+		app.fileKinds[ext] = kind
 	}
+	app.console = newLuaConsole(app, logWin, logBuf)
 	app.lua = runtime.New(app)
+	for _, cmd := range append(defaultCommands, consoleCommands...) {
+		if err := app.RegisterCommand(cmd); err != nil {
+			app.Logf("Unable to register command: %s", err)
+		}
+	}
 	for _, b := range defaultBindings {
-		if err := evtHandler.RegisterAction(b.seq, b.action); err != nil {
+		keymap := b.keymap
+		if keymap == "" {
+			keymap = globalKeymap
+		}
+		if err := app.BindCommand(keymap, b.seq, b.command); err != nil {
 			app.Logf("Unable to register %s: %s", b.seq, err)
 		}
 	}
 	lib.LoadAll(app.lua)
+	app.declareLibCompliance()
+	app.loadLuaAPI()
 	app.lua.PushContext(runtime.RuntimeContextDef{
 		MessageHandler: debuglib.Traceback,
 	})
+	app.addLSPHooks()
+	app.addDefaultCompletionSources()
 	win.RegisterWithApp(app)
+	// The console buffer is only tracked by hooks once it is shown.
+	logWin.eventHandler = app.GetEventHandler(consoleKind)
+	logWin.app = app
 	return app
 }
 
@@ -87,46 +189,111 @@ func (a *App) InitLuaFile(initfile string) error {
 	return a.InitLuaCode(initfile, luaCode)
 }
 
+// InitLuaCode runs a Lua chunk.  If the chunk returns a function, it is called
+// with the App.
 func (a *App) InitLuaCode(initfile string, luaCode []byte) error {
 	chunk, err := a.lua.CompileAndLoadLuaChunk(initfile, luaCode, runtime.TableValue(a.lua.GlobalEnv()))
 	if err != nil {
 		a.Logf("Error compiling init file: %s", err)
 		return err
 	}
-	initFunc, err2 := runtime.Call1(a.lua.MainThread(), runtime.FunctionValue(chunk))
+	initFunc, err2 := a.callLua(runtime.FunctionValue(chunk))
 	if err2 != nil {
 		a.Logf("Error running init chunk: %s", err2)
-		return err
+		return err2
+	}
+	if _, ok := initFunc.TryCallable(); !ok {
+		return nil
 	}
-	_, err2 = runtime.Call1(a.lua.MainThread(), initFunc, golib.NewGoValue(a.lua, a))
+	_, err2 = a.callLua(initFunc, a.LuaApp())
 	if err2 != nil {
 		a.Logf("Error running init function: %s", err2)
-		return err
+		return err2
 	}
 	return nil
 }
 
 func (a *App) Resize(w, h int) {
+	a.screenSize = Size{W: w, H: h}
 	a.focusedWindow.Resize(w, h-1)
 	a.cmdWindow.Resize(w, 1)
-	a.screenSize = Size{W: w, H: h}
+	a.layoutWindows()
 }
 
 func (a *App) HandleEvent(evt Event) {
-	action, err := a.focusedWindow.HandleEvent(evt)
-	if action == nil {
-		action, err = a.eventHandler.HandleEvent(evt)
+	a.runPosted()
+	a.runBufferOpenedHooks()
+	if evt.EventType == NoEvent {
+		a.checkPendingTimeout()
+		return
 	}
-	if action != nil {
-		a.focusedWindow.eventHandler.Reset()
-		a.eventHandler.Reset()
+	if evt.EventType == Key || evt.EventType == Rune {
+		a.message = ""
 	}
-	if err != nil {
-		a.Logf("Error handling event: %s", err)
+	if evt.EventType == Mouse {
+		evt = a.routeMouseEvent(evt)
 	}
-	if action != nil {
-		action(a.focusedWindow)
+	if a.macroRecorder != nil && a.macroDepth == 0 && recordable(evt) {
+		a.macroRecorder.add(evt)
 	}
+	// What is typed at a prompt is recorded once the prompt is closed.
+	if r := a.stringReader; r != nil && evt.EventType != Resize && evt.EventType != Mouse {
+		r.handle(a, evt)
+		if a.stringReader != r {
+			a.commitMacroEvents()
+		}
+		return
+	}
+	if f := a.finder; f != nil && evt.EventType != Resize && evt.EventType != Mouse {
+		f.handle(a, evt)
+		if a.finder != f {
+			a.commitMacroEvents()
+		}
+		return
+	}
+	if r := a.keyReader; r != nil && evt.EventType != Resize {
+		r.handle(a, evt)
+		if a.keyReader != r {
+			a.commitMacroEvents()
+		}
+		return
+	}
+	win := a.focusedWindow
+	l, c := win.CursorPos()
+	a.dispatchEvent(a.eventHandlerStack(), evt)
+	a.updateCompletion()
+	a.updatePending()
+	if l1, c1 := win.CursorPos(); win == a.focusedWindow && (l1 != l || c1 != c) {
+		a.runHooks(&HookEvent{Name: HookCursorMoved, Window: win, Buffer: win.buffer})
+	}
+}
+
+// SetMode switches to an editing mode, whose event handler then takes
+// precedence over the others.  The empty mode disables modal editing.
+func (a *App) SetMode(mode string) {
+	for _, h := range a.eventHandlerStack() {
+		h.Reset()
+	}
+	oldMode := a.mode
+	a.mode = mode
+	if mode != oldMode {
+		a.runHooks(&HookEvent{
+			Name:    HookModeChanged,
+			Window:  a.focusedWindow,
+			Buffer:  a.focusedWindow.buffer,
+			Mode:    mode,
+			OldMode: oldMode,
+		})
+	}
+}
+
+// Mode returns the current editing mode.
+func (a *App) Mode() string {
+	return a.mode
+}
+
+func modeHandlerName(mode string) string {
+	return mode + "-mode"
 }
 
 func (a *App) CommandInput() {
@@ -140,41 +307,92 @@ func (a *App) CommandInput() {
 }
 
 func (a *App) Draw(screen *Screen) {
+	a.runBufferOpenedHooks()
 	screen.Fill(' ')
 	sz := screen.Size()
 	wscreen := screen.SubScreen(Rectangle{
 		Size: Size{W: sz.W, H: sz.H - 1},
 	})
-	a.focusedWindow.FocusCursor(wscreen)
-	a.focusedWindow.Draw(wscreen)
-	a.focusedWindow.DrawCursor(wscreen)
+	if a.windowIndex(a.focusedWindow) >= 0 {
+		a.drawWindows(screen)
+	} else {
+		a.focusedWindow.FocusCursor(wscreen)
+		a.focusedWindow.Draw(wscreen)
+		a.focusedWindow.DrawCursor(wscreen)
+	}
+	a.drawCompletion(wscreen)
+	if a.finder != nil {
+		a.drawFinder(wscreen)
+	}
+	if a.whichKeyVisible() {
+		a.drawWhichKey(wscreen)
+	}
+	a.drawStatusLine(screen.SubScreen(Rectangle{
+		Position: Position{Y: sz.H - 1},
+		Size:     Size{W: sz.W, H: 1},
+	}))
 	screen.Show()
 }
 
+func (a *App) drawStatusLine(screen ScreenWriter) {
+	var p Position
+	if a.mode != "" {
+		p = WriteString(screen, p, "-- "+strings.ToUpper(a.mode)+" -- ", DefaultStyle.Bold(true))
+	}
+	switch {
+	case a.stringReader != nil:
+		a.drawStringReader(screen, p)
+	case a.finder != nil:
+		a.drawFinderPrompt(screen, p)
+	case a.keyReader != nil:
+		WriteString(screen, p, a.keyReader.prompt+eventNames(a.keyReader.events), DefaultStyle)
+	case a.message != "":
+		WriteString(screen, p, a.message, DefaultStyle)
+	default:
+		if keys := a.PendingKeys(); keys != "" {
+			WriteString(screen, p, keys, DefaultStyle)
+		} else if m, ok := a.markAt(a.focusedWindow.buffer, a.focusedWindow.l); ok {
+			WriteString(screen, p, m.Message, m.Style)
+		}
+	}
+}
+
 func (a *App) SwitchWindow() {
 	if a.focusedWindow == a.window {
-		a.focusedWindow = a.logWindow
+		a.FocusWindow(a.logWindow)
 	} else {
-		a.focusedWindow = a.window
+		a.FocusWindow(a.window)
 	}
 	a.focusedWindow.Resize(a.screenSize.W, a.screenSize.H-1)
 }
 
+// Write prints to the Lua console.  It is the standard output of the Lua
+// runtime.
 func (a *App) Write(p []byte) (int, error) {
 	log.Print(string(p))
-	return len(p), nil
+	return a.console.Write(p)
 }
 
 func (a *App) Log(msg string) {
 	log.Print(msg)
-	a.logWindow.buffer.AppendLine(NewLineFromString(msg, nil))
+	a.console.output(msg)
 }
 
 func (a *App) Logf(format string, args ...interface{}) {
 	a.Log(fmt.Sprintf(format, args...))
 }
 
+// ShowMessage displays a message in the status line until the next key is
+// pressed.  The message is also logged.
+func (a *App) ShowMessage(format string, args ...interface{}) {
+	a.message = fmt.Sprintf(format, args...)
+	a.Log(a.message)
+}
+
+// Quit runs the app-quit hook and stops the application.
 func (a *App) Quit() {
+	a.runHooks(&HookEvent{Name: HookAppQuit})
+	a.cancelJobs()
 	a.running = false
 }
 
@@ -186,15 +404,20 @@ func (a *App) CopyToClipboard(s string) error {
 	return clipboard.WriteAll(s)
 }
 
+// LuaActionMaker returns an ActionMaker which calls the Lua function f with the
+// window, the event fields captured by the key sequence and the repeat count,
+// converted as described in the documentation of the "edit" Lua module.
 func (a *App) LuaActionMaker(f runtime.Value) ActionMaker {
-	return func(args []interface{}) Action {
-		luaArgs := make([]runtime.Value, len(args)+1)
+	call := a.luaCaller(f)
+	return func(args []interface{}, count int) Action {
+		luaArgs := make([]runtime.Value, len(args)+2)
 		for i, arg := range args {
-			luaArgs[i+1] = golib.NewGoValue(a.lua, arg)
+			luaArgs[i+1] = a.luaEventArg(arg)
 		}
+		luaArgs[len(args)+1] = runtime.IntValue(int64(count))
 		return func(win *Window) {
-			luaArgs[0] = golib.NewGoValue(a.lua, win)
-			_, err := runtime.Call1(a.lua.MainThread(), f, luaArgs...)
+			luaArgs[0] = a.LuaWindow(win)
+			_, err := call(luaArgs...)
 			if err != nil {
 				a.Logf("Lua error: %s", err)
 			}
@@ -220,6 +443,7 @@ func (a *App) GetEventHandler(name string) *EventHandler {
 	h, ok := a.eventHandlers[name]
 	if !ok {
 		h = NewEventHandler()
+		h.Name = name
 		a.eventHandlers[name] = h
 	}
 	return h
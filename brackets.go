@@ -0,0 +1,217 @@
+package edit
+
+import (
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// The bracket at or before the cursor and the bracket matching it are
+// highlighted.  With the auto-pair minor mode, typing an opener of the auto
+// pairs of the buffer syntax also inserts the closer.
+
+// autoPairMode is the minor mode which closes the brackets typed.
+const autoPairMode = "auto-pair"
+
+func matchedBracket(s Style) Style   { return s.Background(tcell.ColorTeal) }
+func unmatchedBracket(s Style) Style { return s.Background(tcell.ColorRed) }
+
+// A bracketMark is a bracket highlighted in a window.
+type bracketMark struct {
+	l, c  int
+	style func(Style) Style
+}
+
+// MatchBracket returns the position of the bracket at the cursor, or before
+// it, and of the bracket matching it.  matched is false if there is no
+// matching bracket, or it is not of the same kind.  ok is false if there is no
+// bracket outside strings and comments at the cursor.
+func (w *Window) MatchBracket() (l0, c0, l1, c1 int, matched, ok bool) {
+	syn := w.app.Syntax(w.buffer.Kind())
+	line, _ := w.buffer.GetLine(w.l, 0)
+	c := -1
+	for _, i := range []int{w.c, w.c - 1} {
+		if i >= 0 && i < line.Len() {
+			if _, _, isBracket := pairOf(syn.Pairs, line.Runes[i]); isBracket {
+				c = i
+				break
+			}
+		}
+	}
+	if c < 0 {
+		return
+	}
+	type open struct {
+		l, c int
+		r    rune
+	}
+	var stack []open
+	seen := false
+	start := w.l - maxScanLines
+	if start < 0 {
+		start = 0
+	}
+	syn.scanCode(w.buffer, start, w.l+maxScanLines, func(l, c2 int, r rune) bool {
+		isTarget := l == w.l && c2 == c
+		seen = seen || isTarget
+		pair, opens, isBracket := pairOf(syn.Pairs, r)
+		switch {
+		case !isBracket:
+		case opens:
+			stack = append(stack, open{l, c2, r})
+		case len(stack) == 0:
+			if isTarget {
+				l0, c0, l1, c1, ok = l, c2, l, c2, true
+				return false
+			}
+		default:
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if isTarget || top.l == w.l && top.c == c {
+				l0, c0, l1, c1, ok = top.l, top.c, l, c2, true
+				matched = []rune(pair)[0] == top.r
+				return false
+			}
+		}
+		return true
+	})
+	switch {
+	case !seen:
+		ok = false
+	case !ok:
+		// An opener without a closer
+		l0, c0, l1, c1, ok = w.l, c, w.l, c, true
+	}
+	return
+}
+
+// updateBrackets finds the brackets to highlight.
+func (w *Window) updateBrackets() {
+	w.brackets = w.brackets[:0]
+	l0, c0, l1, c1, matched, ok := w.MatchBracket()
+	switch {
+	case !ok:
+	case matched:
+		w.brackets = append(w.brackets, bracketMark{l0, c0, matchedBracket}, bracketMark{l1, c1, matchedBracket})
+	default:
+		w.brackets = append(w.brackets, bracketMark{l0, c0, unmatchedBracket})
+		if l1 != l0 || c1 != c0 {
+			w.brackets = append(w.brackets, bracketMark{l1, c1, unmatchedBracket})
+		}
+	}
+}
+
+// bracketRanges returns the ranges of line l with highlighted brackets.
+func (w *Window) bracketRanges(l int) []styledRange {
+	var ranges []styledRange
+	for _, b := range w.brackets {
+		if b.l == l {
+			ranges = append(ranges, styledRange{b.c, b.c + 1, b.style})
+		}
+	}
+	return ranges
+}
+
+//
+// Auto pairs
+//
+
+type autoCloser struct {
+	l, c int
+}
+
+// autoPair returns the auto pair r is part of, and true if r opens it.
+func (w *Window) autoPair(r rune) ([]rune, bool, bool) {
+	if w.app == nil || !w.app.MinorModeEnabled(autoPairMode) {
+		return nil, false, false
+	}
+	pair, opens, ok := pairOf(w.app.Syntax(w.buffer.Kind()).AutoPairs, r)
+	return []rune(pair), opens, ok
+}
+
+// autoCloserAt returns the index of the auto-inserted closer at (l, c).
+func (w *Window) autoCloserAt(l, c int) int {
+	for i, a := range w.autoClosers {
+		if a.l == l && a.c == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// TypeRune inserts r at the cursor, unless it is the closer inserted when its
+// opener was typed, in which case the cursor moves over it.  If r opens an
+// auto pair, the closer is also inserted.
+func (w *Window) TypeRune(r rune) {
+	pair, opens, ok := w.autoPair(r)
+	if !ok {
+		w.InsertRune(r)
+		return
+	}
+	if i := w.autoCloserAt(w.l, w.c); i >= 0 && pair[1] == r && w.runeAt(w.l, w.c) == r {
+		w.autoClosers = append(w.autoClosers[:i], w.autoClosers[i+1:]...)
+		w.c++
+		return
+	}
+	next := w.runeAt(w.l, w.c)
+	_, nextOpens, nextIsPair := pairOf(w.app.Syntax(w.buffer.Kind()).AutoPairs, next)
+	closeIt := opens && (unicode.IsSpace(next) || nextIsPair && !nextOpens)
+	if pair[0] == pair[1] && w.c > 0 && isWordRune(w.runeAt(w.l, w.c-1)) {
+		// An apostrophe or a closing quote.
+		closeIt = false
+	}
+	w.InsertRune(r)
+	if closeIt {
+		w.listenToBuffer()
+		if err := w.buffer.InsertRune(pair[1], w.l, w.c); err == nil {
+			w.autoClosers = append(w.autoClosers, autoCloser{w.l, w.c})
+		}
+	}
+}
+
+// DeleteRuneOrPair deletes the rune before the cursor, and the rune after it
+// if they make an empty auto pair.
+func (w *Window) DeleteRuneOrPair() error {
+	if w.c == 0 {
+		return w.DeleteRune()
+	}
+	if pair, opens, ok := w.autoPair(w.runeAt(w.l, w.c-1)); ok && opens && w.runeAt(w.l, w.c) == pair[1] {
+		if err := w.buffer.DeleteRuneAt(w.l, w.c); err != nil {
+			return err
+		}
+	}
+	return w.DeleteRune()
+}
+
+// moveAutoClosers moves the auto-inserted closers after a change, forgetting
+// the ones which are deleted.
+func (w *Window) moveAutoClosers(ch BufferChange) {
+	closers := w.autoClosers[:0]
+	for _, a := range w.autoClosers {
+		removed := (a.l > ch.L0 || a.l == ch.L0 && a.c >= ch.C0) && (a.l < ch.L1 || a.l == ch.L1 && a.c < ch.C1)
+		if removed {
+			continue
+		}
+		a.l, a.c = movePos(a.l, a.c, ch, false)
+		closers = append(closers, a)
+	}
+	w.autoClosers = closers
+}
+
+func CmdTypeRune(r rune, n int) Action {
+	return func(w *Window) {
+		for i := 0; i < n; i++ {
+			w.TypeRune(r)
+		}
+	}
+}
+
+func CmdDeleteBackward(w *Window, n int) {
+	for i := 0; i < n; i++ {
+		if w.DeleteRuneOrPair() != nil {
+			return
+		}
+	}
+}
+
+func CmdAutoPairMode(w *Window) { w.App().ToggleMinorMode(autoPairMode) }
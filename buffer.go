@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"unicode/utf8"
@@ -20,6 +21,7 @@ type Buffer interface {
 	MergeLineWithPrevious(l int) error
 	SplitLine(l, c int) error
 	DeleteRuneAt(l, c int) error
+	DeleteRegion(l0, c0, l1, c1 int) error
 	AdvancePos(l, c, dl, dc int) (int, int)
 	EndPos() (int, int)
 	AppendLine(Line)
@@ -34,23 +36,67 @@ type FileBuffer struct {
 	lines    []Line
 	filename string
 	readOnly bool
+	kind     string // "plain" if empty
+
+	changeListeners []func(BufferChange)
+	history         undoHistory
 }
 
 var _ Buffer = (*FileBuffer)(nil)
 
+// errReadOnly is returned by the methods changing a read only buffer.  Such a
+// buffer only changes with AppendLine and Truncate, which are used to fill it.
+var errReadOnly = errors.New("read only buffer")
+
 func NewEmptyFileBuffer() *FileBuffer {
 	return &FileBuffer{
 		lines: []Line{NewLineFromString("", nil)},
 	}
 }
 
+// defaultFileKinds maps file extensions to the kind of buffers read from such
+// files, until changed with App.SetFileKind.
+var defaultFileKinds = map[string]string{
+	".c":    "c",
+	".go":   "go",
+	".h":    "c",
+	".js":   "javascript",
+	".json": "json",
+	".lua":  "lua",
+	".md":   "markdown",
+	".py":   "python",
+	".rs":   "rust",
+	".sh":   "shell",
+	".ts":   "typescript",
+}
+
+// SetFileKind sets the kind of buffers read by the App from files with the
+// extension ext, e.g. ".go".
+func (a *App) SetFileKind(ext, kind string) {
+	a.fileKinds[ext] = kind
+}
+
+// readFile returns a buffer with the contents of the named file, whose kind
+// depends on the extension of the file as set with SetFileKind.
+func (a *App) readFile(filename string) *FileBuffer {
+	return readFileBuffer(filename, a.fileKinds)
+}
+
 func NewBufferFromFile(filename string) *FileBuffer {
+	return readFileBuffer(filename, defaultFileKinds)
+}
+
+func readFileBuffer(filename string, kinds map[string]string) *FileBuffer {
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		return NewDirectoryBuffer(filename)
+	}
 	buf := &FileBuffer{
 		filename: filename,
+		kind:     kinds[filepath.Ext(filename)],
 	}
 	file, err := os.Open(filename)
 	if err != nil {
-		buf.InsertLine(0, Line{})
+		buf.insertLine(0, Line{})
 		return buf
 	}
 	defer file.Close()
@@ -65,7 +111,7 @@ func NewBufferFromFile(filename string) *FileBuffer {
 	}
 	buf.lines = lines
 	if len(lines) == 0 {
-		buf.InsertLine(0, Line{})
+		buf.insertLine(0, Line{})
 	}
 	return buf
 }
@@ -118,37 +164,80 @@ func (b *FileBuffer) GetLine(l, c int) (Line, error) {
 }
 
 func (b *FileBuffer) SetLine(l int, line Line) error {
+	if b.readOnly {
+		return errReadOnly
+	}
 	if l < 0 || len(b.lines) <= l {
 		return fmt.Errorf("out of range")
 	}
+	old := b.lines[l]
 	b.lines[l] = line
+	b.notifyChange(BufferChange{
+		L0: l, L1: l, C1: old.Len(),
+		Removed:  old.String(),
+		Inserted: line.String(),
+	})
 	return nil
 }
 
 func (b *FileBuffer) InsertRune(r rune, l, c int) error {
+	if b.readOnly {
+		return errReadOnly
+	}
 	line, err := b.GetLine(l, c)
 	if err != nil {
 		return err
 	}
 	b.lines[l] = line.InsertRune(r, c)
+	b.notifyChange(BufferChange{L0: l, C0: c, L1: l, C1: c, Inserted: string(r)})
 	return nil
 }
 
 func (b *FileBuffer) InsertString(s string, l, c int) (int, int, error) {
-	for i, part := range splitString(s) {
+	if b.readOnly {
+		return l, c, errReadOnly
+	}
+	if _, err := b.GetLine(l, c); err != nil {
+		return l, c, err
+	}
+	l0, c0 := l, c
+	parts := splitString(s)
+	for i, part := range parts {
 		if i > 0 {
-			if err := b.SplitLine(l, c); err != nil {
-				return l, c, err
-			}
+			b.splitLine(l, c)
 			l, c = b.AdvancePos(l+1, 0, 0, 0)
 		}
 		b.lines[l] = b.lines[l].InsertString(part, c)
 		l, c = b.AdvancePos(l, c, 0, utf8.RuneCountInString(part))
 	}
+	b.notifyChange(BufferChange{L0: l0, C0: c0, L1: l0, C1: c0, Inserted: strings.Join(parts, "\n")})
 	return l, c, nil
 }
 
 func (b *FileBuffer) InsertLine(l int, line Line) error {
+	if b.readOnly {
+		return errReadOnly
+	}
+	return b.insertLineNotify(l, line)
+}
+
+func (b *FileBuffer) insertLineNotify(l int, line Line) error {
+	if err := b.insertLine(l, line); err != nil {
+		return err
+	}
+	switch {
+	case l < len(b.lines)-1:
+		b.notifyChange(BufferChange{L0: l, L1: l, Inserted: line.String() + "\n"})
+	case l > 0:
+		c := b.lines[l-1].Len()
+		b.notifyChange(BufferChange{L0: l - 1, C0: c, L1: l - 1, C1: c, Inserted: "\n" + line.String()})
+	default:
+		b.notifyChange(BufferChange{Inserted: line.String()})
+	}
+	return nil
+}
+
+func (b *FileBuffer) insertLine(l int, line Line) error {
 	if l < 0 || l > len(b.lines) {
 		return fmt.Errorf("out of range")
 	}
@@ -171,54 +260,131 @@ func (b *FileBuffer) InsertLine(l int, line Line) error {
 }
 
 func (b *FileBuffer) AppendLine(line Line) {
-	b.lines = append(b.lines, line)
+	b.insertLineNotify(len(b.lines), line)
 }
 
 func (b *FileBuffer) DeleteLine(l int) error {
+	if b.readOnly {
+		return errReadOnly
+	}
 	if l < 0 || l >= len(b.lines) {
 		return fmt.Errorf("out of range")
 	}
+	change := b.lineDeletion(l)
 	copy(b.lines[l:], b.lines[l+1:])
 	b.lines = b.lines[:len(b.lines)-1]
+	b.notifyChange(change)
 	return nil
 }
 
+// lineDeletion returns the change made by deleting line l.
+func (b *FileBuffer) lineDeletion(l int) BufferChange {
+	line := b.lines[l]
+	switch {
+	case l < len(b.lines)-1:
+		return BufferChange{L0: l, L1: l + 1, Removed: line.String() + "\n"}
+	case l > 0:
+		c := b.lines[l-1].Len()
+		return BufferChange{L0: l - 1, C0: c, L1: l, C1: line.Len(), Removed: "\n" + line.String()}
+	default:
+		return BufferChange{C1: line.Len(), Removed: line.String()}
+	}
+}
+
 func (b *FileBuffer) Truncate(count int) {
+	if count >= len(b.lines) {
+		return
+	}
+	l1, c1 := b.EndPos()
+	var change BufferChange
+	if count > 0 {
+		change.L0, change.C0 = count-1, b.lines[count-1].Len()
+	}
+	change.L1, change.C1 = l1, c1
+	change.Removed, _ = RegionString(b, change.L0, change.C0, l1, c1)
 	b.lines = b.lines[:count]
+	b.notifyChange(change)
 }
 
 func (b *FileBuffer) MergeLineWithPrevious(l int) error {
+	if b.readOnly {
+		return errReadOnly
+	}
 	if l < 1 || l >= len(b.lines) {
 		return fmt.Errorf("out of range")
 	}
+	c := b.lines[l-1].Len()
 	b.lines[l-1] = b.lines[l-1].MergeWith(b.lines[l])
 	copy(b.lines[l:], b.lines[l+1:])
 	b.lines = b.lines[:len(b.lines)-1]
+	b.notifyChange(BufferChange{L0: l - 1, C0: c, L1: l, Removed: "\n"})
 	return nil
 }
 
 func (b *FileBuffer) SplitLine(l, c int) error {
-	line, err := b.GetLine(l, c)
-	if err != nil {
+	if b.readOnly {
+		return errReadOnly
+	}
+	if _, err := b.GetLine(l, c); err != nil {
 		return err
 	}
-	l1, l2 := line.SplitAt(c)
-	b.lines[l] = l1
-	b.InsertLine(l+1, l2)
+	b.splitLine(l, c)
+	b.notifyChange(BufferChange{L0: l, C0: c, L1: l, C1: c, Inserted: "\n"})
 	return nil
 }
 
+func (b *FileBuffer) splitLine(l, c int) {
+	l1, l2 := b.lines[l].SplitAt(c)
+	b.lines[l] = l1
+	b.insertLine(l+1, l2)
+}
+
 func (b *FileBuffer) DeleteRuneAt(l, c int) error {
+	if b.readOnly {
+		return errReadOnly
+	}
 	line, err := b.GetLine(l, c)
 	if err != nil {
 		return err
 	}
 	if line.Len() == 0 {
-		copy(b.lines[l:], b.lines[l+1:])
-		b.lines = b.lines[:len(b.lines)-1]
-		return nil
+		return b.DeleteLine(l)
+	}
+	if c >= line.Len() {
+		return errors.New("line too short")
 	}
+	r := line.Runes[c]
 	b.lines[l] = line.DeleteAt(c)
+	b.notifyChange(BufferChange{L0: l, C0: c, L1: l, C1: c + 1, Removed: string(r)})
+	return nil
+}
+
+// DeleteRegion deletes the text from (l0, c0) up to but not including (l1,
+// c1), joining the first and last lines of the region.
+func (b *FileBuffer) DeleteRegion(l0, c0, l1, c1 int) error {
+	if b.readOnly {
+		return errReadOnly
+	}
+	if l1 < l0 || (l0 == l1 && c1 < c0) {
+		l0, c0, l1, c1 = l1, c1, l0, c0
+	}
+	if l0 < 0 || l1 >= len(b.lines) {
+		return fmt.Errorf("out of range")
+	}
+	first, last := b.lines[l0], b.lines[l1]
+	if c0 < 0 || c0 > first.Len() || c1 < 0 || c1 > last.Len() {
+		return errors.New("line too short")
+	}
+	removed, err := RegionString(b, l0, c0, l1, c1)
+	if err != nil {
+		return err
+	}
+	runes := make([]rune, 0, c0+last.Len()-c1)
+	runes = append(runes, first.Runes[:c0]...)
+	runes = append(runes, last.Runes[c1:]...)
+	b.lines[l0] = Line{Runes: runes, Meta: first.Meta}
+	b.lines = append(b.lines[:l0+1], b.lines[l1+1:]...)
+	b.notifyChange(BufferChange{L0: l0, C0: c0, L1: l1, C1: c1, Removed: removed})
 	return nil
 }
 
@@ -281,7 +447,10 @@ func (b *FileBuffer) StyledLineIter(l, c int) StyledLineIter {
 }
 
 func (b *FileBuffer) Kind() string {
-	return "plain"
+	if b.kind == "" {
+		return "plain"
+	}
+	return b.kind
 }
 
 func (b *FileBuffer) StringFromRegion(l0, c0, l1, c1 int) (string, error) {
@@ -310,8 +479,32 @@ func (b *FileBuffer) StringFromRegion(l0, c0, l1, c1 int) (string, error) {
 	return builder.String(), nil
 }
 
+// RegionString returns the text of buf from (l0, c0) up to but not including
+// (l1, c1).
+func RegionString(buf Buffer, l0, c0, l1, c1 int) (string, error) {
+	if l1 < l0 || (l0 == l1 && c1 < c0) {
+		l0, c0, l1, c1 = l1, c1, l0, c0
+	}
+	if l0 == l1 && c0 == c1 {
+		return "", nil
+	}
+	return buf.StringFromRegion(l0, c0, l1, c1-1)
+}
+
+// ReplaceRegion replaces the text of buf from (l0, c0) up to but not including
+// (l1, c1) with s, and returns the position of the end of the inserted text.
+func ReplaceRegion(buf Buffer, l0, c0, l1, c1 int, s string) (int, int, error) {
+	if l1 < l0 || (l0 == l1 && c1 < c0) {
+		l0, c0, l1, c1 = l1, c1, l0, c0
+	}
+	if err := buf.DeleteRegion(l0, c0, l1, c1); err != nil {
+		return l0, c0, err
+	}
+	return buf.InsertString(s, l0, c0)
+}
+
 func splitString(s string) []string {
 	return newLines.Split(s, -1)
 }
 
-var newLines = regexp.MustCompile(`(?s)\r\n|\n\r|\r`)
+var newLines = regexp.MustCompile(`(?s)\r\n|\n\r|\r|\n`)
@@ -0,0 +1,49 @@
+package edit
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// A BufferChange describes a modification of a buffer: the text from (L0, C0)
+// up to but not including (L1, C1) was replaced with Inserted.  Positions are
+// those before the change.
+type BufferChange struct {
+	L0, C0   int
+	L1, C1   int
+	Removed  string
+	Inserted string
+}
+
+// InsertedEnd returns the position of the end of the inserted text, after the
+// change.
+func (c BufferChange) InsertedEnd() (int, int) {
+	n := strings.Count(c.Inserted, "\n")
+	if n == 0 {
+		return c.L0, c.C0 + utf8.RuneCountInString(c.Inserted)
+	}
+	last := c.Inserted[strings.LastIndexByte(c.Inserted, '\n')+1:]
+	return c.L0 + n, utf8.RuneCountInString(last)
+}
+
+// A ChangeNotifier is a Buffer which can report changes made to it.
+type ChangeNotifier interface {
+	AddChangeListener(f func(BufferChange))
+}
+
+var _ ChangeNotifier = (*FileBuffer)(nil)
+
+// AddChangeListener makes f be called after each change to the buffer.
+func (b *FileBuffer) AddChangeListener(f func(BufferChange)) {
+	b.changeListeners = append(b.changeListeners, f)
+}
+
+func (b *FileBuffer) notifyChange(change BufferChange) {
+	// Read only buffers cannot be undone.
+	if !b.readOnly {
+		b.history.record(change)
+	}
+	for _, f := range b.changeListeners {
+		f(change)
+	}
+}
@@ -9,6 +9,7 @@ import (
 )
 
 func main() {
+	safe := flag.Bool("safe", false, "load untrusted plugins in a sandbox")
 	flag.Parse()
 	var buf edit.Buffer
 	if flag.NArg() == 0 {
@@ -30,6 +31,8 @@ func main() {
 	// Initialise the app
 	win := edit.NewWindow(buf)
 	app := edit.NewApp(win)
+	app.SetSafeMode(*safe)
+	app.LoadConfig(edit.ConfigDir())
 
 	// Initialise the screen
 	screen, err := edit.NewScreen()
@@ -37,10 +40,11 @@ func main() {
 		log.Fatalf("error getting screen: %s", err)
 	}
 	defer screen.Cleanup()
+	app.SetWaker(screen.Wake)
 
 	// Event loop
 	for app.Running() {
 		app.Draw(screen)
-		app.HandleEvent(screen.PollEvent())
+		app.HandleEvent(screen.PollEventTimeout(app.PendingTimeout()))
 	}
 }
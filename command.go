@@ -1,5 +1,13 @@
 package edit
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/arnodel/golua/runtime"
+)
+
 type ArgType interface {
 	FromString() interface{}
 }
@@ -10,11 +18,14 @@ type Parameter struct {
 	Type        ArgType
 }
 
+// A Command is a named action with a description.  Commands are registered
+// with the App and can then be bound to event sequences by name.
 type Command struct {
 	Name        string
 	Description string
 	Parameters  []Parameter
 	Action      CommandAction
+	ActionMaker ActionMaker
 }
 
 type Invocation struct {
@@ -25,3 +36,69 @@ type Invocation struct {
 type CommandAction interface {
 	Apply(w *App)
 }
+
+// RegisterCommand adds a command to the registry, replacing any command with
+// the same name.
+func (a *App) RegisterCommand(cmd *Command) error {
+	if cmd.Name == "" {
+		return errors.New("command has no name")
+	}
+	if cmd.ActionMaker == nil {
+		return fmt.Errorf("command %s has no action", cmd.Name)
+	}
+	a.commands[cmd.Name] = cmd
+	return nil
+}
+
+// GetCommand returns the command with the given name, or nil if there is none.
+func (a *App) GetCommand(name string) *Command {
+	return a.commands[name]
+}
+
+// Commands returns all registered commands sorted by name.
+func (a *App) Commands() []*Command {
+	cmds := make([]*Command, 0, len(a.commands))
+	for _, cmd := range a.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return cmds
+}
+
+// BindCommand binds seq to the named command in the named keymap.
+func (a *App) BindCommand(keymap, seq, command string) error {
+	cmd := a.GetCommand(command)
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	return a.GetEventHandler(keymap).RegisterCommand(seq, cmd)
+}
+
+// DefineCommand registers a command implemented by a Lua function, which is
+// called like the functions bound with BindEvents.
+func (a *App) DefineCommand(name, description string, f runtime.Value) error {
+	return a.RegisterCommand(&Command{
+		Name:        name,
+		Description: description,
+		ActionMaker: a.LuaActionMaker(f),
+	})
+}
+
+// RunCommand runs the named command in win.  Commands taking parameters from
+// events cannot be run this way.
+func (a *App) RunCommand(win *Window, name string, count int) error {
+	cmd := a.GetCommand(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	if len(cmd.Parameters) > 0 {
+		return fmt.Errorf("command %s needs arguments", name)
+	}
+	if win == nil {
+		return errors.New("no window")
+	}
+	cmd.ActionMaker(nil, count)(win)
+	return nil
+}
@@ -0,0 +1,190 @@
+package edit
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Lines are commented with the line comment of the buffer syntax if it has
+// one, otherwise they are wrapped in its block comment.
+
+// ToggleComment comments lines l0 to l1, or uncomments them if they are all
+// commented already.  Line comments are inserted at the smallest indentation
+// of the lines so that they stay aligned.  It is undone as one step.
+func (w *Window) ToggleComment(l0, l1 int) (err error) {
+	if l1 < l0 {
+		l0, l1 = l1, l0
+	}
+	if max := w.buffer.LineCount() - 1; l1 > max {
+		l1 = max
+	}
+	syn := w.app.Syntax(w.buffer.Kind())
+	switch {
+	case syn.LineComment != "":
+		WithUndoGroup(w.buffer, func() { err = w.toggleLineComments(syn.LineComment, l0, l1) })
+	case syn.BlockComment[0] != "" && syn.BlockComment[1] != "":
+		WithUndoGroup(w.buffer, func() { err = w.toggleBlockComment(syn.BlockComment, l0, l1) })
+	default:
+		err = errors.New("no comment syntax")
+	}
+	return
+}
+
+func (w *Window) toggleLineComments(prefix string, l0, l1 int) error {
+	var lines [][]rune
+	indent := -1
+	commented := true
+	for l := l0; l <= l1; l++ {
+		line, err := w.buffer.GetLine(l, 0)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line.Runes)
+		i := leadingSpace(line.Runes)
+		if i == len(line.Runes) {
+			continue
+		}
+		if indent < 0 || i < indent {
+			indent = i
+		}
+		commented = commented && strings.HasPrefix(string(line.Runes[i:]), prefix)
+	}
+	if indent < 0 {
+		// Only blank lines: start a comment on each of them.
+		for i, runes := range lines {
+			if err := w.insertInLine(l0+i, len(runes), prefix+" "); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	n := len([]rune(prefix))
+	for i, runes := range lines {
+		c := leadingSpace(runes)
+		if c == len(runes) {
+			continue
+		}
+		var err error
+		if commented {
+			m := n
+			if c+m < len(runes) && runes[c+m] == ' ' {
+				m++
+			}
+			err = w.deleteInLine(l0+i, c, m)
+		} else {
+			err = w.insertInLine(l0+i, indent, prefix+" ")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Window) toggleBlockComment(delims [2]string, l0, l1 int) error {
+	// Ignore blank lines around the text.
+	for l0 < l1 && w.blankLine(l0) {
+		l0++
+	}
+	for l1 > l0 && w.blankLine(l1) {
+		l1--
+	}
+	first, err := w.buffer.GetLine(l0, 0)
+	if err != nil {
+		return err
+	}
+	last, err := w.buffer.GetLine(l1, 0)
+	if err != nil {
+		return err
+	}
+	c0 := leadingSpace(first.Runes)
+	c1 := last.Len()
+	for c1 > 0 && unicode.IsSpace(last.Runes[c1-1]) {
+		c1--
+	}
+	open, close := []rune(delims[0]), []rune(delims[1])
+	text := string(first.Runes[c0:])
+	end := string(last.Runes[:c1])
+	if strings.HasPrefix(text, delims[0]) && strings.HasSuffix(end, delims[1]) && (l0 < l1 || c1-c0 >= len(open)+len(close)) {
+		n := len(close)
+		if c1-n > 0 && last.Runes[c1-n-1] == ' ' && (l0 < l1 || c1-n-1 >= c0+len(open)) {
+			n++
+		}
+		if err := w.deleteInLine(l1, c1-n, n); err != nil {
+			return err
+		}
+		n = len(open)
+		if line, _ := w.buffer.GetLine(l0, 0); c0+n < line.Len() && line.Runes[c0+n] == ' ' {
+			n++
+		}
+		return w.deleteInLine(l0, c0, n)
+	}
+	if err := w.insertInLine(l1, c1, " "+delims[1]); err != nil {
+		return err
+	}
+	return w.insertInLine(l0, c0, delims[0]+" ")
+}
+
+func (w *Window) blankLine(l int) bool {
+	line, _ := w.buffer.GetLine(l, 0)
+	return leadingSpace(line.Runes) == line.Len()
+}
+
+// insertInLine inserts s at (l, c), moving the cursor if it is after it.
+func (w *Window) insertInLine(l, c int, s string) error {
+	if _, _, err := w.buffer.InsertString(s, l, c); err != nil {
+		return err
+	}
+	if w.l == l && w.c >= c {
+		w.c += len([]rune(s))
+	}
+	return nil
+}
+
+// deleteInLine deletes n runes at (l, c), moving the cursor if it is after
+// them.
+func (w *Window) deleteInLine(l, c, n int) error {
+	if err := w.buffer.DeleteRegion(l, c, l, c+n); err != nil {
+		return err
+	}
+	switch {
+	case w.l != l || w.c < c:
+	case w.c < c+n:
+		w.c = c
+	default:
+		w.c -= n
+	}
+	return nil
+}
+
+func leadingSpace(runes []rune) int {
+	i := 0
+	for i < len(runes) && unicode.IsSpace(runes[i]) {
+		i++
+	}
+	return i
+}
+
+// regionLines returns the lines of the highlighted region, or the cursor line
+// if there is none.  A region ending at the start of a line does not include
+// it.
+func (w *Window) regionLines() (int, int) {
+	l0, _, l1, c1, err := w.highlightedRange()
+	if err != nil {
+		return w.l, w.l
+	}
+	if c1 == 0 && l1 > l0 {
+		l1--
+	}
+	return l0, l1
+}
+
+func CmdToggleComment(w *Window) {
+	l0, l1 := w.regionLines()
+	if err := w.ToggleComment(l0, l1); err != nil {
+		w.App().ShowMessage("Cannot toggle comment: %s", err)
+		return
+	}
+	w.ClearAnchor()
+}
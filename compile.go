@@ -0,0 +1,298 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// A compile command runs in the background, e.g. "go build ./..." or "go
+// test", and its output is shown in a compilation buffer.  Lines of output
+// starting with a file:line or file:line:col location are errors: their
+// location is kept in the Meta of the line, and the error commands visit it.
+
+// compilationKind is the buffer kind of the output of compile commands.
+const compilationKind = "compilation"
+
+// DefaultCompileCommand is the command first suggested by CmdCompile.
+const DefaultCompileCommand = "go build ./..."
+
+// errorLocationPattern matches lines such as "main.go:12:5: undefined: x" or
+// "    foo_test.go:31: got 1, want 2".
+var errorLocationPattern = regexp.MustCompile(`^\s*([^\s:][^:]*):(\d+)(?::(\d+))?:`)
+
+// An ErrorLocation is a location in a file found in compiler output.  Line and
+// Col start at 0.
+type ErrorLocation struct {
+	File      string
+	Line, Col int
+}
+
+// ParseErrorLocation returns the location at the start of a line of output of
+// a command run in dir.
+func ParseErrorLocation(line, dir string) (*ErrorLocation, bool) {
+	m := errorLocationPattern.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	loc := &ErrorLocation{File: m[1]}
+	if !filepath.IsAbs(loc.File) {
+		loc.File = filepath.Join(dir, loc.File)
+	}
+	loc.Line, _ = strconv.Atoi(m[2])
+	loc.Line--
+	if m[3] != "" {
+		loc.Col, _ = strconv.Atoi(m[3])
+		loc.Col--
+	}
+	return loc, true
+}
+
+type compilation struct {
+	cmdLine string // empty for a list of locations
+	dir     string
+	buf     *FileBuffer
+	job     *Job
+	current int // line of the last error visited, -1 if none
+	errors  int
+
+	cancelled bool
+}
+
+// cancel kills the command if it is still running.
+func (c *compilation) cancel() bool {
+	if c.job == nil {
+		return false
+	}
+	c.cancelled = true
+	c.job.Cancel()
+	return true
+}
+
+func newCompilation(cmdLine, dir, title string) *compilation {
+	return &compilation{
+		cmdLine: cmdLine,
+		dir:     dir,
+		buf: &FileBuffer{
+			kind:     compilationKind,
+			readOnly: true,
+			lines:    []Line{NewLineFromString(title, nil)},
+		},
+		current: -1,
+	}
+}
+
+// addLine adds a line of output, which is an error if it starts with a
+// location.
+func (c *compilation) addLine(line string) {
+	var meta interface{}
+	if loc, ok := ParseErrorLocation(line, c.dir); ok {
+		meta = loc
+		c.errors++
+	}
+	c.buf.AppendLine(NewLineFromString(line, meta))
+}
+
+// Compile runs cmdLine in the current directory, showing its output in the
+// compilation buffer.  A compile command still running is cancelled.
+func (a *App) Compile(cmdLine string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if old := a.compilation; old != nil {
+		old.cancel()
+	}
+	c := newCompilation(cmdLine, dir, fmt.Sprintf("Compiling in %s: %s", dir, cmdLine))
+	output := func(j *Job, line string) {
+		c.addLine(line)
+	}
+	cmd := exec.Command("sh", "-c", cmdLine)
+	job, err := a.StartProcess(cmdLine, cmd, JobHandlers{
+		OnOutput: output,
+		OnError:  output,
+		OnExit: func(j *Job, err error) {
+			c.job = nil
+			var msg string
+			switch {
+			case c.cancelled:
+				msg = "Compilation cancelled"
+			case err != nil:
+				msg = fmt.Sprintf("Compilation exited with status %d", exitCode(err))
+			default:
+				msg = "Compilation finished"
+			}
+			c.buf.AppendLine(NewLineFromString("", nil))
+			c.buf.AppendLine(NewLineFromString(msg, nil))
+			if a.compilation == c {
+				a.ShowMessage("%s with %d error(s)", msg, c.errors)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	c.job = job
+	a.showCompilation(c)
+	return nil
+}
+
+// ShowLocations shows lines starting with file:line:col locations relative to
+// the current directory in the compilation buffer, so that the error commands
+// visit them.
+func (a *App) ShowLocations(title string, lines []string) {
+	dir, _ := os.Getwd()
+	if old := a.compilation; old != nil {
+		old.cancel()
+	}
+	c := newCompilation("", dir, title)
+	for _, line := range lines {
+		c.addLine(line)
+	}
+	a.showCompilation(c)
+}
+
+// showCompilation shows the buffer of c in place of the previous compilation
+// buffer, or below the focused window, keeping the focus where it is.
+func (a *App) showCompilation(c *compilation) {
+	var win *Window
+	if old := a.compilation; old != nil {
+		win = a.windowShowing(old.buf)
+	}
+	a.compilation = c
+	if win != nil {
+		win.SetBuffer(c.buf)
+		return
+	}
+	focused := a.focusedWindow
+	a.ShowBuffer(c.buf)
+	a.FocusWindow(focused)
+}
+
+// NextError visits the location of the nth error after the last one visited,
+// or before it if n < 0.
+func (a *App) NextError(n int) error {
+	c := a.compilation
+	if c == nil {
+		return errors.New("no compilation")
+	}
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	l := c.current
+	if l < 0 && step < 0 {
+		l = c.buf.LineCount()
+	}
+	found := -1
+	for ; n > 0; n-- {
+		for l += step; l >= 0 && l < c.buf.LineCount(); l += step {
+			if _, ok := c.buf.lines[l].Meta.(*ErrorLocation); ok {
+				found = l
+				break
+			}
+		}
+	}
+	if found < 0 || l != found {
+		return errors.New("no more errors")
+	}
+	return a.visitError(c, found)
+}
+
+// visitError visits the location of the error at line l of the compilation
+// buffer.
+func (a *App) visitError(c *compilation, l int) error {
+	loc, ok := c.buf.lines[l].Meta.(*ErrorLocation)
+	if !ok {
+		return errors.New("no error on this line")
+	}
+	c.current = l
+	from := a.windowShowing(c.buf)
+	if from == nil {
+		from = a.focusedWindow
+	} else {
+		from.SetCursorPos(l, 0)
+	}
+	win, err := a.VisitFile(loc.File, from)
+	if err != nil {
+		return err
+	}
+	win.SetCursorPos(loc.Line, loc.Col)
+	return nil
+}
+
+// compilationAt returns the compilation shown in w.
+func (a *App) compilationAt(w *Window) *compilation {
+	if c := a.compilation; c != nil && c.buf == w.buffer {
+		return c
+	}
+	return nil
+}
+
+func CmdCompile(w *Window) {
+	a := w.App()
+	cmdLine := DefaultCompileCommand
+	if c := a.compilation; c != nil && c.cmdLine != "" {
+		cmdLine = c.cmdLine
+	}
+	a.ReadString("Compile command: ", cmdLine, func(cmdLine string) {
+		if err := a.Compile(cmdLine); err != nil {
+			a.ShowMessage("Cannot compile: %s", err)
+		}
+	})
+}
+
+func CmdRecompile(w *Window) {
+	a := w.App()
+	if a.compilation == nil || a.compilation.cmdLine == "" {
+		CmdCompile(w)
+		return
+	}
+	if err := a.Compile(a.compilation.cmdLine); err != nil {
+		a.ShowMessage("Cannot compile: %s", err)
+	}
+}
+
+func CmdKillCompilation(w *Window) {
+	if c := w.App().compilation; c == nil || !c.cancel() {
+		w.App().ShowMessage("No compilation running")
+	}
+}
+
+func CmdNextError(w *Window, n int) {
+	if err := w.App().NextError(n); err != nil {
+		w.App().ShowMessage("%s", err)
+	}
+}
+
+func CmdPreviousError(w *Window, n int) { CmdNextError(w, -n) }
+
+func CmdGotoError(w *Window) {
+	a := w.App()
+	if c := a.compilationAt(w); c != nil {
+		if err := a.visitError(c, w.l); err != nil {
+			a.ShowMessage("%s", err)
+		}
+	}
+}
+
+// CmdCompilationMouseRelease moves the cursor like CmdMouseButtonUp, and visits
+// the error under the mouse unless a region was highlighted.
+func CmdCompilationMouseRelease(pos Position) Action {
+	return func(w *Window) {
+		CmdMouseButtonUp(pos)(w)
+		if _, _, _, _, ok := w.HighlightedRegion(); ok {
+			return
+		}
+		a := w.App()
+		if c := a.compilationAt(w); c != nil {
+			if _, ok := c.buf.lines[w.l].Meta.(*ErrorLocation); ok {
+				a.visitError(c, w.l)
+			}
+		}
+	}
+}
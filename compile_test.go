@@ -0,0 +1,25 @@
+package edit
+
+import "testing"
+
+func TestParseErrorLocation(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		want *ErrorLocation
+	}{
+		{"main.go:12:5: undefined: x", &ErrorLocation{"/d/main.go", 11, 4}},
+		{"./x/a.go:1:1: bad", &ErrorLocation{"/d/x/a.go", 0, 0}},
+		{"    foo_test.go:31: got 1, want 2", &ErrorLocation{"/d/foo_test.go", 30, 0}},
+		{"/abs/b.c:7:2: warning", &ErrorLocation{"/abs/b.c", 6, 1}},
+		{"# example.com/pkg", nil},
+		{"ok  \texample.com/pkg\t0.1s", nil},
+		{"main.go: no line", nil},
+		{":3:4: no file", nil},
+		{"", nil},
+	} {
+		loc, ok := ParseErrorLocation(test.line, "/d")
+		if ok != (test.want != nil) || ok && *loc != *test.want {
+			t.Errorf("%q: got %+v, %t want %+v", test.line, loc, ok, test.want)
+		}
+	}
+}
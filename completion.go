@@ -0,0 +1,516 @@
+package edit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Completion asks the completion sources for candidates to complete the text
+// before the cursor, and shows them in a popup below the cursor.  The popup is
+// filtered as the user types, and closed when the cursor leaves the completed
+// text.  While it is shown, the "completion" keymap comes first, so that keys
+// to select and insert a candidate can be bound.
+
+// completionKeymap is the keymap consulted first while the popup is shown.
+const completionKeymap = "completion"
+
+// maxCompletionRows is the maximum height of the popup.
+const maxCompletionRows = 10
+
+// A Completion is a candidate text to replace the text of the cursor line from
+// column Start up to the cursor.
+type Completion struct {
+	Text   string
+	Detail string // shown next to the text, e.g. a type or the source
+	Start  int
+}
+
+// A CompletionRequest is passed to the completion sources.
+type CompletionRequest struct {
+	Window    *Window
+	Buffer    Buffer
+	Line, Col int // position of the cursor
+	WordStart int // column of the start of the word before the cursor
+}
+
+// Before returns the text of the line before the cursor.
+func (r *CompletionRequest) Before() string {
+	line, _ := r.Buffer.GetLine(r.Line, 0)
+	if r.Col > line.Len() {
+		return line.String()
+	}
+	return string(line.Runes[:r.Col])
+}
+
+// A CompletionSource finds candidates for a request.  It must call done once,
+// possibly later from the event loop, e.g. when a language server replies.
+type CompletionSource func(req *CompletionRequest, done func([]Completion))
+
+type namedCompletionSource struct {
+	name   string
+	source CompletionSource
+}
+
+// AddCompletionSource adds a source of completions, replacing the source with
+// the same name.  Candidates from sources added first come first when they
+// match equally well.
+func (a *App) AddCompletionSource(name string, source CompletionSource) {
+	for i, s := range a.completionSources {
+		if s.name == name {
+			a.completionSources[i].source = source
+			return
+		}
+	}
+	a.completionSources = append(a.completionSources, namedCompletionSource{name, source})
+}
+
+// RemoveCompletionSource removes the named source of completions.
+func (a *App) RemoveCompletionSource(name string) {
+	for i, s := range a.completionSources {
+		if s.name == name {
+			a.completionSources = append(a.completionSources[:i:i], a.completionSources[i+1:]...)
+			return
+		}
+	}
+}
+
+func (a *App) addDefaultCompletionSources() {
+	a.AddCompletionSource("lsp", a.lspCompletions)
+	a.AddCompletionSource("files", fileCompletions)
+	a.AddCompletionSource("words", a.wordCompletions)
+}
+
+type completionPopup struct {
+	win      *Window
+	line     int
+	start    int          // smallest Start of the candidates
+	items    []Completion // all the candidates
+	shown    []Completion // the candidates matching the text typed so far
+	selected int
+	top      int // index of the first candidate shown
+	pending  int // number of sources which have not answered yet
+
+	col   int  // cursor column when the candidates were last filtered
+	dirty bool // candidates were added since
+}
+
+// Complete shows the completions of the text before the cursor of w.
+func (a *App) Complete(w *Window) {
+	line, _ := w.buffer.GetLine(w.l, 0)
+	req := &CompletionRequest{
+		Window:    w,
+		Buffer:    w.buffer,
+		Line:      w.l,
+		Col:       w.c,
+		WordStart: wordStart(line.Runes, w.c),
+	}
+	p := &completionPopup{
+		win:     w,
+		line:    w.l,
+		start:   w.c,
+		pending: len(a.completionSources),
+		col:     -1,
+	}
+	a.completion = p
+	for _, s := range a.completionSources {
+		s.source(req, func(items []Completion) {
+			if a.completion != p {
+				return
+			}
+			p.pending--
+			for _, item := range items {
+				if item.Start < 0 || item.Start > req.Col {
+					item.Start = req.WordStart
+				}
+				if item.Start < p.start {
+					p.start = item.Start
+				}
+				p.items = append(p.items, item)
+			}
+			p.dirty = true
+			a.updateCompletion()
+		})
+	}
+}
+
+// wordStart returns the column of the start of the word ending at column c.
+func wordStart(runes []rune, c int) int {
+	if c > len(runes) {
+		c = len(runes)
+	}
+	for c > 0 && isWordRune(runes[c-1]) {
+		c--
+	}
+	return c
+}
+
+// updateCompletion filters the candidates with the text typed so far, or
+// closes the popup if the cursor has left the completed text.
+func (a *App) updateCompletion() {
+	p := a.completion
+	if p == nil {
+		return
+	}
+	w := p.win
+	if w != a.focusedWindow || w.l != p.line || w.c < p.start {
+		a.completion = nil
+		return
+	}
+	if w.c == p.col && !p.dirty {
+		return
+	}
+	p.col, p.dirty = w.c, false
+	line, _ := w.buffer.GetLine(w.l, 0)
+	type scored struct {
+		Completion
+		score int
+	}
+	var matches []scored
+	seen := map[string]bool{}
+	for _, item := range p.items {
+		if seen[item.Text] || item.Start > w.c || w.c > line.Len() {
+			continue
+		}
+		typed := string(line.Runes[item.Start:w.c])
+		if item.Text == typed {
+			continue
+		}
+		if score, ok := fuzzyMatch(typed, item.Text); ok {
+			seen[item.Text] = true
+			matches = append(matches, scored{item, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	p.shown = p.shown[:0]
+	for _, m := range matches {
+		p.shown = append(p.shown, m.Completion)
+	}
+	if len(p.shown) == 0 && p.pending == 0 {
+		a.completion = nil
+		if len(p.items) == 0 {
+			a.ShowMessage("No completions")
+		}
+		return
+	}
+	p.selected, p.top = 0, 0
+}
+
+// fuzzyMatch returns true if the runes of pattern appear in s in the same
+// order, ignoring case, and a score which is higher for matches at the start
+// of s or of words, and for consecutive runes.
+func fuzzyMatch(pattern, s string) (int, bool) {
+	return fuzzyMatchRunes([]rune(strings.ToLower(pattern)), s)
+}
+
+// fuzzyMatchRunes is like fuzzyMatch with the lower case runes of the pattern.
+func fuzzyMatchRunes(pat []rune, s string) (int, bool) {
+	score, i, j, prev := 0, 0, 0, -2
+	var last rune // the rune before r
+	for _, r := range s {
+		if i == len(pat) {
+			break
+		}
+		if unicode.ToLower(r) == pat[i] {
+			switch {
+			case j == 0:
+				score += 4
+			case j == prev+1:
+				score += 3
+			case !isWordRune(last) || last == '_' || unicode.IsUpper(r) && unicode.IsLower(last):
+				score += 2
+			default:
+				score++
+			}
+			prev = j
+			i++
+		}
+		last = r
+		j++
+	}
+	if i < len(pat) {
+		return 0, false
+	}
+	return score, true
+}
+
+func (a *App) moveCompletionSelection(n int) {
+	p := a.completion
+	if p == nil || len(p.shown) == 0 {
+		return
+	}
+	p.selected = (p.selected + n) % len(p.shown)
+	if p.selected < 0 {
+		p.selected += len(p.shown)
+	}
+	if p.selected < p.top {
+		p.top = p.selected
+	} else if p.selected >= p.top+maxCompletionRows {
+		p.top = p.selected - maxCompletionRows + 1
+	}
+}
+
+// acceptCompletion replaces the completed text with the selected candidate.
+func (a *App) acceptCompletion() {
+	p := a.completion
+	a.completion = nil
+	if p == nil || p.selected >= len(p.shown) {
+		return
+	}
+	item := p.shown[p.selected]
+	w := p.win
+	l, c, err := ReplaceRegion(w.buffer, w.l, item.Start, w.l, w.c, item.Text)
+	if err != nil {
+		a.ShowMessage("Cannot complete: %s", err)
+		return
+	}
+	w.SetCursorPos(l, c)
+}
+
+// drawCompletion draws the popup below the cursor, or above it if there is
+// not enough room below.
+func (a *App) drawCompletion(screen ScreenWriter) {
+	p := a.completion
+	if p == nil || len(p.shown) == 0 {
+		return
+	}
+	w := p.win
+	rows := len(p.shown) - p.top
+	if rows > maxCompletionRows {
+		rows = maxCompletionRows
+	}
+	textW, detailW := 0, 0
+	for _, item := range p.shown[p.top : p.top+rows] {
+		if n := len([]rune(item.Text)); n > textW {
+			textW = n
+		}
+		if n := len([]rune(item.Detail)); n > detailW {
+			detailW = n
+		}
+	}
+	width := textW + 2
+	if detailW > 0 {
+		width += detailW + 2
+	}
+	sz := screen.Size()
+	if width > sz.W {
+		width = sz.W
+	}
+	line, _ := w.buffer.GetLine(p.line, 0)
+	x := w.gutterWidth() + w.getPrinter().LineCol(line, p.start) - 1
+	if x+width > sz.W {
+		x = sz.W - width
+	}
+	if x < 0 {
+		x = 0
+	}
+	y := w.top + w.lineRow(p.line) + 1
+	if y+rows > sz.H && y-1-rows >= 0 {
+		y = y - 1 - rows
+	}
+	popup := screen.SubScreen(Rectangle{
+		Position: Position{X: x, Y: y},
+		Size:     Size{W: width, H: rows},
+	})
+	for i, item := range p.shown[p.top : p.top+rows] {
+		style := DefaultStyle.Reverse(true)
+		if p.top+i == p.selected {
+			style = DefaultStyle.Bold(true)
+		}
+		for x := 0; x < width; x++ {
+			popup.SetRune(Position{X: x, Y: i}, ' ', style)
+		}
+		WriteString(popup, Position{X: 1, Y: i}, item.Text, style)
+		if item.Detail != "" {
+			WriteString(popup, Position{X: textW + 3, Y: i}, item.Detail, style.Dim(true))
+		}
+	}
+}
+
+//
+// Sources
+//
+
+// maxCompletionWords limits the number of words collected from buffers.
+const maxCompletionWords = 5000
+
+// wordCompletions completes words found in the open buffers, the buffer of the
+// window first.
+func (a *App) wordCompletions(req *CompletionRequest, done func([]Completion)) {
+	buffers := []Buffer{req.Buffer}
+	for _, win := range a.windows {
+		buffers = append(buffers, win.buffer)
+	}
+	for buf := range a.openBuffers {
+		buffers = append(buffers, buf)
+	}
+	seen := map[Buffer]bool{}
+	words := map[string]bool{}
+	var items []Completion
+	for _, buf := range buffers {
+		if seen[buf] || buf.Kind() == consoleKind {
+			continue
+		}
+		seen[buf] = true
+		for l := 0; l < buf.LineCount() && len(items) < maxCompletionWords; l++ {
+			line, _ := buf.GetLine(l, 0)
+			runes := line.Runes
+			for c := 0; c < len(runes); {
+				if !isWordRune(runes[c]) {
+					c++
+					continue
+				}
+				c0 := c
+				for c < len(runes) && isWordRune(runes[c]) {
+					c++
+				}
+				if buf == req.Buffer && l == req.Line && c0 == req.WordStart {
+					continue
+				}
+				word := string(runes[c0:c])
+				if c-c0 > 1 && !words[word] {
+					words[word] = true
+					items = append(items, Completion{Text: word, Start: req.WordStart})
+				}
+			}
+		}
+	}
+	done(items)
+}
+
+// fileCompletions completes the path before the cursor with the names in its
+// directory, if it contains a slash.
+func fileCompletions(req *CompletionRequest, done func([]Completion)) {
+	before := []rune(req.Before())
+	start := len(before)
+	for start > 0 && !unicode.IsSpace(before[start-1]) && !strings.ContainsRune(`"'()<>=,`, before[start-1]) {
+		start--
+	}
+	path := string(before[start:])
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		done(nil)
+		return
+	}
+	dir := path[:i+1]
+	readDir := dir
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			readDir = filepath.Join(home, dir[2:])
+		}
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		done(nil)
+		return
+	}
+	var items []Completion
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(path[i+1:], ".") {
+			continue
+		}
+		detail := "file"
+		if e.IsDir() {
+			name += "/"
+			detail = "dir"
+		}
+		items = append(items, Completion{Text: dir + name, Detail: detail, Start: start})
+	}
+	done(items)
+}
+
+// lspCompletions asks the language server of the buffer for completions.
+func (a *App) lspCompletions(req *CompletionRequest, done func([]Completion)) {
+	c := a.lspClient(req.Buffer)
+	if c == nil {
+		done(nil)
+		return
+	}
+	fb := req.Buffer.(*FileBuffer)
+	c.openDocument(fb)
+	params := lspPositionParams{
+		TextDocument: lspTextDocument{URI: c.docs[fb].uri},
+		Position:     lspPos(fb, req.Line, req.Col),
+	}
+	c.call("textDocument/completion", params, func(result json.RawMessage, err error) {
+		if err != nil {
+			done(nil)
+			return
+		}
+		type item struct {
+			Label      string       `json:"label"`
+			Detail     string       `json:"detail"`
+			InsertText string       `json:"insertText"`
+			TextEdit   *lspTextEdit `json:"textEdit"`
+		}
+		var list struct {
+			Items []item `json:"items"`
+		}
+		if json.Unmarshal(result, &list.Items) != nil {
+			json.Unmarshal(result, &list)
+		}
+		var items []Completion
+		for _, it := range list.Items {
+			comp := Completion{Text: it.Label, Detail: it.Detail, Start: req.WordStart}
+			switch {
+			case it.TextEdit != nil && it.TextEdit.Range.Start.Line == req.Line:
+				comp.Text = it.TextEdit.NewText
+				_, comp.Start = bufferPos(fb, it.TextEdit.Range.Start)
+			case it.InsertText != "":
+				comp.Text = it.InsertText
+			}
+			items = append(items, comp)
+		}
+		done(items)
+	})
+}
+
+// AddLuaCompletionSource adds a source of completions calling the Lua function
+// f with the window and the word before the cursor.
+func (a *App) AddLuaCompletionSource(name string, f rt.Value) {
+	call := a.luaCaller(f)
+	a.AddCompletionSource(name, func(req *CompletionRequest, done func([]Completion)) {
+		before := []rune(req.Before())
+		prefix := string(before[req.WordStart:])
+		res, err := call(a.LuaWindow(req.Window), rt.StringValue(prefix))
+		if err != nil {
+			a.Logf("Completion source %s: %s", name, err)
+			done(nil)
+			return
+		}
+		list, _ := res.TryTable()
+		if list == nil {
+			done(nil)
+			return
+		}
+		var items []Completion
+		for i := int64(1); i <= list.Len(); i++ {
+			v := list.Get(rt.IntValue(i))
+			if s, ok := v.TryString(); ok {
+				items = append(items, Completion{Text: s, Start: req.WordStart})
+			} else if t, ok := v.TryTable(); ok {
+				text, _ := t.Get(rt.StringValue("text")).TryString()
+				detail, _ := t.Get(rt.StringValue("detail")).TryString()
+				if text != "" {
+					items = append(items, Completion{Text: text, Detail: detail, Start: req.WordStart})
+				}
+			}
+		}
+		done(items)
+	})
+}
+
+func CmdComplete(w *Window) { w.App().Complete(w) }
+
+func CmdCompletionNext(w *Window, n int)     { w.App().moveCompletionSelection(n) }
+func CmdCompletionPrevious(w *Window, n int) { w.App().moveCompletionSelection(-n) }
+func CmdCompletionAccept(w *Window)          { w.App().acceptCompletion() }
+func CmdCompletionCancel(w *Window)          { w.App().completion = nil }
@@ -0,0 +1,327 @@
+package edit
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// The configuration directory contains init.lua, which is run first, and a
+// plugins directory.  A plugin is either plugins/<name>.lua or
+// plugins/<name>/init.lua, and is loaded with require(<name>) after init.lua.
+// Like init.lua, a plugin may return a function, which is then called with the
+// App.  A plugin failing to load is reported in the log and does not prevent
+// the others from loading.
+//
+// init.lua can restrict the plugins which are loaded with
+// app:enable_plugins{...} and app:disable_plugins{...}.
+
+// ConfigDir returns the configuration directory: $XDG_CONFIG_HOME/edit, or
+// ~/.config/edit if XDG_CONFIG_HOME is not set.
+func ConfigDir() string {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "edit")
+}
+
+// configSnapshot records the state of the App before the configuration is
+// loaded, so that it can be restored when the configuration is reloaded.
+type configSnapshot struct {
+	states   map[*EventHandler]map[string]stateDef
+	commands map[string]*Command
+	hooks    map[string][]hook
+	loaded   map[string]bool // modules in package.loaded
+
+	completionSources []namedCompletionSource
+	snippets          map[string]map[string]Snippet
+	syntaxes          map[string]*Syntax
+	foldProviders     map[string]FoldProvider
+	languageServers   map[string]*LanguageServer
+	fileKinds         map[string]string
+}
+
+func (a *App) takeConfigSnapshot() *configSnapshot {
+	snap := &configSnapshot{
+		states:   map[*EventHandler]map[string]stateDef{},
+		commands: map[string]*Command{},
+		hooks:    map[string][]hook{},
+		loaded:   map[string]bool{},
+	}
+	for _, h := range a.allEventHandlers() {
+		snap.states[h] = copyStates(h.states)
+	}
+	for name, cmd := range a.commands {
+		snap.commands[name] = cmd
+	}
+	for name, hooks := range a.hooks {
+		snap.hooks[name] = append([]hook(nil), hooks...)
+	}
+	for _, name := range a.loadedModules() {
+		snap.loaded[name] = true
+	}
+	snap.completionSources = append([]namedCompletionSource(nil), a.completionSources...)
+	snap.snippets = copySnippets(a.snippets)
+	snap.syntaxes = map[string]*Syntax{}
+	for kind, syntax := range a.syntaxes {
+		snap.syntaxes[kind] = syntax
+	}
+	snap.foldProviders = map[string]FoldProvider{}
+	for kind, p := range a.foldProviders {
+		snap.foldProviders[kind] = p
+	}
+	snap.languageServers = map[string]*LanguageServer{}
+	for kind, ls := range a.languageServers {
+		snap.languageServers[kind] = ls
+	}
+	snap.fileKinds = map[string]string{}
+	for ext, kind := range a.fileKinds {
+		snap.fileKinds[ext] = kind
+	}
+	return snap
+}
+
+func (a *App) restoreConfigSnapshot(snap *configSnapshot) {
+	for _, h := range a.allEventHandlers() {
+		h.Reset()
+		h.shadowed = nil
+		h.states = copyStates(snap.states[h])
+	}
+	a.commands = map[string]*Command{}
+	for name, cmd := range snap.commands {
+		a.commands[name] = cmd
+	}
+	a.hooks = map[string][]hook{}
+	for name, hooks := range snap.hooks {
+		a.hooks[name] = append([]hook(nil), hooks...)
+	}
+	loaded := a.packageTable().Get(rt.StringValue("loaded")).AsTable()
+	for _, name := range a.loadedModules() {
+		if !snap.loaded[name] {
+			loaded.Set(rt.StringValue(name), rt.NilValue)
+		}
+	}
+	// The vi bindings are registered lazily, maybe after the snapshot was
+	// taken.
+	if _, ok := snap.states[a.GetEventHandler(modeHandlerName("normal"))]; !ok && a.viBindingsRegistered {
+		a.registerViBindings()
+	}
+	a.completionSources = append([]namedCompletionSource(nil), snap.completionSources...)
+	a.snippets = copySnippets(snap.snippets)
+	a.syntaxes = map[string]*Syntax{}
+	for kind, syntax := range snap.syntaxes {
+		a.syntaxes[kind] = syntax
+	}
+	a.foldProviders = map[string]FoldProvider{}
+	for kind, p := range snap.foldProviders {
+		a.foldProviders[kind] = p
+	}
+	// Language servers started from a configuration which is going away are
+	// stopped, they are started again when needed.
+	for kind, c := range a.lspClients {
+		if a.languageServers[kind] != snap.languageServers[kind] {
+			c.job.Cancel()
+		}
+	}
+	a.languageServers = map[string]*LanguageServer{}
+	for kind, ls := range snap.languageServers {
+		a.languageServers[kind] = ls
+	}
+	a.fileKinds = map[string]string{}
+	for ext, kind := range snap.fileKinds {
+		a.fileKinds[ext] = kind
+	}
+}
+
+func copySnippets(snippets map[string]map[string]Snippet) map[string]map[string]Snippet {
+	res := make(map[string]map[string]Snippet, len(snippets))
+	for kind, byTrigger := range snippets {
+		res[kind] = make(map[string]Snippet, len(byTrigger))
+		for trigger, s := range byTrigger {
+			res[kind][trigger] = s
+		}
+	}
+	return res
+}
+
+func (a *App) allEventHandlers() []*EventHandler {
+	var handlers []*EventHandler
+	for _, h := range a.eventHandlers {
+		handlers = append(handlers, h)
+	}
+	for _, h := range a.bufferEventHandlers {
+		handlers = append(handlers, h)
+	}
+	return handlers
+}
+
+func copyStates(states map[string]stateDef) map[string]stateDef {
+	res := make(map[string]stateDef, len(states))
+	for s, sDef := range states {
+		res[s] = copyStateDef(sDef)
+	}
+	return res
+}
+
+func (a *App) packageTable() *rt.Table {
+	return a.lua.Registry(rt.StringValue("package")).AsTable()
+}
+
+func (a *App) loadedModules() []string {
+	var names []string
+	loaded := a.packageTable().Get(rt.StringValue("loaded")).AsTable()
+	for k, _, ok := loaded.Next(rt.NilValue); ok && !k.IsNil(); k, _, ok = loaded.Next(k) {
+		if name, ok := k.TryString(); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// setPackagePath makes require find modules in the configuration directory and
+// its plugins directory before the default locations.
+func (a *App) setPackagePath(dir string) {
+	pkg := a.packageTable()
+	path, _ := pkg.Get(rt.StringValue("path")).TryString()
+	if a.defaultPackagePath == "" {
+		a.defaultPackagePath = path
+	}
+	plugins := filepath.Join(dir, "plugins")
+	path = strings.Join([]string{
+		filepath.Join(dir, "?.lua"),
+		filepath.Join(dir, "?", "init.lua"),
+		filepath.Join(plugins, "?.lua"),
+		filepath.Join(plugins, "?", "init.lua"),
+		a.defaultPackagePath,
+	}, ";")
+	pkg.Set(rt.StringValue("path"), rt.StringValue(path))
+}
+
+// LoadConfig loads init.lua and the plugins from the configuration directory
+// dir.  It does nothing if dir does not exist.  Errors are logged, and the
+// last one is returned.
+func (a *App) LoadConfig(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	if a.configSnapshot == nil {
+		a.configSnapshot = a.takeConfigSnapshot()
+	}
+	a.configDir = dir
+	a.setPackagePath(dir)
+	lastErr := a.loadSnippetFiles(dir)
+	initFile := filepath.Join(dir, "init.lua")
+	if _, err := os.Stat(initFile); err == nil {
+		if err := a.InitLuaFile(initFile); err != nil {
+			lastErr = err
+		}
+	}
+	for _, name := range a.Plugins() {
+		if !a.pluginEnabled(name) {
+			continue
+		}
+		if err := a.loadPlugin(name); err != nil {
+			a.Logf("Error loading plugin %s: %s", name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// ReloadConfig restores the keymaps, commands, hooks and other registrations
+// to what they were before the configuration was loaded, and stops the timers
+// and jobs started from Lua, then loads the configuration again.
+func (a *App) ReloadConfig() error {
+	if a.configSnapshot == nil {
+		return a.LoadConfig(ConfigDir())
+	}
+	a.stopLuaTasks()
+	a.restoreConfigSnapshot(a.configSnapshot)
+	a.enabledPlugins = nil
+	a.disabledPlugins = nil
+	a.trustedPlugins = map[string]bool{}
+	a.Logf("Reloading configuration from %s", a.configDir)
+	return a.LoadConfig(a.configDir)
+}
+
+// Plugins returns the names of the plugins found in the configuration
+// directory, sorted.
+func (a *App) Plugins() []string {
+	if a.configDir == "" {
+		return nil
+	}
+	dir := filepath.Join(a.configDir, "plugins")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case e.IsDir():
+			if _, err := os.Stat(filepath.Join(dir, name, "init.lua")); err == nil {
+				names = append(names, name)
+			}
+		case strings.HasSuffix(name, ".lua"):
+			names = append(names, strings.TrimSuffix(name, ".lua"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetEnabledPlugins restricts the plugins loaded to the ones named.  A nil
+// list allows all plugins.
+func (a *App) SetEnabledPlugins(names []string) {
+	a.enabledPlugins = nil
+	if names != nil {
+		a.enabledPlugins = map[string]bool{}
+		for _, name := range names {
+			a.enabledPlugins[name] = true
+		}
+	}
+}
+
+// SetDisabledPlugins prevents the named plugins from being loaded.
+func (a *App) SetDisabledPlugins(names []string) {
+	a.disabledPlugins = map[string]bool{}
+	for _, name := range names {
+		a.disabledPlugins[name] = true
+	}
+}
+
+func (a *App) pluginEnabled(name string) bool {
+	if a.disabledPlugins[name] {
+		return false
+	}
+	return a.enabledPlugins == nil || a.enabledPlugins[name]
+}
+
+// loadPlugin requires the plugin module and, if it returns a function, calls
+// it with the App.  In safe mode, untrusted plugins are loaded in a sandbox.
+func (a *App) loadPlugin(name string) error {
+	if a.pluginSandboxed(name) {
+		return a.loadSandboxedPlugin(name)
+	}
+	require := a.lua.GlobalEnv().Get(rt.StringValue("require"))
+	mod, err := a.callLua(require, rt.StringValue(name))
+	if err != nil {
+		return err
+	}
+	if _, ok := mod.TryCallable(); ok {
+		if _, err := a.callLua(mod, a.LuaApp()); err != nil {
+			return err
+		}
+	}
+	a.Logf("Loaded plugin %s", name)
+	return nil
+}
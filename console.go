@@ -0,0 +1,241 @@
+package edit
+
+import (
+	"strings"
+
+	"github.com/arnodel/golua/lib/base"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// The log window shows a Lua console: log messages are printed above a prompt
+// where Lua code can be typed.  Pressing Enter runs the input against the live
+// runtime and prints the results (or the error with its traceback) below it.
+// Input which is not a complete chunk continues on the next line.
+
+// consoleKind is the buffer kind of the Lua console.
+const consoleKind = "lua-console"
+
+const (
+	consolePrompt     = "> "
+	consoleContPrompt = ">> "
+)
+
+type luaConsole struct {
+	app       *App
+	win       *Window
+	buf       *FileBuffer
+	inputLine int // line of the first prompt of the current input
+
+	history    []string
+	historyPos int // index in history of the input shown, len(history) if none
+
+	partial strings.Builder // output not yet terminated by a newline
+}
+
+func newLuaConsole(app *App, win *Window, buf *FileBuffer) *luaConsole {
+	c := &luaConsole{app: app, win: win, buf: buf}
+	buf.lines = []Line{NewLineFromString(consolePrompt, nil)}
+	win.SetCursorPos(0, len(consolePrompt))
+	return c
+}
+
+// output prints a line above the prompt.
+func (c *luaConsole) output(msg string) {
+	for _, s := range splitString(msg) {
+		if c.inputLine >= c.buf.LineCount() {
+			c.buf.AppendLine(NewLineFromString(s, nil))
+		} else {
+			c.buf.InsertLine(c.inputLine, NewLineFromString(s, nil))
+			if c.win.l >= c.inputLine {
+				c.win.l++
+			}
+		}
+		c.inputLine++
+	}
+}
+
+// Write prints the text written by Lua code, e.g. with print.
+func (c *luaConsole) Write(p []byte) (int, error) {
+	c.partial.Write(p)
+	text := c.partial.String()
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		c.output(text[:i])
+		c.partial.Reset()
+		c.partial.WriteString(text[i+1:])
+	}
+	return len(p), nil
+}
+
+// input returns the text of the current input, without the prompts.
+func (c *luaConsole) input() string {
+	return c.inputFrom(c.inputLine, c.buf.LineCount())
+}
+
+// inputFrom returns the text of the lines from l0 up to l1, without the
+// prompts.
+func (c *luaConsole) inputFrom(l0, l1 int) string {
+	var lines []string
+	for l := l0; l < l1; l++ {
+		lines = append(lines, stripPrompt(c.buf.lines[l].String()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func stripPrompt(s string) string {
+	if strings.HasPrefix(s, consoleContPrompt) {
+		return s[len(consoleContPrompt):]
+	}
+	return strings.TrimPrefix(s, consolePrompt)
+}
+
+// setInput replaces the current input with src.
+func (c *luaConsole) setInput(src string) {
+	c.buf.Truncate(c.inputLine)
+	for i, s := range strings.Split(src, "\n") {
+		prompt := consolePrompt
+		if i > 0 {
+			prompt = consoleContPrompt
+		}
+		c.buf.AppendLine(NewLineFromString(prompt+s, nil))
+	}
+	c.win.MoveCursorToEnd()
+}
+
+// newPrompt starts a new input below the output.
+func (c *luaConsole) newPrompt() {
+	c.buf.AppendLine(NewLineFromString(consolePrompt, nil))
+	c.inputLine = c.buf.LineCount() - 1
+	c.historyPos = len(c.history)
+	c.win.MoveCursorToEnd()
+}
+
+// submit runs the current input, or continues it on a new line if it is not
+// complete.  On a line of a previous input, it copies that input to the
+// prompt instead.
+func (c *luaConsole) submit() {
+	if c.win.l < c.inputLine {
+		if line := c.buf.lines[c.win.l].String(); strings.HasPrefix(line, consolePrompt) {
+			c.setInput(stripPrompt(line))
+		}
+		return
+	}
+	src := c.input()
+	if strings.TrimSpace(src) == "" {
+		c.newPrompt()
+		return
+	}
+	clos, err := c.compile(src)
+	if err != nil {
+		if snErr, ok := err.(*rt.SyntaxError); ok && snErr.IsUnexpectedEOF() {
+			c.continueInput()
+			return
+		}
+	}
+	c.history = append(c.history, src)
+	c.inputLine = c.buf.LineCount()
+	if err != nil {
+		c.output(err.Error())
+	} else {
+		c.run(clos)
+	}
+	c.newPrompt()
+}
+
+// continueInput adds a line to the current input.
+func (c *luaConsole) continueInput() {
+	c.buf.AppendLine(NewLineFromString(consoleContPrompt, nil))
+	c.win.MoveCursorToEnd()
+}
+
+// compile compiles src as an expression if possible, otherwise as a chunk.
+func (c *luaConsole) compile(src string) (*rt.Closure, error) {
+	r := c.app.lua
+	env := rt.TableValue(r.GlobalEnv())
+	if clos, err := r.CompileAndLoadLuaChunk("console", []byte("return "+src), env); err == nil {
+		return clos, nil
+	}
+	return r.CompileAndLoadLuaChunk("console", []byte(src), env)
+}
+
+func (c *luaConsole) run(clos *rt.Closure) {
+	term := rt.NewTerminationWith(nil, 0, true)
+	err := c.app.callLuaContext(func(t *rt.Thread) *rt.Error {
+		return rt.Call(t, rt.FunctionValue(clos), nil, term)
+	})
+	if err != nil {
+		c.output(err.Error())
+		return
+	}
+	t := c.app.lua.MainThread()
+	var results []string
+	for _, v := range term.Etc() {
+		s, err := base.ToString(t, v)
+		if err != nil {
+			s = err.Error()
+		}
+		results = append(results, s)
+	}
+	if len(results) > 0 {
+		c.output(strings.Join(results, "\t"))
+	}
+}
+
+// historyMove replaces the input with an older (dir < 0) or newer (dir > 0)
+// input from the history.
+func (c *luaConsole) historyMove(dir int) {
+	pos := c.historyPos + dir
+	switch {
+	case pos < 0 || pos > len(c.history):
+		return
+	case pos == len(c.history):
+		c.setInput("")
+	default:
+		c.setInput(c.history[pos])
+	}
+	c.historyPos = pos
+}
+
+// ShowConsole shows the Lua console window below the focused window, or
+// focuses it if it is already shown.
+func (a *App) ShowConsole() {
+	if a.windowIndex(a.logWindow) < 0 {
+		a.insertWindow(a.focusedWindow, a.logWindow)
+	}
+	a.FocusWindow(a.logWindow)
+}
+
+func consoleAction(f func(c *luaConsole)) ActionMaker {
+	return SimpleActionMaker(func(w *Window) {
+		if c := w.App().console; c != nil && c.win == w {
+			f(c)
+		}
+	})
+}
+
+var consoleCommands = []*Command{
+	{
+		Name:        "lua-console",
+		Description: "Show the Lua console",
+		ActionMaker: SimpleActionMaker(func(w *Window) { w.App().ShowConsole() }),
+	},
+	{
+		Name:        "console-submit",
+		Description: "Run the Lua input, or continue it if it is incomplete",
+		ActionMaker: consoleAction((*luaConsole).submit),
+	},
+	{
+		Name:        "console-newline",
+		Description: "Continue the Lua input on a new line",
+		ActionMaker: consoleAction((*luaConsole).continueInput),
+	},
+	{
+		Name:        "console-history-previous",
+		Description: "Replace the Lua input with the previous one in the history",
+		ActionMaker: consoleAction(func(c *luaConsole) { c.historyMove(-1) }),
+	},
+	{
+		Name:        "console-history-next",
+		Description: "Replace the Lua input with the next one in the history",
+		ActionMaker: consoleAction(func(c *luaConsole) { c.historyMove(1) }),
+	},
+}
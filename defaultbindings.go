@@ -1,24 +1,42 @@
 package edit
 
-func CmdInsertRune(r rune) Action {
-	return func(w *Window) { w.InsertRune(r) }
+import "strings"
+
+func CmdInsertRune(r rune, n int) Action {
+	return func(w *Window) {
+		for i := 0; i < n; i++ {
+			w.InsertRune(r)
+		}
+	}
 }
 
-func CmdCursorLeft(w *Window)  { w.MoveCursor(0, -1) }
-func CmdCursorRight(w *Window) { w.MoveCursor(0, 1) }
-func CmdCursorUp(w *Window)    { w.MoveCursor(-1, 0) }
-func CmdCursorDown(w *Window)  { w.MoveCursor(1, 0) }
+func CmdCursorLeft(w *Window, n int)  { w.MoveCursor(0, -n) }
+func CmdCursorRight(w *Window, n int) { w.MoveCursor(0, n) }
+func CmdCursorUp(w *Window, n int)    { w.MoveCursor(-n, 0) }
+func CmdCursorDown(w *Window, n int)  { w.MoveCursor(n, 0) }
+
+func CmdDeletePrevRune(w *Window, n int) {
+	for i := 0; i < n; i++ {
+		if w.DeleteRune() != nil {
+			return
+		}
+	}
+}
+
+func CmdCarriageReturn(w *Window, n int) {
+	for i := 0; i < n; i++ {
+		w.SplitLine(true)
+	}
+}
 
-func CmdDeletePrevRune(w *Window)  { w.DeleteRune() }
-func CmdCarriageReturn(w *Window)  { w.SplitLine(true) }
 func CmdMoveToLineStart(w *Window) { w.MoveCursorToLineStart() }
 func CmdMoveToLineEnd(w *Window)   { w.MoveCursorToLineEnd() }
 
-func CmdPageDown(w *Window) { w.PageDown(1) }
-func CmdPageUp(w *Window)   { w.PageDown(-1) }
+func CmdPageDown(w *Window, n int) { w.PageDown(n) }
+func CmdPageUp(w *Window, n int)   { w.PageDown(-n) }
 
-func CmdScrollDown(w *Window) { w.ScrollDown(1) }
-func CmdScrollUp(w *Window)   { w.ScrollUp(1) }
+func CmdScrollDown(w *Window, n int) { w.ScrollDown(n) }
+func CmdScrollUp(w *Window, n int)   { w.ScrollUp(n) }
 
 func CmdResize(w, h int) Action {
 	return func(win *Window) { win.App().Resize(w, h) }
@@ -55,7 +73,17 @@ func CmdMouseButtonUp(pos Position) Action {
 
 func CmdQuit(w *Window) { w.App().Quit() }
 
-func CmdSaveBuffer(w *Window) { w.buffer.Save() }
+func CmdReloadConfig(w *Window) {
+	if err := w.App().ReloadConfig(); err != nil {
+		w.App().ShowMessage("Error reloading configuration: %s", err)
+	}
+}
+
+func CmdSaveBuffer(w *Window) {
+	if err := w.App().SaveBuffer(w.buffer); err != nil {
+		w.App().Logf("Error saving buffer: %s", err)
+	}
+}
 
 func CmdPasteString(s string) Action {
 	return func(w *Window) {
@@ -63,125 +91,1137 @@ func CmdPasteString(s string) Action {
 	}
 }
 
+func CmdStartMacro(w *Window) { w.App().StartMacroRecording() }
+
+func CmdStopMacro(w *Window) {
+	if err := w.App().StopMacroRecording(); err != nil {
+		w.App().Logf("Error stopping macro: %s", err)
+	}
+}
+
+func CmdRunMacro(w *Window, n int) {
+	if err := w.App().RunMacro("", n); err != nil {
+		w.App().Logf("Error running macro: %s", err)
+	}
+}
+
+func CmdRunMacroOnRegion(w *Window) {
+	if err := w.App().RunMacroOnRegion(w, ""); err != nil {
+		w.App().Logf("Error running macro: %s", err)
+	}
+}
+
+func CmdNameLastMacro(w *Window) {
+	a := w.App()
+	a.ReadString("Name for last macro: ", "", func(name string) {
+		if err := a.NameLastMacro(name); err != nil {
+			a.ShowMessage("Error naming macro: %s", err)
+		}
+	})
+}
+
+func CmdRunNamedMacro(w *Window, n int) {
+	a := w.App()
+	a.ReadString("Run macro: ", "", func(name string) {
+		if err := a.RunMacro(name, n); err != nil {
+			a.ShowMessage("Error running macro: %s", err)
+		}
+	})
+}
+
+func CmdSaveMacros(w *Window) {
+	a := w.App()
+	a.ReadString("Save macros to: ", MacroFile(), func(filename string) {
+		if err := a.SaveMacros(filename); err != nil {
+			a.ShowMessage("Error saving macros: %s", err)
+		} else {
+			a.ShowMessage("Saved macros to %s", filename)
+		}
+	})
+}
+
+func CmdLoadMacros(w *Window) {
+	a := w.App()
+	a.ReadString("Load macros from: ", MacroFile(), func(filename string) {
+		if err := a.LoadMacros(filename); err != nil {
+			a.ShowMessage("Error loading macros: %s", err)
+		}
+	})
+}
+
+func CmdToggleModalEditing(w *Window) { w.App().ToggleModalEditing() }
+
+func CmdDescribeKeyBriefly(w *Window) { w.App().DescribeKeyBriefly() }
+func CmdDescribeKey(w *Window)        { w.App().DescribeKey() }
+func CmdDescribeBindings(w *Window)   { w.App().DescribeBindings() }
+
+func CmdOtherWindow(w *Window) { w.App().OtherWindow() }
+func CmdSplitWindow(w *Window) { w.App().SplitWindow(w, w.buffer) }
+func CmdCloseWindow(w *Window) { w.App().CloseWindow(w) }
+
 func SimpleActionMaker(f Action) ActionMaker {
-	return func(args []interface{}) Action { return f }
+	return func(args []interface{}, count int) Action { return f }
 }
 
-var defaultBindings = []struct {
-	seq    string
-	action ActionMaker
-}{
+// CountActionMaker returns an ActionMaker for an action which takes a repeat
+// count.
+func CountActionMaker(f func(w *Window, n int)) ActionMaker {
+	return func(args []interface{}, count int) Action {
+		return func(w *Window) { f(w, count) }
+	}
+}
+
+var defaultCommands = []*Command{
+	{
+		Name:        "self-insert",
+		Description: "Insert the character typed",
+		Parameters:  []Parameter{{Name: "rune"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdTypeRune(args[0].(rune), count)
+		},
+	},
+	{
+		Name:        "insert-tab",
+		Description: "Insert a tab character",
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdInsertRune('\t', count)
+		},
+	},
+	{
+		Name:        "cursor-left",
+		Description: "Move the cursor left",
+		ActionMaker: CountActionMaker(CmdCursorLeft),
+	},
+	{
+		Name:        "cursor-right",
+		Description: "Move the cursor right",
+		ActionMaker: CountActionMaker(CmdCursorRight),
+	},
 	{
-		seq: "Rune.Rune",
-		action: func(args []interface{}) Action {
-			return CmdInsertRune(args[0].(rune))
+		Name:        "cursor-up",
+		Description: "Move the cursor up",
+		ActionMaker: CountActionMaker(CmdCursorUp),
+	},
+	{
+		Name:        "cursor-down",
+		Description: "Move the cursor down",
+		ActionMaker: CountActionMaker(CmdCursorDown),
+	},
+	{
+		Name:        "delete-backward",
+		Description: "Delete the character before the cursor",
+		ActionMaker: CountActionMaker(CmdDeleteBackward),
+	},
+	{
+		Name:        "newline",
+		Description: "Split the line at the cursor",
+		ActionMaker: CountActionMaker(CmdCarriageReturn),
+	},
+	{
+		Name:        "line-start",
+		Description: "Move the cursor to the start of the line",
+		ActionMaker: SimpleActionMaker(CmdMoveToLineStart),
+	},
+	{
+		Name:        "line-end",
+		Description: "Move the cursor to the end of the line",
+		ActionMaker: SimpleActionMaker(CmdMoveToLineEnd),
+	},
+	{
+		Name:        "page-down",
+		Description: "Move the cursor down by a page",
+		ActionMaker: CountActionMaker(CmdPageDown),
+	},
+	{
+		Name:        "page-up",
+		Description: "Move the cursor up by a page",
+		ActionMaker: CountActionMaker(CmdPageUp),
+	},
+	{
+		Name:        "resize",
+		Description: "Resize the application to the new screen size",
+		Parameters:  []Parameter{{Name: "size"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			size := args[0].(Size)
+			return CmdResize(size.W, size.H)
 		},
 	},
 	{
-		seq:    "Tab",
-		action: SimpleActionMaker(CmdInsertRune('\t')),
+		Name:        "mouse-press",
+		Description: "Start highlighting a region",
+		Parameters:  []Parameter{{Name: "position"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdMoveButtonDown(args[0].(Position))
+		},
 	},
 	{
-		seq:    "Left",
-		action: SimpleActionMaker(CmdCursorLeft),
+		Name:        "mouse-release",
+		Description: "Move the cursor, or copy the highlighted region to the clipboard",
+		Parameters:  []Parameter{{Name: "position"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdMouseButtonUp(args[0].(Position))
+		},
 	},
 	{
-		seq:    "Right",
-		action: SimpleActionMaker(CmdCursorRight),
+		Name:        "mouse-drag",
+		Description: "Extend the highlighted region",
+		Parameters:  []Parameter{{Name: "position"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdMouseDrag(args[0].(Position))
+		},
 	},
 	{
-		seq:    "Ctrl-F",
-		action: SimpleActionMaker(CmdCursorRight),
+		Name:        "scroll-down",
+		Description: "Scroll the window down",
+		ActionMaker: CountActionMaker(CmdScrollDown),
 	},
 	{
-		seq:    "Up",
-		action: SimpleActionMaker(CmdCursorUp),
+		Name:        "scroll-up",
+		Description: "Scroll the window up",
+		ActionMaker: CountActionMaker(CmdScrollUp),
 	},
 	{
-		seq:    "Ctrl-P",
-		action: SimpleActionMaker(CmdCursorUp),
+		Name:        "save-buffer",
+		Description: "Save the buffer to its file",
+		ActionMaker: SimpleActionMaker(CmdSaveBuffer),
 	},
 	{
-		seq:    "Down",
-		action: SimpleActionMaker(CmdCursorDown),
+		Name:        "start-macro",
+		Description: "Start recording a keyboard macro",
+		ActionMaker: SimpleActionMaker(CmdStartMacro),
 	},
 	{
-		seq:    "Ctrl-N",
-		action: SimpleActionMaker(CmdCursorDown),
+		Name:        "stop-macro",
+		Description: "Stop recording the keyboard macro",
+		ActionMaker: SimpleActionMaker(CmdStopMacro),
 	},
 	{
-		seq:    "Backspace",
-		action: SimpleActionMaker(CmdDeletePrevRune),
+		Name:        "run-macro",
+		Description: "Run the last keyboard macro",
+		ActionMaker: CountActionMaker(CmdRunMacro),
 	},
 	{
-		seq:    "Backspace2", // On MacOS
-		action: SimpleActionMaker(CmdDeletePrevRune),
+		Name:        "run-macro-on-region",
+		Description: "Run the last keyboard macro on each line of the region",
+		ActionMaker: SimpleActionMaker(CmdRunMacroOnRegion),
 	},
 	{
-		seq:    "Enter",
-		action: SimpleActionMaker(CmdCarriageReturn),
+		Name:        "toggle-minor-mode",
+		Description: "Enable a minor mode if it is disabled, disable it otherwise",
+		ActionMaker: SimpleActionMaker(CmdToggleMinorMode),
 	},
 	{
-		seq:    "Ctrl-A",
-		action: SimpleActionMaker(CmdMoveToLineStart),
+		Name:        "name-last-macro",
+		Description: "Give a name to the last keyboard macro",
+		ActionMaker: SimpleActionMaker(CmdNameLastMacro),
 	},
 	{
-		seq:    "Ctrl-E",
-		action: SimpleActionMaker(CmdMoveToLineEnd),
+		Name:        "run-named-macro",
+		Description: "Run a named keyboard macro",
+		ActionMaker: CountActionMaker(CmdRunNamedMacro),
 	},
 	{
-		seq:    "Ctrl-V",
-		action: SimpleActionMaker(CmdPageDown),
+		Name:        "save-macros",
+		Description: "Save the named keyboard macros to a file",
+		ActionMaker: SimpleActionMaker(CmdSaveMacros),
 	},
 	{
-		seq:    "Alt+Ctrl-V",
-		action: SimpleActionMaker(CmdPageUp),
+		Name:        "load-macros",
+		Description: "Load named keyboard macros from a file",
+		ActionMaker: SimpleActionMaker(CmdLoadMacros),
 	},
 	{
-		seq: "Resize.Size",
-		action: func(args []interface{}) Action {
-			size := args[0].(Size)
-			return CmdResize(size.W, size.H)
-		},
+		Name:        "toggle-modal-editing",
+		Description: "Toggle vi style modal editing",
+		ActionMaker: SimpleActionMaker(CmdToggleModalEditing),
 	},
 	{
-		seq: "MousePress-Button1.Position",
-		action: func(args []interface{}) Action {
-			return CmdMoveButtonDown(args[0].(Position))
-		},
+		Name:        "describe-key-briefly",
+		Description: "Show which keymap a key sequence is bound in",
+		ActionMaker: SimpleActionMaker(CmdDescribeKeyBriefly),
 	},
 	{
-		seq: "MouseRelease-Button1.Position",
-		action: func(args []interface{}) Action {
-			return CmdMouseButtonUp(args[0].(Position))
+		Name:        "describe-key",
+		Description: "Show the command bound to a key sequence",
+		ActionMaker: SimpleActionMaker(CmdDescribeKey),
+	},
+	{
+		Name:        "describe-bindings",
+		Description: "List all key bindings in a help buffer",
+		ActionMaker: SimpleActionMaker(CmdDescribeBindings),
+	},
+	{
+		Name:        "other-window",
+		Description: "Switch to the next window",
+		ActionMaker: SimpleActionMaker(CmdOtherWindow),
+	},
+	{
+		Name:        "split-window",
+		Description: "Split the current window in two",
+		ActionMaker: SimpleActionMaker(CmdSplitWindow),
+	},
+	{
+		Name:        "close-window",
+		Description: "Close the current window",
+		ActionMaker: SimpleActionMaker(CmdCloseWindow),
+	},
+	{
+		Name:        "undo",
+		Description: "Undo the last change to the buffer",
+		ActionMaker: SimpleActionMaker(CmdUndo),
+	},
+	{
+		Name:        "redo",
+		Description: "Redo the last change undone in the buffer",
+		ActionMaker: SimpleActionMaker(CmdRedo),
+	},
+	{
+		Name:        "shell-command",
+		Description: "Run a shell command and show its output in a new buffer",
+		ActionMaker: SimpleActionMaker(CmdShellCommand),
+	},
+	{
+		Name:        "shell-command-insert",
+		Description: "Run a shell command and insert its output at the cursor",
+		ActionMaker: SimpleActionMaker(CmdShellCommandInsert),
+	},
+	{
+		Name:        "shell-command-on-region",
+		Description: "Replace the region with the output of a shell command given the region as input",
+		ActionMaker: SimpleActionMaker(CmdShellCommandOnRegion),
+	},
+	{
+		Name:        "compile",
+		Description: "Run a compile command and show its output",
+		ActionMaker: SimpleActionMaker(CmdCompile),
+	},
+	{
+		Name:        "recompile",
+		Description: "Run the last compile command again",
+		ActionMaker: SimpleActionMaker(CmdRecompile),
+	},
+	{
+		Name:        "kill-compilation",
+		Description: "Stop the running compile command",
+		ActionMaker: SimpleActionMaker(CmdKillCompilation),
+	},
+	{
+		Name:        "next-error",
+		Description: "Visit the location of the next error in the compilation output",
+		ActionMaker: CountActionMaker(CmdNextError),
+	},
+	{
+		Name:        "previous-error",
+		Description: "Visit the location of the previous error in the compilation output",
+		ActionMaker: CountActionMaker(CmdPreviousError),
+	},
+	{
+		Name:        "compile-goto-error",
+		Description: "Visit the location of the error on the cursor line",
+		ActionMaker: SimpleActionMaker(CmdGotoError),
+	},
+	{
+		Name:        "compile-mouse-release",
+		Description: "Move the cursor and visit the location of the error under it",
+		Parameters:  []Parameter{{Name: "position"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdCompilationMouseRelease(args[0].(Position))
 		},
 	},
 	{
-		seq: "MouseDrag-Button1.Position",
-		action: func(args []interface{}) Action {
-			return CmdMouseDrag(args[0].(Position))
+		Name:        "lsp-hover",
+		Description: "Show information about the symbol at the cursor from the language server",
+		ActionMaker: SimpleActionMaker(CmdHover),
+	},
+	{
+		Name:        "lsp-definition",
+		Description: "Go to the definition of the symbol at the cursor",
+		ActionMaker: SimpleActionMaker(CmdGotoDefinition),
+	},
+	{
+		Name:        "lsp-references",
+		Description: "List the references to the symbol at the cursor",
+		ActionMaker: SimpleActionMaker(CmdFindReferences),
+	},
+	{
+		Name:        "lsp-rename",
+		Description: "Rename the symbol at the cursor everywhere",
+		ActionMaker: SimpleActionMaker(CmdRename),
+	},
+	{
+		Name:        "lsp-format",
+		Description: "Format the buffer with the language server",
+		ActionMaker: SimpleActionMaker(CmdFormatBuffer),
+	},
+	{
+		Name:        "complete",
+		Description: "Show the completions of the text before the cursor",
+		ActionMaker: SimpleActionMaker(CmdComplete),
+	},
+	{
+		Name:        "completion-next",
+		Description: "Select the next completion",
+		ActionMaker: CountActionMaker(CmdCompletionNext),
+	},
+	{
+		Name:        "completion-previous",
+		Description: "Select the previous completion",
+		ActionMaker: CountActionMaker(CmdCompletionPrevious),
+	},
+	{
+		Name:        "completion-accept",
+		Description: "Replace the completed text with the selected completion",
+		ActionMaker: SimpleActionMaker(CmdCompletionAccept),
+	},
+	{
+		Name:        "completion-cancel",
+		Description: "Close the completion popup",
+		ActionMaker: SimpleActionMaker(CmdCompletionCancel),
+	},
+	{
+		Name:        "expand-snippet-or-tab",
+		Description: "Expand the snippet triggered by the word before the cursor, or insert a tab",
+		ActionMaker: CountActionMaker(CmdSnippetExpandOrTab),
+	},
+	{
+		Name:        "snippet-next-field",
+		Description: "Move to the next field of the snippet",
+		ActionMaker: CountActionMaker(CmdSnippetNextField),
+	},
+	{
+		Name:        "snippet-previous-field",
+		Description: "Move to the previous field of the snippet",
+		ActionMaker: CountActionMaker(CmdSnippetPreviousField),
+	},
+	{
+		Name:        "snippet-exit",
+		Description: "Stop moving between the fields of the snippet",
+		ActionMaker: SimpleActionMaker(CmdSnippetExit),
+	},
+	{
+		Name:        "toggle-fold",
+		Description: "Fold or unfold the lines around the cursor",
+		ActionMaker: SimpleActionMaker(CmdToggleFold),
+	},
+	{
+		Name:        "open-all-folds",
+		Description: "Unfold all the lines of the window",
+		ActionMaker: SimpleActionMaker(CmdOpenAllFolds),
+	},
+	{
+		Name:        "close-all-folds",
+		Description: "Fold all the foldable lines of the window",
+		ActionMaker: SimpleActionMaker(CmdCloseAllFolds),
+	},
+	{
+		Name:        "auto-pair-mode",
+		Description: "Toggle inserting closing brackets and quotes as their opener is typed",
+		ActionMaker: SimpleActionMaker(CmdAutoPairMode),
+	},
+	{
+		Name:        "toggle-comment",
+		Description: "Comment or uncomment the current line or the highlighted lines",
+		ActionMaker: SimpleActionMaker(CmdToggleComment),
+	},
+	{
+		Name:        "move-lines-up",
+		Description: "Move the current line or the highlighted lines up",
+		ActionMaker: CountActionMaker(CmdMoveLinesUp),
+	},
+	{
+		Name:        "move-lines-down",
+		Description: "Move the current line or the highlighted lines down",
+		ActionMaker: CountActionMaker(CmdMoveLinesDown),
+	},
+	{
+		Name:        "duplicate-lines",
+		Description: "Copy the current line or the highlighted lines below them",
+		ActionMaker: SimpleActionMaker(CmdDuplicateLines),
+	},
+	{
+		Name:        "join-lines",
+		Description: "Join the highlighted lines, or the current line and the next",
+		ActionMaker: CountActionMaker(CmdJoinLines),
+	},
+	{
+		Name:        "join-previous-line",
+		Description: "Join the current line to the previous one",
+		ActionMaker: SimpleActionMaker(CmdJoinPreviousLine),
+	},
+	{
+		Name:        "sort-lines",
+		Description: "Sort the highlighted lines, or the buffer",
+		ActionMaker: SimpleActionMaker(CmdSortLines(LineSort{})),
+	},
+	{
+		Name:        "sort-lines-numeric",
+		Description: "Sort the highlighted lines, or the buffer, by the numbers they start with",
+		ActionMaker: SimpleActionMaker(CmdSortLines(LineSort{Numeric: true})),
+	},
+	{
+		Name:        "sort-lines-reverse",
+		Description: "Sort the highlighted lines, or the buffer, in reverse order",
+		ActionMaker: SimpleActionMaker(CmdSortLines(LineSort{Reverse: true})),
+	},
+	{
+		Name:        "sort-lines-unique",
+		Description: "Sort the highlighted lines, or the buffer, removing duplicates",
+		ActionMaker: SimpleActionMaker(CmdSortLines(LineSort{Unique: true})),
+	},
+	{
+		Name:        "delete-blank-lines",
+		Description: "Delete the blank highlighted lines, or the blank lines of the buffer",
+		ActionMaker: SimpleActionMaker(CmdDeleteBlankLines),
+	},
+	{
+		Name:        "trim-trailing-whitespace",
+		Description: "Remove the spaces at the end of the highlighted lines, or of the buffer",
+		ActionMaker: SimpleActionMaker(CmdTrimTrailingWhitespace),
+	},
+	{
+		Name:        "upcase-word",
+		Description: "Convert the next word or the region to upper case",
+		ActionMaker: CountActionMaker(CmdTransformWords(strings.ToUpper)),
+	},
+	{
+		Name:        "downcase-word",
+		Description: "Convert the next word or the region to lower case",
+		ActionMaker: CountActionMaker(CmdTransformWords(strings.ToLower)),
+	},
+	{
+		Name:        "capitalize-word",
+		Description: "Capitalize the next word or the words of the region",
+		ActionMaker: CountActionMaker(CmdTransformWords(capitalize)),
+	},
+	{
+		Name:        "camel-case",
+		Description: "Convert the identifier at the cursor or in the region to camelCase",
+		ActionMaker: SimpleActionMaker(CmdConvertIdentifiers(CamelCase)),
+	},
+	{
+		Name:        "snake-case",
+		Description: "Convert the identifier at the cursor or in the region to snake_case",
+		ActionMaker: SimpleActionMaker(CmdConvertIdentifiers(SnakeCase)),
+	},
+	{
+		Name:        "kebab-case",
+		Description: "Convert the identifier at the cursor or in the region to kebab-case",
+		ActionMaker: SimpleActionMaker(CmdConvertIdentifiers(KebabCase)),
+	},
+	{
+		Name:        "transpose-chars",
+		Description: "Swap the characters around the cursor",
+		ActionMaker: CountActionMaker(CmdTransposeRunes),
+	},
+	{
+		Name:        "transpose-words",
+		Description: "Swap the words around the cursor",
+		ActionMaker: CountActionMaker(CmdTransposeWords),
+	},
+	{
+		Name:        "transpose-lines",
+		Description: "Swap the current line with the previous one",
+		ActionMaker: CountActionMaker(CmdTransposeLines),
+	},
+	{
+		Name:        "find-file",
+		Description: "Open a file of the project found by fuzzy matching its path",
+		ActionMaker: SimpleActionMaker(CmdFindFile),
+	},
+	{
+		Name:        "open-directory",
+		Description: "List the entries of a directory",
+		ActionMaker: SimpleActionMaker(CmdOpenDirectory),
+	},
+	{
+		Name:        "directory-open-entry",
+		Description: "Open the file or directory at the cursor of a directory buffer",
+		ActionMaker: SimpleActionMaker(CmdDirectoryOpenEntry),
+	},
+	{
+		Name:        "directory-mouse-release",
+		Description: "Move the cursor and open the directory entry under it",
+		Parameters:  []Parameter{{Name: "position"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdDirectoryMouseRelease(args[0].(Position))
 		},
 	},
 	{
-		seq:    "MousePress-WheelDown",
-		action: SimpleActionMaker(CmdScrollDown),
+		Name:        "directory-up",
+		Description: "List the parent directory",
+		ActionMaker: SimpleActionMaker(CmdDirectoryUp),
+	},
+	{
+		Name:        "directory-refresh",
+		Description: "List the entries of the directory again",
+		ActionMaker: SimpleActionMaker(CmdDirectoryRefresh),
+	},
+	{
+		Name:        "directory-create",
+		Description: "Create a file, or a directory if the name ends with /",
+		ActionMaker: SimpleActionMaker(CmdDirectoryCreate),
+	},
+	{
+		Name:        "directory-rename",
+		Description: "Rename the entry at the cursor",
+		ActionMaker: SimpleActionMaker(CmdDirectoryRename),
+	},
+	{
+		Name:        "directory-copy",
+		Description: "Copy the entry at the cursor",
+		ActionMaker: SimpleActionMaker(CmdDirectoryCopy),
+	},
+	{
+		Name:        "directory-delete",
+		Description: "Delete the entry at the cursor",
+		ActionMaker: SimpleActionMaker(CmdDirectoryDelete),
 	},
 	{
-		seq:    "MousePress-WheelUp",
-		action: SimpleActionMaker(CmdScrollUp),
+		Name:        "grep",
+		Description: "Search the files under a directory for a regular expression",
+		ActionMaker: SimpleActionMaker(CmdGrep),
 	},
 	{
-		seq:    "Ctrl-X Ctrl-S",
-		action: SimpleActionMaker(CmdSaveBuffer),
+		Name:        "regrep",
+		Description: "Run the last search again",
+		ActionMaker: SimpleActionMaker(CmdRegrep),
 	},
 	{
-		seq:    "Ctrl-C",
-		action: SimpleActionMaker(CmdQuit),
+		Name:        "kill-grep",
+		Description: "Stop the running search",
+		ActionMaker: SimpleActionMaker(CmdKillGrep),
 	},
 	{
-		seq: "Paste.PasteString",
-		action: func(args []interface{}) Action {
+		Name:        "grep-goto-match",
+		Description: "Visit the location of the match on the cursor line",
+		ActionMaker: SimpleActionMaker(CmdGrepGotoMatch),
+	},
+	{
+		Name:        "grep-mouse-release",
+		Description: "Move the cursor and visit the location of the match under it",
+		Parameters:  []Parameter{{Name: "position"}},
+		ActionMaker: func(args []interface{}, count int) Action {
+			return CmdGrepMouseRelease(args[0].(Position))
+		},
+	},
+	{
+		Name:        "reload-config",
+		Description: "Reload init.lua and the plugins from the configuration directory",
+		ActionMaker: SimpleActionMaker(CmdReloadConfig),
+	},
+	{
+		Name:        "quit",
+		Description: "Quit the application",
+		ActionMaker: SimpleActionMaker(CmdQuit),
+	},
+	{
+		Name:        "paste",
+		Description: "Insert pasted text",
+		Parameters:  []Parameter{{Name: "text"}},
+		ActionMaker: func(args []interface{}, count int) Action {
 			return CmdPasteString(args[0].(string))
 		},
 	},
 }
+
+// defaultBindings binds sequences to commands in the global keymap, unless a
+// keymap is specified.
+var defaultBindings = []struct {
+	keymap  string
+	seq     string
+	command string
+}{
+	{
+		seq:     "Rune.Rune",
+		command: "self-insert",
+	},
+	{
+		seq:     "Tab",
+		command: "expand-snippet-or-tab",
+	},
+	{
+		seq:     "Left",
+		command: "cursor-left",
+	},
+	{
+		seq:     "Right",
+		command: "cursor-right",
+	},
+	{
+		seq:     "Ctrl-F",
+		command: "cursor-right",
+	},
+	{
+		seq:     "Up",
+		command: "cursor-up",
+	},
+	{
+		seq:     "Ctrl-P",
+		command: "cursor-up",
+	},
+	{
+		seq:     "Down",
+		command: "cursor-down",
+	},
+	{
+		seq:     "Ctrl-N",
+		command: "cursor-down",
+	},
+	{
+		seq:     "Backspace",
+		command: "delete-backward",
+	},
+	{
+		seq:     "Backspace2", // On MacOS
+		command: "delete-backward",
+	},
+	{
+		seq:     "Enter",
+		command: "newline",
+	},
+	{
+		seq:     "Ctrl-A",
+		command: "line-start",
+	},
+	{
+		seq:     "Ctrl-E",
+		command: "line-end",
+	},
+	{
+		seq:     "Ctrl-V",
+		command: "page-down",
+	},
+	{
+		seq:     "Alt+Ctrl-V",
+		command: "page-up",
+	},
+	{
+		seq:     "Resize.Size",
+		command: "resize",
+	},
+	{
+		seq:     "MousePress-Button1.Position",
+		command: "mouse-press",
+	},
+	{
+		seq:     "MouseRelease-Button1.Position",
+		command: "mouse-release",
+	},
+	{
+		seq:     "MouseDrag-Button1.Position",
+		command: "mouse-drag",
+	},
+	{
+		seq:     "MousePress-WheelDown",
+		command: "scroll-down",
+	},
+	{
+		seq:     "MousePress-WheelUp",
+		command: "scroll-up",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-S",
+		command: "save-buffer",
+	},
+	{
+		seq:     "Ctrl-X (",
+		command: "start-macro",
+	},
+	{
+		seq:     "Ctrl-X )",
+		command: "stop-macro",
+	},
+	{
+		seq:     "Ctrl-X e",
+		command: "run-macro",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-K r",
+		command: "run-macro-on-region",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-K n",
+		command: "name-last-macro",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-K e",
+		command: "run-named-macro",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-K s",
+		command: "save-macros",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-K l",
+		command: "load-macros",
+	},
+	{
+		seq:     "Ctrl-X o",
+		command: "other-window",
+	},
+	{
+		seq:     "Ctrl-X 2",
+		command: "split-window",
+	},
+	{
+		seq:     "Ctrl-X k",
+		command: "close-window",
+	},
+	{
+		seq:     "Ctrl-Z",
+		command: "toggle-modal-editing",
+	},
+	{
+		seq:     "F1 c",
+		command: "describe-key-briefly",
+	},
+	{
+		seq:     "F1 k",
+		command: "describe-key",
+	},
+	{
+		seq:     "F1 b",
+		command: "describe-bindings",
+	},
+	{
+		seq:     "Ctrl-_",
+		command: "undo",
+	},
+	{
+		seq:     "Ctrl-X u",
+		command: "undo",
+	},
+	{
+		seq:     "Alt+_",
+		command: "redo",
+	},
+	{
+		seq:     "Alt+!",
+		command: "shell-command",
+	},
+	{
+		seq:     "Ctrl-X !",
+		command: "shell-command-insert",
+	},
+	{
+		seq:     "Alt+|",
+		command: "shell-command-on-region",
+	},
+	{
+		seq:     "Ctrl-X c",
+		command: "compile",
+	},
+	{
+		seq:     "Ctrl-X `",
+		command: "next-error",
+	},
+	{
+		seq:     "Alt+g n",
+		command: "next-error",
+	},
+	{
+		seq:     "Alt+g p",
+		command: "previous-error",
+	},
+	{
+		seq:     "Alt+.",
+		command: "lsp-definition",
+	},
+	{
+		seq:     "Alt+?",
+		command: "lsp-references",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-L h",
+		command: "lsp-hover",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-L d",
+		command: "lsp-definition",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-L r",
+		command: "lsp-references",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-L n",
+		command: "lsp-rename",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-L f",
+		command: "lsp-format",
+	},
+	{
+		seq:     "Alt+/",
+		command: "complete",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-Z z",
+		command: "toggle-fold",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-Z o",
+		command: "open-all-folds",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-Z c",
+		command: "close-all-folds",
+	},
+	{
+		seq:     "Alt+;",
+		command: "toggle-comment",
+	},
+	{
+		seq:     "Alt+Up",
+		command: "move-lines-up",
+	},
+	{
+		seq:     "Alt+Down",
+		command: "move-lines-down",
+	},
+	{
+		seq:     "Shift+Alt+Down",
+		command: "duplicate-lines",
+	},
+	{
+		seq:     "Alt+^",
+		command: "join-previous-line",
+	},
+	{
+		seq:     "Alt+u",
+		command: "upcase-word",
+	},
+	{
+		seq:     "Alt+l",
+		command: "downcase-word",
+	},
+	{
+		seq:     "Alt+c",
+		command: "capitalize-word",
+	},
+	{
+		seq:     "Ctrl-T",
+		command: "transpose-chars",
+	},
+	{
+		seq:     "Alt+t",
+		command: "transpose-words",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-T",
+		command: "transpose-lines",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-F",
+		command: "find-file",
+	},
+	{
+		seq:     "Ctrl-X d",
+		command: "open-directory",
+	},
+	{
+		seq:     "Ctrl-X g",
+		command: "grep",
+	},
+	{
+		seq:     "Ctrl-X Ctrl-R",
+		command: "reload-config",
+	},
+	{
+		seq:     "Ctrl-C",
+		command: "quit",
+	},
+	{
+		seq:     "Paste.PasteString",
+		command: "paste",
+	},
+	{
+		seq:     "Ctrl-X l",
+		command: "lua-console",
+	},
+	{
+		keymap:  consoleKind,
+		seq:     "Enter",
+		command: "console-submit",
+	},
+	{
+		keymap:  consoleKind,
+		seq:     "Alt+Enter",
+		command: "console-newline",
+	},
+	{
+		keymap:  consoleKind,
+		seq:     "Alt+p",
+		command: "console-history-previous",
+	},
+	{
+		keymap:  consoleKind,
+		seq:     "Alt+n",
+		command: "console-history-next",
+	},
+	{
+		keymap:  compilationKind,
+		seq:     "Enter",
+		command: "compile-goto-error",
+	},
+	{
+		keymap:  compilationKind,
+		seq:     "MouseRelease-Button1.Position",
+		command: "compile-mouse-release",
+	},
+	{
+		keymap:  compilationKind,
+		seq:     "g",
+		command: "recompile",
+	},
+	{
+		keymap:  compilationKind,
+		seq:     "q",
+		command: "close-window",
+	},
+	{
+		keymap:  grepKind,
+		seq:     "Enter",
+		command: "grep-goto-match",
+	},
+	{
+		keymap:  grepKind,
+		seq:     "MouseRelease-Button1.Position",
+		command: "grep-mouse-release",
+	},
+	{
+		keymap:  grepKind,
+		seq:     "g",
+		command: "regrep",
+	},
+	{
+		keymap:  grepKind,
+		seq:     "k",
+		command: "kill-grep",
+	},
+	{
+		keymap:  grepKind,
+		seq:     "q",
+		command: "close-window",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "Enter",
+		command: "directory-open-entry",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "MouseRelease-Button1.Position",
+		command: "directory-mouse-release",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "^",
+		command: "directory-up",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "g",
+		command: "directory-refresh",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "+",
+		command: "directory-create",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "R",
+		command: "directory-rename",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "C",
+		command: "directory-copy",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "D",
+		command: "directory-delete",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "n",
+		command: "cursor-down",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "p",
+		command: "cursor-up",
+	},
+	{
+		keymap:  directoryKind,
+		seq:     "q",
+		command: "close-window",
+	},
+	{
+		keymap:  lspHoverKind,
+		seq:     "q",
+		command: "close-window",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Down",
+		command: "completion-next",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Ctrl-N",
+		command: "completion-next",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Up",
+		command: "completion-previous",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Ctrl-P",
+		command: "completion-previous",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Enter",
+		command: "completion-accept",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Tab",
+		command: "completion-accept",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Esc",
+		command: "completion-cancel",
+	},
+	{
+		keymap:  completionKeymap,
+		seq:     "Ctrl-G",
+		command: "completion-cancel",
+	},
+	{
+		keymap:  snippetKeymap,
+		seq:     "Tab",
+		command: "snippet-next-field",
+	},
+	{
+		keymap:  snippetKeymap,
+		seq:     "Backtab",
+		command: "snippet-previous-field",
+	},
+	{
+		keymap:  snippetKeymap,
+		seq:     "Ctrl-G",
+		command: "snippet-exit",
+	},
+	{
+		keymap:  "help",
+		seq:     "q",
+		command: "close-window",
+	},
+}
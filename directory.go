@@ -0,0 +1,338 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A directory buffer lists the entries of a directory with their mode, size
+// and modification time.  The entry of each line is kept in its Meta, and the
+// commands of the directory keymap act on the entry at the cursor.
+
+// directoryKind is the buffer kind of directory listings.
+const directoryKind = "directory"
+
+// A DirEntry is the Meta of a line of a directory buffer.
+type DirEntry struct {
+	Name  string
+	Path  string
+	IsDir bool
+
+	col int // column of the name in the line
+}
+
+// NewDirectoryBuffer returns a buffer listing the entries of dir.
+func NewDirectoryBuffer(dir string) *FileBuffer {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	buf := &FileBuffer{
+		filename: dir,
+		kind:     directoryKind,
+		readOnly: true,
+		lines:    []Line{NewLineFromString(dir+":", nil)},
+	}
+	fillDirectoryBuffer(buf)
+	return buf
+}
+
+// fillDirectoryBuffer lists the entries of the directory of buf after its
+// first line, directories first.
+func fillDirectoryBuffer(buf *FileBuffer) {
+	buf.Truncate(1)
+	dir := buf.filename
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		buf.AppendLine(NewLineFromString("  "+err.Error(), nil))
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IsDir() && !entries[j].IsDir()
+	})
+	if parent := filepath.Dir(dir); parent != dir {
+		buf.AppendLine(directoryLine(dir, "..", parent))
+	}
+	for _, e := range entries {
+		buf.AppendLine(directoryLine(dir, e.Name(), filepath.Join(dir, e.Name())))
+	}
+}
+
+func directoryLine(dir, name, path string) Line {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return NewLineFromString(fmt.Sprintf("  %s: %s", name, err), nil)
+	}
+	entry := &DirEntry{Name: name, Path: path, IsDir: info.IsDir()}
+	display := name
+	switch {
+	case info.IsDir():
+		display += "/"
+	case info.Mode()&os.ModeSymlink != 0:
+		if target, err := os.Readlink(path); err == nil {
+			display += " -> " + target
+		}
+		if st, err := os.Stat(path); err == nil {
+			entry.IsDir = st.IsDir()
+		}
+	}
+	prefix := fmt.Sprintf("  %s %10d %s  ", info.Mode(), info.Size(), info.ModTime().Format("2006-01-02 15:04"))
+	entry.col = len([]rune(prefix))
+	return NewLineFromString(prefix+display, entry)
+}
+
+// directoryEntry returns the entry at line l of a directory buffer.
+func directoryEntry(buf Buffer, l int) (*DirEntry, bool) {
+	fb, ok := buf.(*FileBuffer)
+	if !ok || fb.kind != directoryKind || l < 0 || l >= len(fb.lines) {
+		return nil, false
+	}
+	entry, ok := fb.lines[l].Meta.(*DirEntry)
+	return entry, ok
+}
+
+// RefreshDirectory lists the entries of the directory shown in w again,
+// keeping the cursor on the same line if possible.
+func (w *Window) RefreshDirectory() {
+	fb, ok := w.buffer.(*FileBuffer)
+	if !ok || fb.kind != directoryKind {
+		return
+	}
+	fillDirectoryBuffer(fb)
+	w.SetCursorPos(w.l, 0)
+	if entry, ok := directoryEntry(fb, w.l); ok {
+		w.c = entry.col
+	}
+}
+
+// OpenEntry opens the entry at the cursor of a directory buffer in w.
+func (w *Window) OpenEntry() error {
+	entry, ok := directoryEntry(w.buffer, w.l)
+	if !ok {
+		return errors.New("no file on this line")
+	}
+	buf, err := w.App().FileBuffer(entry.Path)
+	if err != nil {
+		return err
+	}
+	w.SetBuffer(buf)
+	w.RefreshDirectory()
+	return nil
+}
+
+// copyPath copies the file or directory src to dst, which must not exist and
+// must not be inside src.  Symbolic links are copied as links.
+func copyPath(src, dst string) error {
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(absSrc, absDst)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("cannot copy %s inside itself", src)
+	}
+	return copyTree(src, dst)
+}
+
+func copyTree(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case !info.IsDir():
+		return copyFile(src, dst, info.Mode())
+	}
+	if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := copyTree(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// directoryOf returns the directory shown in w, or the directory of the file
+// shown in it.
+func directoryOf(w *Window) string {
+	fb, ok := w.buffer.(*FileBuffer)
+	switch {
+	case !ok || fb.filename == "":
+		return "."
+	case fb.kind == directoryKind:
+		return fb.filename
+	default:
+		return filepath.Dir(fb.filename)
+	}
+}
+
+// targetPath returns the path of name, relative to dir unless it is absolute.
+func targetPath(dir, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// directoryAction reads an argument for an operation on the entry at the
+// cursor, then applies it and lists the directory again.
+func directoryAction(w *Window, prompt func(e *DirEntry) (string, string), apply func(e *DirEntry, arg string) error) {
+	a := w.App()
+	entry, ok := directoryEntry(w.buffer, w.l)
+	if !ok || entry.Name == ".." {
+		a.ShowMessage("No file on this line")
+		return
+	}
+	p, initial := prompt(entry)
+	a.ReadString(p, initial, func(arg string) {
+		if err := apply(entry, arg); err != nil {
+			a.ShowMessage("%s", err)
+		}
+		w.RefreshDirectory()
+	})
+}
+
+func CmdOpenDirectory(w *Window) {
+	a := w.App()
+	a.ReadString("Directory: ", directoryOf(w)+string(filepath.Separator), func(dir string) {
+		if err := a.OpenFile(dir); err != nil {
+			a.ShowMessage("Cannot open directory: %s", err)
+		}
+	})
+}
+
+func CmdDirectoryOpenEntry(w *Window) {
+	if err := w.OpenEntry(); err != nil {
+		w.App().ShowMessage("%s", err)
+	}
+}
+
+// CmdDirectoryMouseRelease moves the cursor like CmdMouseButtonUp, and opens
+// the entry under the mouse unless a region was highlighted.
+func CmdDirectoryMouseRelease(pos Position) Action {
+	return func(w *Window) {
+		CmdMouseButtonUp(pos)(w)
+		if _, _, _, _, ok := w.HighlightedRegion(); ok {
+			return
+		}
+		if _, ok := directoryEntry(w.buffer, w.l); ok {
+			CmdDirectoryOpenEntry(w)
+		}
+	}
+}
+
+// CmdDirectoryUp lists the parent directory, with the cursor on the directory
+// it comes from.
+func CmdDirectoryUp(w *Window) {
+	dir := directoryOf(w)
+	if err := w.App().OpenFile(filepath.Dir(dir)); err != nil {
+		w.App().ShowMessage("%s", err)
+		return
+	}
+	w = w.App().focusedWindow
+	for l := 0; l < w.buffer.LineCount(); l++ {
+		if entry, ok := directoryEntry(w.buffer, l); ok && entry.Path == dir {
+			w.SetCursorPos(l, entry.col)
+		}
+	}
+}
+
+func CmdDirectoryRefresh(w *Window) { w.RefreshDirectory() }
+
+func CmdDirectoryCreate(w *Window) {
+	a := w.App()
+	dir := directoryOf(w)
+	a.ReadString("Create (end with / for a directory): ", "", func(name string) {
+		path := targetPath(dir, name)
+		var err error
+		if strings.HasSuffix(name, "/") {
+			err = os.MkdirAll(path, 0o777)
+		} else {
+			var f *os.File
+			if f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666); err == nil {
+				err = f.Close()
+			}
+		}
+		if err != nil {
+			a.ShowMessage("%s", err)
+		}
+		w.RefreshDirectory()
+	})
+}
+
+func CmdDirectoryRename(w *Window) {
+	directoryAction(w, func(e *DirEntry) (string, string) {
+		return "Rename " + e.Name + " to: ", e.Name
+	}, func(e *DirEntry, name string) error {
+		dst := targetPath(filepath.Dir(e.Path), name)
+		if _, err := os.Lstat(dst); err == nil {
+			return fmt.Errorf("%s already exists", name)
+		}
+		return os.Rename(e.Path, dst)
+	})
+}
+
+func CmdDirectoryCopy(w *Window) {
+	directoryAction(w, func(e *DirEntry) (string, string) {
+		return "Copy " + e.Name + " to: ", e.Name
+	}, func(e *DirEntry, name string) error {
+		return copyPath(e.Path, targetPath(filepath.Dir(e.Path), name))
+	})
+}
+
+func CmdDirectoryDelete(w *Window) {
+	directoryAction(w, func(e *DirEntry) (string, string) {
+		if e.IsDir {
+			return "Delete " + e.Name + " and all its contents? (yes or no) ", ""
+		}
+		return "Delete " + e.Name + "? (yes or no) ", ""
+	}, func(e *DirEntry, answer string) error {
+		if answer != "yes" {
+			return errors.New("not deleted")
+		}
+		if e.IsDir {
+			return os.RemoveAll(e.Path)
+		}
+		return os.Remove(e.Path)
+	})
+}
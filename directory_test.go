@@ -0,0 +1,52 @@
+package edit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyPath(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "sub", "f"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Links are copied as links, even those to a directory containing them.
+	if err := os.Symlink("sub/f", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(src, filepath.Join(src, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dst := range []string{src, filepath.Join(src, "sub"), filepath.Join(src, "sub", "x")} {
+		if err := copyPath(src, dst); err == nil {
+			t.Errorf("copied into %s", dst)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(src, "sub", "x")); err == nil {
+		t.Error("a copy was started inside the source")
+	}
+
+	dst := filepath.Join(dir, "src2")
+	if err := copyPath(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	for link, want := range map[string]string{"link": "sub/f", "loop": src} {
+		if target, err := os.Readlink(filepath.Join(dst, link)); err != nil || target != want {
+			t.Errorf("%s: %q, %v", link, target, err)
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dst, "sub", "f")); err != nil || string(data) != "x" {
+		t.Errorf("copied file: %q, %v", data, err)
+	}
+	// A sibling whose name starts with the name of the source is not inside.
+	if err := copyPath(filepath.Join(src, "sub"), filepath.Join(src, "sub2")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,347 @@
+package edit
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dispatchEvent gives evt to the first handler in the stack that knows what to
+// do with it and runs the resulting action.  A handler in the middle of a key
+// sequence has priority.  If it cannot complete the sequence and falls through,
+// or a handler below it knows the sequence, the events of the sequence are
+// given to the handlers below it instead.  A numeric prefix collected by one
+// handler is passed on to the next ones.
+func (a *App) dispatchEvent(handlers []*EventHandler, evt Event) {
+	for i, h := range handlers {
+		if h.currentState == "" {
+			continue
+		}
+		events := append(h.PendingEvents(), evt)
+		count := h.count
+		action, err := h.HandleEvent(evt)
+		switch {
+		case action != nil:
+			a.runAction(action, evt)
+		case err != nil && (h.Fallthrough || knowsSequence(handlers[i+1:], events)):
+			a.flushEvents(handlers[i+1:], events, count)
+		case err != nil:
+			a.eventError(err)
+		}
+		return
+	}
+	var (
+		count countPrefix
+		err   error
+	)
+	for _, h := range handlers {
+		if count.given && !h.count.given {
+			h.count = count
+		}
+		count = h.count
+		var action Action
+		action, err = h.HandleEvent(evt)
+		if action != nil {
+			a.runAction(action, evt)
+			return
+		}
+		if err == nil {
+			return
+		}
+	}
+	if err != nil {
+		a.eventError(err)
+	}
+}
+
+// flushEvents gives events which were part of an abandoned key sequence to the
+// handlers below the one which abandoned it.
+func (a *App) flushEvents(handlers []*EventHandler, events []Event, count countPrefix) {
+	if len(handlers) == 0 {
+		a.eventError(errors.New("no known transition for event"))
+		return
+	}
+	if count.given && !handlers[0].count.given {
+		handlers[0].count = count
+	}
+	for _, evt := range events {
+		a.dispatchEvent(handlers, evt)
+	}
+}
+
+// runAction runs an action triggered by evt in the focused window.
+func (a *App) runAction(action Action, evt Event) {
+	for _, h := range a.eventHandlerStack() {
+		h.Reset()
+	}
+	win := a.focusedWindow
+	WithUndoGroup(win.buffer, func() {
+		action(win)
+		// Changes made by a live snippet in response are part of the
+		// same step.
+		a.updateSnippet()
+	})
+	if win.HasAnchor() {
+		win.updateAnchoredRegion()
+	} else if evt.EventType == Key || evt.EventType == Rune {
+		win.ResetHighlightRegion()
+	}
+	a.commitMacroEvents()
+}
+
+func (a *App) eventError(err error) {
+	for _, h := range a.eventHandlerStack() {
+		h.Reset()
+	}
+	a.Logf("Error handling event: %s", err)
+	if a.macroRecorder != nil && a.macroDepth == 0 {
+		a.macroRecorder.discard()
+	}
+}
+
+// pendingHandler returns the handler in the middle of a key sequence or
+// collecting a numeric prefix, if there is one.
+func (a *App) pendingHandler() *EventHandler {
+	for _, h := range a.eventHandlerStack() {
+		if h.Pending() {
+			return h
+		}
+	}
+	return nil
+}
+
+// updatePending records when the pending key sequence last changed, for
+// timeouts.
+func (a *App) updatePending() {
+	if a.pendingHandler() != nil {
+		a.pendingSince = time.Now()
+	} else {
+		a.pendingSince = time.Time{}
+	}
+}
+
+// SetKeyTimeout sets how long the event handler with the given name waits for
+// the next event of a key sequence, in milliseconds.  When the timeout expires
+// the sequence is abandoned.  A value of 0 means waiting indefinitely.
+func (a *App) SetKeyTimeout(name string, ms int) {
+	a.GetEventHandler(name).Timeout = time.Duration(ms) * time.Millisecond
+}
+
+// SetWhichKeyDelay sets how long to wait in the middle of a key sequence before
+// showing the possible continuations, in milliseconds.  A negative value means
+// never showing them.
+func (a *App) SetWhichKeyDelay(ms int) {
+	a.whichKeyDelay = time.Duration(ms) * time.Millisecond
+}
+
+// PendingTimeout returns how long the event loop can wait for the next event
+// before calling HandleEvent with a NoEvent event, so that key sequence
+// timeouts are processed.  It returns 0 if there is no need to wake up.
+func (a *App) PendingTimeout() time.Duration {
+	h := a.pendingHandler()
+	if h == nil || h.currentState == "" {
+		return 0
+	}
+	elapsed := time.Since(a.pendingSince)
+	var timeout time.Duration
+	consider := func(d time.Duration) {
+		if d <= 0 {
+			return
+		}
+		if d -= elapsed; d <= 0 {
+			d = time.Millisecond
+		}
+		if timeout == 0 || d < timeout {
+			timeout = d
+		}
+	}
+	consider(h.Timeout)
+	if elapsed < a.whichKeyDelay {
+		consider(a.whichKeyDelay)
+	}
+	return timeout
+}
+
+// checkPendingTimeout abandons the pending key sequence if its handler has
+// timed out.
+func (a *App) checkPendingTimeout() {
+	handlers := a.eventHandlerStack()
+	for i, h := range handlers {
+		if !h.Pending() {
+			continue
+		}
+		if h.currentState == "" || h.Timeout <= 0 || time.Since(a.pendingSince) < h.Timeout {
+			return
+		}
+		events := h.PendingEvents()
+		count := h.count
+		h.Reset()
+		if h.Fallthrough {
+			a.flushEvents(handlers[i+1:], events, count)
+		} else {
+			a.eventError(errors.New("key sequence timed out: " + eventNames(events)))
+		}
+		a.updatePending()
+		return
+	}
+}
+
+// PendingKeys returns a description of the pending key sequence, including any
+// numeric prefix, or "" if there is none.
+func (a *App) PendingKeys() string {
+	h := a.pendingHandler()
+	if h == nil {
+		return ""
+	}
+	var parts []string
+	if h.count.given {
+		parts = append(parts, h.count.String())
+	}
+	if len(h.events) > 0 {
+		parts = append(parts, eventNames(h.events))
+	}
+	return strings.Join(parts, " ") + " -"
+}
+
+func eventNames(events []Event) string {
+	names := make([]string, len(events))
+	for i, evt := range events {
+		names[i] = evt.Name()
+	}
+	return strings.Join(names, " ")
+}
+
+// whichKeyVisible returns true if the continuations of the pending key sequence
+// should be displayed.
+func (a *App) whichKeyVisible() bool {
+	if a.whichKeyDelay < 0 || a.pendingSince.IsZero() {
+		return false
+	}
+	h := a.pendingHandler()
+	return h != nil && h.currentState != "" && time.Since(a.pendingSince) >= a.whichKeyDelay
+}
+
+// drawWhichKey draws a popup at the bottom of the screen listing the possible
+// continuations of the pending key sequence, in columns.
+func (a *App) drawWhichKey(screen ScreenWriter) {
+	conts := a.Continuations()
+	if len(conts) == 0 {
+		return
+	}
+	items := make([]string, len(conts))
+	width := 0
+	for i, cont := range conts {
+		items[i] = cont.Key + "  " + cont.Description()
+		if n := len([]rune(items[i])); n > width {
+			width = n
+		}
+	}
+	width += 3
+	sz := screen.Size()
+	cols := sz.W / width
+	if cols == 0 {
+		cols = 1
+	}
+	rows := (len(items) + cols - 1) / cols
+	if rows > sz.H/2 {
+		rows = sz.H / 2
+	}
+	popup := screen.SubScreen(Rectangle{
+		Position: Position{Y: sz.H - rows},
+		Size:     Size{W: sz.W, H: rows},
+	})
+	style := DefaultStyle.Reverse(true)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < sz.W; x++ {
+			popup.SetRune(Position{X: x, Y: y}, ' ', style)
+		}
+	}
+	for i, item := range items {
+		col, row := i/rows, i%rows
+		if col >= cols {
+			break
+		}
+		WriteString(popup, Position{X: col*width + 1, Y: row}, item, style)
+	}
+}
+
+// A Continuation is an event which can follow the pending key sequence of an
+// event handler.
+type Continuation struct {
+	Key     string
+	Prefix  bool   // true if the event starts a longer sequence
+	Command string // name of the command bound to the sequence, if any
+}
+
+// Description returns a short description of what the continuation does: the
+// name of the command it runs if it is bound to one.
+func (c Continuation) Description() string {
+	switch {
+	case c.Prefix:
+		return "+prefix"
+	case c.Command != "":
+		return c.Command
+	default:
+		return "action"
+	}
+}
+
+// Continuations returns the events which can follow the pending key sequence,
+// sorted by name.
+func (h *EventHandler) Continuations() []Continuation {
+	if h == nil {
+		return nil
+	}
+	conts := h.continuations(h.currentState, nil)
+	sortContinuations(conts)
+	return conts
+}
+
+// continuations appends the events which can follow state s to conts, unless
+// conts has one with the same name.
+func (h *EventHandler) continuations(s string, conts []Continuation) []Continuation {
+	n := len(conts)
+next:
+	for key, next := range h.states[s].transitions {
+		for _, c := range conts[:n] {
+			if c.Key == key {
+				continue next
+			}
+		}
+		sDef := h.states[next]
+		conts = append(conts, Continuation{
+			Key:     key,
+			Prefix:  len(sDef.transitions) > 0,
+			Command: sDef.command,
+		})
+	}
+	return conts
+}
+
+// Continuations returns the events which can follow the pending key sequence
+// in the handler collecting it or in the handlers below it, sorted by name.
+func (a *App) Continuations() []Continuation {
+	handlers := a.eventHandlerStack()
+	for i, h := range handlers {
+		if h.currentState == "" {
+			continue
+		}
+		conts := h.continuations(h.currentState, nil)
+		events := h.PendingEvents()
+		for _, lower := range handlers[i+1:] {
+			if s, ok := lower.lookup(events); ok {
+				conts = lower.continuations(s, conts)
+			}
+		}
+		sortContinuations(conts)
+		return conts
+	}
+	return nil
+}
+
+func sortContinuations(conts []Continuation) {
+	sort.Slice(conts, func(i, j int) bool {
+		return conts[i].Key < conts[j].Key
+	})
+}
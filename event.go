@@ -2,8 +2,11 @@ package edit
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 )
@@ -160,26 +163,163 @@ func addPrefix(s, p string) string {
 
 type Action func(win *Window)
 
-type ActionMaker func([]interface{}) Action
+// An ActionMaker makes an action from the values of the event fields captured
+// by a key sequence and a repeat count, which is 1 unless a numeric prefix was
+// given.
+type ActionMaker func(args []interface{}, count int) Action
 
 type stateDef struct {
+	command     string // name of the command, if the action is a command
 	action      ActionMaker
 	eventFields []string
 	transitions map[string]string
 }
 
 type EventHandler struct {
+	// Name identifies the handler when describing key bindings.
+	Name string
+
 	states       map[string]stateDef
 	currentState string
 	events       []Event
+
+	// UniversalArgument is the name of the event that starts a numeric prefix
+	// (emacs style).  On its own it means 4, repeating it multiplies by 4, and
+	// it can be followed by digits and an optional leading minus sign.  Alt
+	// with a digit also starts a numeric prefix.
+	UniversalArgument string
+
+	// If BareDigits is true, digits typed at the start of a key sequence are a
+	// numeric prefix (vi style).  A leading 0 is not a count.
+	BareDigits bool
+
+	// Timeout is how long to wait for the next event of a key sequence before
+	// abandoning it.  If it is 0, there is no timeout.
+	Timeout time.Duration
+
+	// If Fallthrough is true, the events of an abandoned key sequence are passed
+	// on to the event handlers below this one.  This allows e.g. binding "j k"
+	// in insert mode while still being able to type "j".
+	Fallthrough bool
+
+	count           countPrefix
+	collectingCount bool
+
+	// Bindings hidden by Shadow, per sequence.
+	shadowed map[string][]map[string]stateDef
 }
 
 func NewEventHandler() *EventHandler {
 	return &EventHandler{
-		states: map[string]stateDef{},
+		states:            map[string]stateDef{},
+		UniversalArgument: "Ctrl-U",
 	}
 }
 
+// countPrefix accumulates a numeric prefix.
+type countPrefix struct {
+	value    int
+	negative bool
+	digits   bool // true if digits have been typed
+	given    bool // true if a prefix was given at all
+}
+
+func (c countPrefix) Count() int {
+	if !c.given {
+		return 1
+	}
+	n := c.value
+	if !c.digits && c.negative {
+		n = 1
+	}
+	if c.negative {
+		n = -n
+	}
+	return n
+}
+
+func (c *countPrefix) addDigit(r rune) {
+	if !c.digits {
+		c.value = 0
+		c.digits = true
+	}
+	c.value = c.value*10 + int(r-'0')
+	c.given = true
+}
+
+func isDigitEvent(evt Event, mod Modifiers) bool {
+	return evt.EventType == Rune && evt.Modifiers == mod && evt.Rune >= '0' && evt.Rune <= '9'
+}
+
+// handleCountEvent updates the numeric prefix being collected with evt and
+// returns true if evt was consumed by it.
+func (h *EventHandler) handleCountEvent(evt Event) bool {
+	if h.currentState != "" {
+		return false
+	}
+	if evt.EventType == Mouse && evt.Name() == "MouseMove" {
+		return false
+	}
+	switch {
+	case h.UniversalArgument != "" && evt.Name() == h.UniversalArgument:
+		if !h.collectingCount || h.count.digits {
+			h.count = countPrefix{value: 4, given: true}
+			h.collectingCount = true
+		} else {
+			h.count.value *= 4
+		}
+		return true
+	case isDigitEvent(evt, Alt):
+		if !h.collectingCount {
+			h.count = countPrefix{}
+			h.collectingCount = true
+		}
+		h.count.addDigit(evt.Rune)
+		return true
+	case h.collectingCount && isDigitEvent(evt, 0):
+		h.count.addDigit(evt.Rune)
+		return true
+	case h.collectingCount && !h.count.digits && evt.EventType == Rune && evt.Modifiers == 0 && evt.Rune == '-':
+		h.count.negative = !h.count.negative
+		return true
+	case h.BareDigits && isDigitEvent(evt, 0) && (evt.Rune != '0' || h.count.digits):
+		h.collectingCount = true
+		h.count.addDigit(evt.Rune)
+		return true
+	}
+	h.collectingCount = false
+	return false
+}
+
+func (c countPrefix) String() string {
+	if c.negative && !c.digits {
+		return "-"
+	}
+	return strconv.Itoa(c.Count())
+}
+
+// Pending returns true if the handler is in the middle of a key sequence or
+// collecting a numeric prefix.
+func (h *EventHandler) Pending() bool {
+	return h != nil && (h.currentState != "" || h.collectingCount)
+}
+
+// PendingEvents returns the events of the key sequence in progress.
+func (h *EventHandler) PendingEvents() []Event {
+	if h == nil {
+		return nil
+	}
+	return append([]Event(nil), h.events...)
+}
+
+// Count returns the numeric prefix collected so far, or 1 if there is none.
+func (h *EventHandler) Count() int {
+	if h == nil {
+		return 1
+	}
+	return h.count.Count()
+}
+
 func convertEvents(events []Event, fields []string) []interface{} {
 	var out []interface{}
 	for i, evt := range events {
@@ -203,6 +343,9 @@ func (h *EventHandler) HandleEvent(evt Event) (Action, error) {
 	if h == nil {
 		return nil, nil
 	}
+	if h.handleCountEvent(evt) {
+		return nil, nil
+	}
 	sDef := h.states[h.currentState]
 	evtName := evt.Name()
 	trans := evtName
@@ -224,7 +367,7 @@ func (h *EventHandler) HandleEvent(evt Event) (Action, error) {
 	h.events = append(h.events, evt)
 	var action Action
 	if sDef.action != nil {
-		action = sDef.action(convertEvents(h.events, sDef.eventFields))
+		action = sDef.action(convertEvents(h.events, sDef.eventFields), h.Count())
 		h.Reset()
 	}
 	return action, nil
@@ -241,35 +384,76 @@ func (h *EventHandler) HandleEvent(evt Event) (Action, error) {
 // It will also record that the state x:y:z triggers action x:y:z triggers
 // action
 func (h *EventHandler) RegisterAction(seq string, action ActionMaker) error {
+	return h.registerAction(seq, "", action)
+}
+
+// RegisterCommand associates the given command with the event sequence
+// represented by seq, like RegisterAction.  The sequence must capture the
+// event fields the command takes as parameters.
+func (h *EventHandler) RegisterCommand(seq string, cmd *Command) error {
+	if err := checkParameters(seq, cmd); err != nil {
+		return err
+	}
+	return h.registerAction(seq, cmd.Name, cmd.ActionMaker)
+}
+
+// parameterFields gives the event field captured for the parameters of the
+// default commands.
+var parameterFields = map[string]string{
+	"rune":     "Rune",
+	"position": "Position",
+	"size":     "Size",
+	"text":     "PasteString",
+}
+
+// checkParameters returns an error if the fields captured by seq cannot fill
+// the parameters of cmd.
+func checkParameters(seq string, cmd *Command) error {
+	_, eventFields := parseSeq(seq)
+	var fields []string
+	for _, f := range eventFields {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) < len(cmd.Parameters) {
+		return fmt.Errorf("command %s needs %d argument(s) but %s captures %d", cmd.Name, len(cmd.Parameters), seq, len(fields))
+	}
+	for i, p := range cmd.Parameters {
+		if f, ok := parameterFields[p.Name]; ok && fields[i] != f {
+			return fmt.Errorf("command %s needs a %s for its %s argument but %s captures a %s", cmd.Name, f, p.Name, seq, fields[i])
+		}
+	}
+	return nil
+}
+
+func (h *EventHandler) registerAction(seq, command string, action ActionMaker) error {
 	if h == nil {
 		return errors.New("cannot register an action on a nil handler")
 	}
-	events := strings.Split(seq, " ")
+	eventNames, eventFields := parseSeq(seq)
 	s := ""
-	var eventFields []string
-	var eventNames []string
-	for _, event := range events {
-		parts := strings.SplitN(event, ".", 2)
-		eventName := parts[0]
-		eventField := ""
-		if len(parts) == 2 {
-			eventField = parts[1]
-		}
+	for _, eventName := range eventNames {
 		sDef := h.states[s]
 		if sDef.action != nil {
 			return errors.New("action already exists")
 		}
 		s = childState(s, eventName)
-		eventNames = append(eventNames, eventName)
-		eventFields = append(eventFields, eventField)
 	}
 	sDef, ok := h.states[s]
 	if ok && len(sDef.transitions) > 0 {
 		return errors.New("seq is prefix to existing seq")
 	}
 	// All is well
-	s = ""
 	log.Printf("Action for %s: %v", seq, eventNames)
+	h.bind(eventNames, eventFields, command, action)
+	return nil
+}
+
+// bind creates the transitions for a sequence of event names and associates
+// the action with the final state.
+func (h *EventHandler) bind(eventNames, eventFields []string, command string, action ActionMaker) {
+	s := ""
 	for _, eventName := range eventNames {
 		sDef := h.states[s]
 		if sDef.transitions == nil {
@@ -279,40 +463,67 @@ func (h *EventHandler) RegisterAction(seq string, action ActionMaker) error {
 		s = childState(s, eventName)
 		sDef.transitions[eventName] = s
 	}
-	sDef = h.states[s]
+	sDef := h.states[s]
+	sDef.command = command
 	sDef.action = action
 	sDef.eventFields = eventFields
 	h.states[s] = sDef
-	return nil
 }
 
+// parseSeq splits a sequence such as "Ctrl-X Rune.Rune" into event names and
+// the event fields to capture.
+func parseSeq(seq string) (eventNames, eventFields []string) {
+	for _, event := range strings.Split(seq, " ") {
+		eventName, eventField := event, ""
+		// A leading or trailing dot is the "." key, e.g. in "Alt+.".
+		if i := strings.IndexByte(event, '.'); i > 0 && i < len(event)-1 {
+			eventName, eventField = event[:i], event[i+1:]
+		}
+		eventNames = append(eventNames, eventName)
+		eventFields = append(eventFields, eventField)
+	}
+	return
+}
+
+// UnregisterAction removes the action associated with seq.  Other sequences
+// sharing a prefix with seq are not affected.
 func (h *EventHandler) UnregisterAction(seq string) error {
 	if h == nil {
 		return nil
 	}
-	events := strings.Split(seq, " ")
-	var eventNames []string
-	for _, event := range events {
-		parts := strings.SplitN(event, ".", 2)
-		eventName := parts[0]
-		eventNames = append(eventNames, eventName)
-	}
+	eventNames, _ := parseSeq(seq)
+	log.Printf("Remove action for %s: %v", seq, eventNames)
 	s := ""
-	log.Printf("Action for %s: %v", seq, eventNames)
 	for _, eventName := range eventNames {
-		sDef := h.states[s]
-		if sDef.transitions != nil {
-			delete(sDef.transitions, eventName)
-			if len(sDef.transitions) == 0 {
-				delete(h.states, s)
-			}
-		}
 		s = childState(s, eventName)
 	}
-	delete(h.states, s)
+	sDef, ok := h.states[s]
+	if !ok {
+		return nil
+	}
+	sDef.command = ""
+	sDef.action = nil
+	sDef.eventFields = nil
+	h.states[s] = sDef
+	h.prune(s)
 	return nil
 }
 
+// prune removes state s if it has no action and no transitions, then does the
+// same with its parent.
+func (h *EventHandler) prune(s string) {
+	for s != "" {
+		sDef := h.states[s]
+		if sDef.action != nil || len(sDef.transitions) > 0 {
+			return
+		}
+		delete(h.states, s)
+		parent, eventName := parentState(s)
+		delete(h.states[parent].transitions, eventName)
+		s = parent
+	}
+}
+
 // Reset the handler so any ongoing sequence is aborted.
 func (h *EventHandler) Reset() {
 	if h == nil {
@@ -320,8 +531,16 @@ func (h *EventHandler) Reset() {
 	}
 	h.currentState = ""
 	h.events = nil
+	h.count = countPrefix{}
+	h.collectingCount = false
 }
 
 func childState(s, t string) string {
 	return s + " " + t
 }
+
+// parentState is the inverse of childState.
+func parentState(s string) (string, string) {
+	i := strings.LastIndexByte(s, ' ')
+	return s[:i], s[i+1:]
+}
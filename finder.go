@@ -0,0 +1,300 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// The file finder reads a pattern in the status line and lists the files of
+// the project matching it above, best match first, while the project is
+// walked in the background.  Enter opens the selected file in the focused
+// window.
+
+// maxFinderRows is the maximum number of candidates shown.
+const maxFinderRows = 10
+
+// maxFinderFiles limits the number of files collected, so that finding files
+// from e.g. the home directory does not use too much memory.
+const maxFinderFiles = 200000
+
+// finderBatch is how often files found are handed over to the event loop.
+const finderBatch = 50 * time.Millisecond
+
+var errTooManyFiles = errors.New("too many files")
+
+type fileFinder struct {
+	dir      string
+	job      *Job
+	files    []string
+	input    []rune
+	matched  string        // input when shown was computed
+	matches  []finderMatch // files[:scanned] matching it, best first
+	scanned  int
+	shown    []string // files of matches
+	selected int
+	top      int
+	dirty    bool // files or input changed since shown was computed
+}
+
+// A finderMatch is a file matching the input of a fileFinder.
+type finderMatch struct {
+	file  string
+	index int // in the files of the finder
+	score int
+}
+
+// betterMatch returns true if m is ranked before n.
+func betterMatch(m, n finderMatch) bool {
+	if m.score != n.score {
+		return m.score > n.score
+	}
+	if len(m.file) != len(n.file) {
+		return len(m.file) < len(n.file)
+	}
+	return m.index < n.index
+}
+
+// scoreFile returns the score of file if it matches the lower case runes of
+// a pattern.  Matching the name is worth more than matching directories.
+func scoreFile(pat []rune, file string) (int, bool) {
+	score, ok := fuzzyMatchRunes(pat, file)
+	if !ok {
+		return 0, false
+	}
+	if s, ok := fuzzyMatchRunes(pat, path.Base(file)); ok {
+		score += s
+	}
+	return score, true
+}
+
+// mergeMatches merges two lists of matches sorted best first.
+func mergeMatches(a, b []finderMatch) []finderMatch {
+	merged := make([]finderMatch, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if betterMatch(b[0], a[0]) {
+			merged, b = append(merged, b[0]), b[1:]
+		} else {
+			merged, a = append(merged, a[0]), a[1:]
+		}
+	}
+	return append(append(merged, a...), b...)
+}
+
+// FindFile starts finding files under dir.
+func (a *App) FindFile(dir string) {
+	for _, h := range a.eventHandlerStack() {
+		h.Reset()
+	}
+	a.cancelFinder()
+	f := &fileFinder{dir: dir, dirty: true}
+	a.finder = f
+	f.job = a.StartJob("find-file "+dir, func(j *Job) error {
+		var batch []string
+		last := time.Now()
+		n := 0
+		err := WalkProject(j.Context(), dir, func(rel string) error {
+			batch = append(batch, rel)
+			if n++; n >= maxFinderFiles {
+				return errTooManyFiles
+			}
+			if time.Since(last) >= finderBatch {
+				f.post(a, batch)
+				batch, last = nil, time.Now()
+			}
+			return nil
+		})
+		f.post(a, batch)
+		return err
+	}, JobHandlers{
+		OnExit: func(j *Job, err error) {
+			if err == errTooManyFiles {
+				a.ShowMessage("Only the first %d files are listed", maxFinderFiles)
+			}
+			if a.finder == f {
+				f.dirty = true
+			}
+		},
+	})
+}
+
+// post hands files over to the event loop.
+func (f *fileFinder) post(a *App, files []string) {
+	if len(files) > 0 {
+		a.Post(func() {
+			f.files = append(f.files, files...)
+			f.dirty = true
+		})
+	}
+}
+
+func (a *App) cancelFinder() {
+	if f := a.finder; f != nil {
+		f.job.Cancel()
+		a.finder = nil
+	}
+}
+
+func (f *fileFinder) handle(a *App, evt Event) {
+	switch evt.EventType {
+	case Rune:
+		f.input = append(f.input, evt.Rune)
+		f.dirty = true
+	case Paste:
+		f.input = append(f.input, []rune(evt.PasteString)...)
+		f.dirty = true
+	case Key:
+		switch tcell.Key(evt.KeyData) {
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if len(f.input) > 0 {
+				f.input = f.input[:len(f.input)-1]
+				f.dirty = true
+			}
+		case tcell.KeyCtrlU:
+			f.input = nil
+			f.dirty = true
+		// The list goes up from the status line.
+		case tcell.KeyUp, tcell.KeyCtrlP:
+			f.moveSelection(1)
+		case tcell.KeyDown, tcell.KeyCtrlN:
+			f.moveSelection(-1)
+		case tcell.KeyEnter:
+			f.update()
+			a.cancelFinder()
+			if f.selected < len(f.shown) {
+				if err := a.OpenFile(filepath.Join(f.dir, filepath.FromSlash(f.shown[f.selected]))); err != nil {
+					a.ShowMessage("Cannot open file: %s", err)
+				}
+			}
+		case tcell.KeyEsc, tcell.KeyCtrlG:
+			a.cancelFinder()
+			a.ShowMessage("Cancelled")
+		}
+	}
+}
+
+// update ranks the files matching the input if it or the files changed.  It
+// runs on each redraw, so only the files found since the last update are
+// scored, and when the input is extended only the files which matched it
+// before.
+func (f *fileFinder) update() {
+	if !f.dirty {
+		return
+	}
+	f.dirty = false
+	pattern := string(f.input)
+	pat := []rune(strings.ToLower(pattern))
+	// Stay on the selected file while more files are found.
+	var selected string
+	if pattern == f.matched && f.selected < len(f.shown) {
+		selected = f.shown[f.selected]
+	}
+	switch {
+	case pattern == f.matched:
+	case strings.HasPrefix(pattern, f.matched):
+		// Files which did not match cannot match a longer pattern.
+		matches := f.matches[:0]
+		for _, m := range f.matches {
+			if score, ok := scoreFile(pat, m.file); ok {
+				m.score = score
+				matches = append(matches, m)
+			}
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			return betterMatch(matches[i], matches[j])
+		})
+		f.matches = matches
+	default:
+		f.matches, f.scanned = nil, 0
+	}
+	f.matched = pattern
+	if f.scanned < len(f.files) {
+		var found []finderMatch
+		for i, file := range f.files[f.scanned:] {
+			if score, ok := scoreFile(pat, file); ok {
+				found = append(found, finderMatch{file: file, index: f.scanned + i, score: score})
+			}
+		}
+		sort.Slice(found, func(i, j int) bool {
+			return betterMatch(found[i], found[j])
+		})
+		f.matches = mergeMatches(f.matches, found)
+		f.scanned = len(f.files)
+	}
+	f.shown = f.shown[:0]
+	f.selected, f.top = 0, 0
+	for _, m := range f.matches {
+		if m.file == selected {
+			f.selected = len(f.shown)
+		}
+		f.shown = append(f.shown, m.file)
+	}
+	f.moveSelection(0)
+}
+
+func (f *fileFinder) moveSelection(n int) {
+	if len(f.shown) == 0 {
+		return
+	}
+	f.selected = (f.selected + n) % len(f.shown)
+	if f.selected < 0 {
+		f.selected += len(f.shown)
+	}
+	if f.selected < f.top {
+		f.top = f.selected
+	} else if f.selected >= f.top+maxFinderRows {
+		f.top = f.selected - maxFinderRows + 1
+	}
+}
+
+// drawFinder draws the candidates at the bottom of the screen, the best one
+// closest to the status line.
+func (a *App) drawFinder(screen ScreenWriter) {
+	f := a.finder
+	f.update()
+	sz := screen.Size()
+	rows := len(f.shown) - f.top
+	if rows > maxFinderRows {
+		rows = maxFinderRows
+	}
+	for i := 0; i < rows; i++ {
+		style := DefaultStyle.Reverse(true)
+		if f.top+i == f.selected {
+			style = DefaultStyle.Bold(true)
+		}
+		y := sz.H - 1 - i
+		for x := 0; x < sz.W; x++ {
+			screen.SetRune(Position{X: x, Y: y}, ' ', style)
+		}
+		WriteString(screen, Position{X: 1, Y: y}, f.shown[f.top+i], style)
+	}
+}
+
+func (a *App) drawFinderPrompt(screen ScreenWriter, p Position) {
+	f := a.finder
+	status := fmt.Sprintf("Find file (%d/%d", len(f.shown), len(f.files))
+	if f.job.Running() {
+		status += "+"
+	}
+	p = WriteString(screen, p, status+"): "+string(f.input), DefaultStyle)
+	screen.Reverse(p)
+}
+
+// OpenFile shows the named file, or directory, in the focused window.
+func (a *App) OpenFile(filename string) error {
+	buf, err := a.FileBuffer(filename)
+	if err != nil {
+		return err
+	}
+	a.focusedWindow.SetBuffer(buf)
+	a.focusedWindow.RefreshDirectory()
+	return nil
+}
+
+func CmdFindFile(w *Window) { w.App().FindFile(".") }
@@ -0,0 +1,70 @@
+package edit
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// finderFiles returns n file names of a made up project.
+func finderFiles(n int) []string {
+	dirs := []string{"cmd/edit", "internal/lsp", "docs", "", "vendor/github.com/x/y"}
+	names := []string{"main.go", "finder.go", "README.md", "find_test.go", "Makefile"}
+	var files []string
+	for i := 0; i < n; i++ {
+		files = append(files, fmt.Sprintf("%s/%d%s", dirs[i%len(dirs)], i/len(names), names[i%len(names)]))
+	}
+	return files
+}
+
+// The list is the same whether it is updated as files are found and the
+// pattern typed, or computed once at the end.
+func TestFinderIncremental(t *testing.T) {
+	files := finderFiles(2000)
+	for _, inputs := range [][]string{
+		{"", "f", "fi", "fin", "fing"},
+		{"m", "ma", "m", "", "md", "mdi"},
+		{"find", "x", "xy", "main"},
+	} {
+		f := &fileFinder{}
+		step := len(files) / len(inputs)
+		for i, input := range inputs {
+			f.files = files[:(i+1)*step]
+			f.input = []rune(input)
+			f.dirty = true
+			f.update()
+		}
+		f.files = files
+		f.dirty = true
+		f.update()
+		g := &fileFinder{files: files, input: f.input, dirty: true}
+		g.update()
+		if !reflect.DeepEqual(f.shown, g.shown) {
+			t.Errorf("%v: got %d files %.5v, want %d files %.5v", inputs, len(f.shown), f.shown, len(g.shown), g.shown)
+		}
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern, s string
+		score      int
+		ok         bool
+	}{
+		{"", "abc", 0, true},
+		{"abc", "abc", 4 + 3 + 3, true},
+		{"ABC", "abc", 4 + 3 + 3, true},
+		{"ac", "abc", 4 + 1, true},
+		{"fb", "foo_bar", 4 + 2, true},
+		{"fb", "fooBar", 4 + 2, true},
+		{"fb", "foo/bar", 4 + 2, true},
+		{"ca", "abc", 0, false},
+		{"abcd", "abc", 0, false},
+		{"é", "café", 1, true},
+	} {
+		score, ok := fuzzyMatch(test.pattern, test.s)
+		if score != test.score || ok != test.ok {
+			t.Errorf("%q in %q: got %d, %t want %d, %t", test.pattern, test.s, score, ok, test.score, test.ok)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package edit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// A window can fold ranges of lines: the first line of a closed fold is drawn
+// followed by a summary and the other lines are hidden.  The ranges which can
+// be folded are found by the fold provider of the buffer kind.
+
+// A FoldRange is a range of lines which can be folded.  Lines First+1 to Last
+// are hidden when it is closed.
+type FoldRange struct {
+	First, Last int
+}
+
+// A FoldProvider returns the ranges of a buffer which can be folded.
+type FoldProvider func(buf Buffer) []FoldRange
+
+// namedFoldProviders are the providers which can be given by name.
+var namedFoldProviders = map[string]FoldProvider{
+	"indent":  IndentFolds,
+	"bracket": BracketFolds,
+}
+
+// bracketFoldKinds are the kinds folded by brackets unless a provider is set.
+var bracketFoldKinds = map[string]bool{
+	"c":          true,
+	"go":         true,
+	"javascript": true,
+	"json":       true,
+	"rust":       true,
+	"typescript": true,
+}
+
+// foldSummaryStyle is the style of the summary after the first line of a
+// closed fold.
+var foldSummaryStyle = DefaultStyle.Foreground(tcell.ColorGray)
+
+// SetFoldProvider sets the provider of the ranges which can be folded in
+// buffers of kind.  A nil provider restores the default one.
+func (a *App) SetFoldProvider(kind string, p FoldProvider) {
+	if p == nil {
+		delete(a.foldProviders, kind)
+	} else {
+		a.foldProviders[kind] = p
+	}
+}
+
+func (a *App) foldProvider(kind string) FoldProvider {
+	if p := a.foldProviders[kind]; p != nil {
+		return p
+	}
+	if bracketFoldKinds[kind] {
+		return BracketFolds
+	}
+	return IndentFolds
+}
+
+// indentWidth returns the width of the indentation of runes, or -1 if it is
+// blank.
+func indentWidth(runes []rune) int {
+	n := 0
+	for _, r := range runes {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 8 - n%8
+		default:
+			return n
+		}
+	}
+	return -1
+}
+
+// IndentFolds finds ranges made of a line followed by more indented lines.
+func IndentFolds(buf Buffer) []FoldRange {
+	n := buf.LineCount()
+	indents := make([]int, n)
+	for l := range indents {
+		line, _ := buf.GetLine(l, 0)
+		indents[l] = indentWidth(line.Runes)
+	}
+	var ranges []FoldRange
+	for l, indent := range indents {
+		if indent < 0 {
+			continue
+		}
+		last := l
+		for l1 := l + 1; l1 < n; l1++ {
+			if indents[l1] < 0 {
+				continue
+			}
+			if indents[l1] <= indent {
+				break
+			}
+			last = l1
+		}
+		if last > l {
+			ranges = append(ranges, FoldRange{l, last})
+		}
+	}
+	return ranges
+}
+
+// BracketFolds finds ranges from a line with an opening bracket to the line of
+// the matching closing bracket.  The closing line is part of the range if it
+// only contains closing brackets and punctuation.
+func BracketFolds(buf Buffer) []FoldRange {
+	var stack []int
+	last := map[int]int{}
+	for l := 0; l < buf.LineCount(); l++ {
+		line, _ := buf.GetLine(l, 0)
+		var quote rune
+		for i, r := range line.Runes {
+			switch {
+			case quote != 0:
+				if r == quote && (i == 0 || line.Runes[i-1] != '\\') {
+					quote = 0
+				}
+			case r == '"' || r == '\'' || r == '`':
+				quote = r
+			case r == '{' || r == '[' || r == '(':
+				stack = append(stack, l)
+			case r == '}' || r == ']' || r == ')':
+				if len(stack) == 0 {
+					continue
+				}
+				first := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				end := l
+				if !onlyPunct(line.Runes) {
+					end--
+				}
+				if end > first && end > last[first] {
+					last[first] = end
+				}
+			}
+		}
+	}
+	var ranges []FoldRange
+	for first, end := range last {
+		ranges = append(ranges, FoldRange{first, end})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].First < ranges[j].First })
+	return ranges
+}
+
+func onlyPunct(runes []rune) bool {
+	for _, r := range runes {
+		if !unicode.IsSpace(r) && !strings.ContainsRune("}]),;", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// FoldRanges returns the ranges which can be folded in the window's buffer.
+func (w *Window) FoldRanges() []FoldRange {
+	var ranges []FoldRange
+	for _, r := range w.app.foldProvider(w.buffer.Kind())(w.buffer) {
+		if r.First >= 0 && r.First < r.Last && r.Last < w.buffer.LineCount() {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// ToggleFold opens the fold closed at the cursor line, or closes the innermost
+// range containing it.
+func (w *Window) ToggleFold() bool {
+	for i, f := range w.folds {
+		if f.First == w.l {
+			w.folds = append(w.folds[:i:i], w.folds[i+1:]...)
+			return true
+		}
+	}
+	var best FoldRange
+	found := false
+	for _, r := range w.FoldRanges() {
+		if r.First <= w.l && w.l <= r.Last && (!found || r.Last-r.First < best.Last-best.First) {
+			best, found = r, true
+		}
+	}
+	if !found {
+		return false
+	}
+	w.closeFold(best)
+	return true
+}
+
+func (w *Window) closeFold(r FoldRange) {
+	w.listenToBuffer()
+	w.folds = append(w.folds, r)
+	if f, ok := w.foldHiding(w.l); ok {
+		w.SetCursorPos(f.First, w.c)
+	}
+}
+
+// OpenAllFolds shows all the lines.
+func (w *Window) OpenAllFolds() {
+	w.folds = nil
+}
+
+// CloseAllFolds closes all the ranges which can be folded.
+func (w *Window) CloseAllFolds() {
+	w.folds = nil
+	for _, r := range w.FoldRanges() {
+		w.closeFold(r)
+	}
+}
+
+// moveFolds moves the folds after a change.  Folds whose hidden lines are
+// changed are opened.
+func (w *Window) moveFolds(ch BufferChange) {
+	el, _ := ch.InsertedEnd()
+	delta := el - ch.L1
+	folds := w.folds[:0]
+	for _, f := range w.folds {
+		switch {
+		case ch.L0 > f.Last:
+		case ch.L1 < f.First:
+			f.First += delta
+			f.Last += delta
+		case ch.L0 == f.First && ch.L1 == f.First && delta == 0:
+		default:
+			continue
+		}
+		folds = append(folds, f)
+	}
+	w.folds = folds
+}
+
+// hiddenRanges returns the sorted disjoint ranges of hidden lines.
+func (w *Window) hiddenRanges() []FoldRange {
+	var ranges []FoldRange
+	for _, f := range w.folds {
+		ranges = append(ranges, FoldRange{f.First + 1, f.Last})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].First < ranges[j].First })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.First <= merged[n-1].Last+1 {
+			if r.Last > merged[n-1].Last {
+				merged[n-1].Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// foldHiding returns the outermost fold hiding line l.
+func (w *Window) foldHiding(l int) (FoldRange, bool) {
+	var outer FoldRange
+	found := false
+	for _, f := range w.folds {
+		if f.First < l && l <= f.Last && (!found || f.First < outer.First) {
+			outer, found = f, true
+		}
+	}
+	return outer, found
+}
+
+// closedFoldAt returns the outermost fold starting at line l.
+func (w *Window) closedFoldAt(l int) (FoldRange, bool) {
+	var outer FoldRange
+	found := false
+	for _, f := range w.folds {
+		if f.First == l && f.Last > outer.Last {
+			outer, found = f, true
+		}
+	}
+	return outer, found
+}
+
+// visibleLine returns the line n visible lines after l, or before it if n < 0,
+// and the number of lines which could not be moved past the start or the end
+// of the buffer.
+func (w *Window) visibleLine(l, n int) (int, int) {
+	if len(w.folds) == 0 {
+		switch {
+		case l+n < 0:
+			return 0, l + n
+		case l+n >= w.buffer.LineCount():
+			last := w.buffer.LineCount() - 1
+			return last, l + n - last
+		}
+		return l + n, 0
+	}
+	hidden := w.hiddenRanges()
+	for ; n > 0; n-- {
+		next := l + 1
+		for _, r := range hidden {
+			if r.First <= next && next <= r.Last {
+				next = r.Last + 1
+			}
+		}
+		if next >= w.buffer.LineCount() {
+			break
+		}
+		l = next
+	}
+	for ; n < 0; n++ {
+		prev := l - 1
+		for i := len(hidden) - 1; i >= 0; i-- {
+			if r := hidden[i]; r.First <= prev && prev <= r.Last {
+				prev = r.First - 1
+			}
+		}
+		if prev < 0 {
+			break
+		}
+		l = prev
+	}
+	return l, n
+}
+
+// lineRow returns the row of line l relative to the top of the window, which is
+// negative if l is above it.  A hidden line is on the row of its fold.
+func (w *Window) lineRow(l int) int {
+	if len(w.folds) == 0 {
+		return l - w.topLine
+	}
+	if f, ok := w.foldHiding(l); ok {
+		l = f.First
+	}
+	l0, l1, sign := w.topLine, l, 1
+	if l < w.topLine {
+		l0, l1, sign = l, w.topLine, -1
+	}
+	rows := l1 - l0
+	for _, r := range w.hiddenRanges() {
+		// Hidden lines in (l0, l1]
+		first, last := r.First, r.Last
+		if first <= l0 {
+			first = l0 + 1
+		}
+		if last > l1 {
+			last = l1
+		}
+		if last >= first {
+			rows -= last - first + 1
+		}
+	}
+	return sign * rows
+}
+
+// revealCursor opens the folds hiding the cursor, and makes the top line
+// visible.
+func (w *Window) revealCursor() {
+	for {
+		f, ok := w.foldHiding(w.l)
+		if !ok {
+			break
+		}
+		for i, g := range w.folds {
+			if g == f {
+				w.folds = append(w.folds[:i:i], w.folds[i+1:]...)
+				break
+			}
+		}
+	}
+	if f, ok := w.foldHiding(w.topLine); ok {
+		w.topLine = f.First
+	}
+}
+
+func foldSummary(f FoldRange) []rune {
+	if n := f.Last - f.First; n > 1 {
+		return []rune(fmt.Sprintf(" ⋯ %d lines", n))
+	}
+	return []rune(" ⋯ 1 line")
+}
+
+// foldSummaryIter draws the first line of a fold followed by its summary.
+type foldSummaryIter struct {
+	iter    StyledLineIter
+	summary []rune
+}
+
+func (i *foldSummaryIter) Next() (rune, Style) {
+	if i.iter.HasNext() {
+		return i.iter.Next()
+	}
+	r := i.summary[0]
+	i.summary = i.summary[1:]
+	return r, foldSummaryStyle
+}
+
+func (i *foldSummaryIter) HasNext() bool {
+	return i.iter.HasNext() || len(i.summary) > 0
+}
+
+func CmdToggleFold(w *Window) {
+	if !w.ToggleFold() {
+		w.App().ShowMessage("Nothing to fold")
+	}
+}
+
+func CmdOpenAllFolds(w *Window)  { w.OpenAllFolds() }
+func CmdCloseAllFolds(w *Window) { w.CloseAllFolds() }
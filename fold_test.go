@@ -0,0 +1,76 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndentFolds(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want []FoldRange
+	}{
+		{"a\nb", nil},
+		{"a\n  b\n  c\nd", []FoldRange{{0, 2}}},
+		{"a\n  b\n    c\n  d\ne", []FoldRange{{0, 3}, {1, 2}}},
+		// Blank lines do not end a range, but are not its last line.
+		{"a\n  b\n\n  c\n\nd", []FoldRange{{0, 3}}},
+		{"a\n\tb\n        c", []FoldRange{{0, 2}}},
+		{"  a\nb\n  c", []FoldRange{{1, 2}}},
+	} {
+		_, w := newTestApp(test.text)
+		if got := IndentFolds(w.buffer); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestBracketFolds(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want []FoldRange
+	}{
+		{"f() {}", nil},
+		{"f() {\n  x\n}", []FoldRange{{0, 2}}},
+		{"f() {\n  x\n});", []FoldRange{{0, 2}}},
+		{"f() {\n  x\n}, g", []FoldRange{{0, 1}}},
+		// The closing line is not hidden if it has more than brackets.
+		{"if x {\n  y\n} else {\n  z\n}", []FoldRange{{0, 1}, {2, 4}}},
+		{"a := []int{\n  1,\n  f(\n    2,\n  ),\n}", []FoldRange{{0, 5}, {2, 4}}},
+		{"a := f(\n  1)", nil},
+		{"s := \"{\"\nt\n\"}\"", nil},
+		{"x)\n(\ny", nil},
+	} {
+		_, w := newTestApp(test.text)
+		if got := BracketFolds(w.buffer); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestVisibleLine(t *testing.T) {
+	// Lines 0 to 9, with 2-4 and 6-7 hidden.
+	_, w := newTestApp("0\n1\n2\n3\n4\n5\n6\n7\n8\n9")
+	for _, test := range []struct {
+		folds          []FoldRange
+		l, n           int
+		wantL, wantOut int
+	}{
+		{nil, 3, 2, 5, 0},
+		{nil, 3, -5, 0, -2},
+		{nil, 8, 4, 9, 3},
+		{[]FoldRange{{1, 4}, {5, 7}}, 0, 1, 1, 0},
+		{[]FoldRange{{1, 4}, {5, 7}}, 0, 2, 5, 0},
+		{[]FoldRange{{1, 4}, {5, 7}}, 0, 3, 8, 0},
+		{[]FoldRange{{1, 4}, {5, 7}}, 8, -2, 1, 0},
+		{[]FoldRange{{1, 4}, {5, 7}}, 9, 3, 9, 3},
+		{[]FoldRange{{1, 4}, {5, 7}}, 5, -4, 0, -2},
+		// Nested folds hide the lines of the outer one.
+		{[]FoldRange{{1, 8}, {2, 3}}, 0, 2, 9, 0},
+	} {
+		w.folds = test.folds
+		if l, out := w.visibleLine(test.l, test.n); l != test.wantL || out != test.wantOut {
+			t.Errorf("%v: %d lines from %d: got %d, %d want %d, %d", test.folds, test.n, test.l, l, out, test.wantL, test.wantOut)
+		}
+	}
+}
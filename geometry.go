@@ -45,11 +45,11 @@ func (r Rectangle) Intersect(s Rectangle) Rectangle {
 	if r1.Y > s1.Y {
 		r1.Y = s1.Y
 	}
-	r.W = s1.X - r.X
+	r.W = r1.X - r.X
 	if r.W < 0 {
 		r.W = 0
 	}
-	r.H = s1.Y - r.Y
+	r.H = r1.Y - r.Y
 	if r.H < 0 {
 		r.H = 0
 	}
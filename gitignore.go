@@ -0,0 +1,177 @@
+package edit
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Walking a project skips the files ignored by the .gitignore files of the
+// directories walked through, and the .git directory.
+
+// An ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp // matches paths relative to the .gitignore directory
+	negate  bool
+	dirOnly bool
+}
+
+// A gitignore holds the rules of the .gitignore file of a directory.
+type gitignore struct {
+	base  string // directory, relative to the walk root, "" for the root
+	rules []ignoreRule
+}
+
+// readGitignore reads the .gitignore file of directory base under root.
+func readGitignore(root, base string) (*gitignore, error) {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(base), ".gitignore"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ig := &gitignore{base: base}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			ig.rules = append(ig.rules, rule)
+		}
+	}
+	return ig, scanner.Err()
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+	line = strings.TrimRight(line, " \t\r")
+	switch {
+	case line == "" || strings.HasPrefix(line, "#"):
+		return rule, false
+	case strings.HasPrefix(line, "!"):
+		rule.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	// A pattern with a slash is relative to the .gitignore directory,
+	// otherwise it matches names at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := globRegexp(line)
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return rule, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globRegexp translates a gitignore glob to a regular expression.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(glob[i:], "**/"):
+				b.WriteString("(.*/)?")
+				i += 2
+			case strings.HasPrefix(glob[i:], "**"):
+				b.WriteString(".*")
+				i++
+			default:
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// ignored returns true if the last rule matching rel, a slash separated path
+// relative to the walk root, ignores it.
+func ignored(ignores []*gitignore, rel string, isDir bool) bool {
+	ignore := false
+	for _, ig := range ignores {
+		p := rel
+		if ig.base != "" {
+			p = strings.TrimPrefix(rel, ig.base+"/")
+		}
+		for _, rule := range ig.rules {
+			if (!rule.dirOnly || isDir) && rule.re.MatchString(p) {
+				ignore = !rule.negate
+			}
+		}
+	}
+	return ignore
+}
+
+// WalkProject calls f with the slash separated path relative to root of each
+// file under root which is not ignored, until ctx is done or f returns an
+// error.  Directories which cannot be read are skipped.
+func WalkProject(ctx context.Context, root string, f func(rel string) error) error {
+	return walkProjectDir(ctx, root, "", nil, f)
+}
+
+func walkProjectDir(ctx context.Context, root, rel string, ignores []*gitignore, f func(string) error) error {
+	if ig, err := readGitignore(root, rel); err == nil {
+		ignores = append(ignores[:len(ignores):len(ignores)], ig)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := e.Name()
+		p := path.Join(rel, name)
+		if name == ".git" || ignored(ignores, p, e.IsDir()) {
+			continue
+		}
+		var err error
+		switch {
+		case e.IsDir():
+			err = walkProjectDir(ctx, root, p, ignores, f)
+		case e.Type().IsRegular():
+			err = f(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
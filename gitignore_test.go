@@ -0,0 +1,107 @@
+package edit
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	for _, test := range []struct {
+		line    string
+		path    string
+		isDir   bool
+		ignored bool // the rule ignores path
+	}{
+		{"*.o", "a.o", false, true},
+		{"*.o", "x/y/a.o", false, true},
+		{"*.o", "a.oo", false, false},
+		{"/build", "build", true, true},
+		{"/build", "x/build", true, false},
+		{"doc/*.txt", "doc/a.txt", false, true},
+		{"doc/*.txt", "doc/x/a.txt", false, false},
+		{"doc/**/*.txt", "doc/x/y/a.txt", false, true},
+		{"**/tmp", "a/b/tmp", true, true},
+		{"out/", "out", true, true},
+		{"out/", "out", false, false},
+		{"!keep.o", "keep.o", false, false},
+		{`\#file`, "#file", false, true},
+		{"a?c", "abc", false, true},
+		{"a?c", "a/c", false, false},
+		{"[ab].go", "b.go", false, true},
+		{"[!ab].go", "b.go", false, false},
+		{"trailing  ", "trailing", false, true},
+	} {
+		rule, ok := parseIgnoreRule(test.line)
+		if !ok {
+			t.Errorf("%q: not a rule", test.line)
+			continue
+		}
+		ig := []*gitignore{{rules: []ignoreRule{rule}}}
+		if got := ignored(ig, test.path, test.isDir); got != test.ignored {
+			t.Errorf("%q on %q: got %t want %t", test.line, test.path, got, test.ignored)
+		}
+	}
+	for _, line := range []string{"", "# comment", "/", "!", "   "} {
+		if _, ok := parseIgnoreRule(line); ok {
+			t.Errorf("%q is a rule", line)
+		}
+	}
+}
+
+// A negated rule after another one keeps a file, and the rules of a
+// subdirectory apply to paths relative to it.  The walk passes the rules of
+// the directories containing the path.
+func TestIgnored(t *testing.T) {
+	rules := func(lines ...string) []ignoreRule {
+		var rules []ignoreRule
+		for _, line := range lines {
+			rule, _ := parseIgnoreRule(line)
+			rules = append(rules, rule)
+		}
+		return rules
+	}
+	ignores := []*gitignore{
+		{rules: rules("*.log", "!keep.log")},
+		{base: "sub", rules: rules("/gen", "keep.log")},
+	}
+	for _, test := range []struct {
+		path  string
+		depth int // number of gitignore files applying
+		want  bool
+	}{
+		{"a.log", 1, true},
+		{"keep.log", 1, false},
+		{"sub/keep.log", 2, true},
+		{"sub/a.log", 2, true},
+		{"sub/gen", 2, true},
+		{"gen", 1, false},
+		{"sub/x/gen", 2, false},
+	} {
+		if got := ignored(ignores[:test.depth], test.path, false); got != test.want {
+			t.Errorf("%s: got %t want %t", test.path, got, test.want)
+		}
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	for _, test := range []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.go", "a.go", true},
+		{"*.go", "x/a.go", false},
+		{"a.b", "aXb", false},
+		{"**", "x/y", true},
+		{"**/a", "a", true},
+		{"x/**/a", "x/a", true},
+		{`\*`, "*", true},
+		{`\*`, "x", false},
+		{"[a-c]", "b", true},
+		{"[", "[", true},
+	} {
+		re := regexp.MustCompile("^" + globRegexp(test.glob) + "$")
+		if got := re.MatchString(test.path); got != test.want {
+			t.Errorf("%q on %q: got %t want %t", test.glob, test.path, got, test.want)
+		}
+	}
+}
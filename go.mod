@@ -6,12 +6,12 @@ replace github.com/arnodel/golua => /Users/arno/Projects/golua
 
 require (
 	github.com/arnodel/golua v0.0.0-20220121091306-866962c51982
+	github.com/atotto/clipboard v0.1.4
 	github.com/gdamore/tcell/v2 v2.4.0
 )
 
 require (
 	github.com/arnodel/strftime v0.1.6 // indirect
-	github.com/atotto/clipboard v0.1.4 // indirect
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.0.3 // indirect
 	github.com/mattn/go-runewidth v0.0.10 // indirect
@@ -0,0 +1,281 @@
+package edit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// Grep searches the files of a project for a regular expression in the
+// background, skipping the files ignored by .gitignore files.  Matching lines
+// are added to a grep buffer as they are found, as "file:line:col: text" with
+// their location in the Meta of the line, and Enter or a click visits them.
+
+// grepKind is the buffer kind of grep results.
+const grepKind = "grep"
+
+// grepBatch is how often matches found are handed over to the event loop.
+const grepBatch = 50 * time.Millisecond
+
+// maxGrepLineLen is the length beyond which matching lines are cut in the
+// results.
+const maxGrepLineLen = 300
+
+// maxGrepScanLine is the length beyond which lines are not searched.
+const maxGrepScanLine = 1 << 20
+
+var errBinaryFile = errors.New("binary file")
+
+type grepSearch struct {
+	pattern string
+	dir     string
+	buf     *FileBuffer
+	job     *Job
+	matches int
+
+	cancelled bool
+}
+
+// cancel stops the search if it is still running.
+func (g *grepSearch) cancel() bool {
+	if g.job == nil || !g.job.Running() {
+		return false
+	}
+	g.cancelled = true
+	g.job.Cancel()
+	return true
+}
+
+// A grepMatch is a matching line found by the search goroutine.
+type grepMatch struct {
+	text string
+	loc  *ErrorLocation
+}
+
+// Grep searches the files under dir for pattern, showing the matches in the
+// grep buffer.  A search still running is cancelled.
+func (a *App) Grep(pattern, dir string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if old := a.grep; old != nil {
+		old.cancel()
+	}
+	g := &grepSearch{
+		pattern: pattern,
+		dir:     dir,
+		buf: &FileBuffer{
+			kind:     grepKind,
+			readOnly: true,
+			lines:    []Line{NewLineFromString(fmt.Sprintf("Searching for %s in %s", pattern, dir), nil)},
+		},
+	}
+	g.job = a.StartJob("grep "+pattern, func(j *Job) error {
+		var batch []grepMatch
+		last := time.Now()
+		err := WalkProject(j.Context(), dir, func(rel string) error {
+			// Files which cannot be read are skipped.
+			grepFile(j.Context(), re, dir, rel, func(m grepMatch) {
+				batch = append(batch, m)
+			})
+			if time.Since(last) >= grepBatch {
+				g.post(a, batch)
+				batch, last = nil, time.Now()
+			}
+			return nil
+		})
+		g.post(a, batch)
+		return err
+	}, JobHandlers{
+		OnExit: func(j *Job, err error) {
+			var msg string
+			switch {
+			case g.cancelled:
+				msg = "Search cancelled"
+			case err != nil:
+				msg = fmt.Sprintf("Search failed: %s", err)
+			default:
+				msg = "Search finished"
+			}
+			g.buf.AppendLine(NewLineFromString("", nil))
+			g.buf.AppendLine(NewLineFromString(msg, nil))
+			if a.grep == g {
+				a.ShowMessage("%s with %d match(es)", msg, g.matches)
+			}
+		},
+	})
+	a.showGrep(g)
+	return nil
+}
+
+// post adds matches to the grep buffer from the event loop.
+func (g *grepSearch) post(a *App, matches []grepMatch) {
+	if len(matches) == 0 {
+		return
+	}
+	a.Post(func() {
+		for _, m := range matches {
+			g.buf.AppendLine(NewLineFromString(m.text, m.loc))
+		}
+		g.matches += len(matches)
+	})
+}
+
+// grepFile calls f with each line of file rel under dir matching re, until
+// ctx is cancelled.  Binary files, i.e. files containing a NUL byte, are
+// skipped.  Lines longer than maxGrepScanLine bytes are only searched up to
+// that length.
+func grepFile(ctx context.Context, re *regexp.Regexp, dir, rel string, f func(grepMatch)) error {
+	filename := filepath.Join(dir, filepath.FromSlash(rel))
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var found []grepMatch
+	n := 0
+	err = readLines(file, maxGrepScanLine, func(line []byte) error {
+		l := n
+		n++
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if bytes.IndexByte(line, 0) >= 0 {
+			return errBinaryFile
+		}
+		m := re.FindIndex(line)
+		if m == nil {
+			return nil
+		}
+		col := utf8.RuneCount(line[:m[0]])
+		text := []rune(string(bytes.TrimRight(line, "\r")))
+		if len(text) > maxGrepLineLen {
+			text = append(text[:maxGrepLineLen], []rune(" ...")...)
+		}
+		found = append(found, grepMatch{
+			text: fmt.Sprintf("%s:%d:%d: %s", rel, l+1, col+1, string(text)),
+			loc:  &ErrorLocation{File: filename, Line: l, Col: col},
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range found {
+		f(m)
+	}
+	return nil
+}
+
+// showGrep shows the buffer of g in place of the previous grep buffer, or
+// below the focused window, keeping the focus where it is.
+func (a *App) showGrep(g *grepSearch) {
+	var win *Window
+	if old := a.grep; old != nil {
+		win = a.windowShowing(old.buf)
+	}
+	a.grep = g
+	if win != nil {
+		win.SetBuffer(g.buf)
+		return
+	}
+	focused := a.focusedWindow
+	a.ShowBuffer(g.buf)
+	a.FocusWindow(focused)
+}
+
+// visitMatch visits the location of the match at line l of the grep buffer.
+func (a *App) visitMatch(g *grepSearch, l int) error {
+	loc, ok := g.buf.lines[l].Meta.(*ErrorLocation)
+	if !ok {
+		return errors.New("no match on this line")
+	}
+	from := a.windowShowing(g.buf)
+	if from == nil {
+		from = a.focusedWindow
+	} else {
+		from.SetCursorPos(l, 0)
+	}
+	win, err := a.VisitFile(loc.File, from)
+	if err != nil {
+		return err
+	}
+	win.SetCursorPos(loc.Line, loc.Col)
+	return nil
+}
+
+// grepAt returns the search shown in w.
+func (a *App) grepAt(w *Window) *grepSearch {
+	if g := a.grep; g != nil && g.buf == w.buffer {
+		return g
+	}
+	return nil
+}
+
+func CmdGrep(w *Window) {
+	a := w.App()
+	pattern, dir := "", "."
+	if g := a.grep; g != nil {
+		pattern = g.pattern
+	}
+	a.ReadString("Grep for regexp: ", pattern, func(pattern string) {
+		a.ReadString("In directory: ", dir, func(dir string) {
+			if err := a.Grep(pattern, dir); err != nil {
+				a.ShowMessage("Cannot search: %s", err)
+			}
+		})
+	})
+}
+
+func CmdRegrep(w *Window) {
+	a := w.App()
+	if a.grep == nil {
+		CmdGrep(w)
+		return
+	}
+	if err := a.Grep(a.grep.pattern, a.grep.dir); err != nil {
+		a.ShowMessage("Cannot search: %s", err)
+	}
+}
+
+func CmdKillGrep(w *Window) {
+	if g := w.App().grep; g == nil || !g.cancel() {
+		w.App().ShowMessage("No search running")
+	}
+}
+
+func CmdGrepGotoMatch(w *Window) {
+	a := w.App()
+	if g := a.grepAt(w); g != nil {
+		if err := a.visitMatch(g, w.l); err != nil {
+			a.ShowMessage("%s", err)
+		}
+	}
+}
+
+// CmdGrepMouseRelease moves the cursor like CmdMouseButtonUp, and visits the
+// match under the mouse unless a region was highlighted.
+func CmdGrepMouseRelease(pos Position) Action {
+	return func(w *Window) {
+		CmdMouseButtonUp(pos)(w)
+		if _, _, _, _, ok := w.HighlightedRegion(); ok {
+			return
+		}
+		a := w.App()
+		if g := a.grepAt(w); g != nil {
+			if _, ok := g.buf.lines[w.l].Meta.(*ErrorLocation); ok {
+				a.visitMatch(g, w.l)
+			}
+		}
+	}
+}
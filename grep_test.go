@@ -0,0 +1,36 @@
+package edit
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGrepFileLongLines(t *testing.T) {
+	dir := t.TempDir()
+	text := "needle 1\n" + strings.Repeat("x", 2<<20) + " needle\nneedle 3\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "big"), []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile("needle")
+	var lines []int
+	err := grepFile(context.Background(), re, dir, "big", func(m grepMatch) {
+		lines = append(lines, m.loc.Line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The end of the long line is not searched.
+	if len(lines) != 2 || lines[0] != 0 || lines[1] != 2 {
+		t.Errorf("matches on lines %v", lines)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := grepFile(ctx, re, dir, "big", func(grepMatch) {}); err != context.Canceled {
+		t.Errorf("cancelled search: %v", err)
+	}
+}
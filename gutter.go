@@ -0,0 +1,100 @@
+package edit
+
+import "sort"
+
+// A Mark annotates a line of a buffer, e.g. with a diagnostic.  Windows
+// showing a buffer with marks have a gutter at their left where the marks are
+// drawn, and the message of the mark on the cursor line is shown in the status
+// line.
+type Mark struct {
+	Line    int
+	Rune    rune
+	Style   Style
+	Message string
+}
+
+// gutterWidth is the width of the gutter of a window, when it has one.
+const gutterWidth = 2
+
+// SetMarks replaces the marks of buf set by source, e.g. "lsp".
+func (a *App) SetMarks(buf Buffer, source string, marks []Mark) {
+	bufMarks := a.marks[buf]
+	if len(marks) == 0 {
+		delete(bufMarks, source)
+		if len(bufMarks) == 0 {
+			delete(a.marks, buf)
+		}
+		return
+	}
+	if bufMarks == nil {
+		bufMarks = map[string][]Mark{}
+		a.marks[buf] = bufMarks
+	}
+	bufMarks[source] = marks
+}
+
+// Marks returns the marks of buf from all sources, sorted by line.
+func (a *App) Marks(buf Buffer) []Mark {
+	var sources []string
+	for source := range a.marks[buf] {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var marks []Mark
+	for _, source := range sources {
+		marks = append(marks, a.marks[buf][source]...)
+	}
+	sort.SliceStable(marks, func(i, j int) bool {
+		return marks[i].Line < marks[j].Line
+	})
+	return marks
+}
+
+// markAt returns the first mark of buf on line l.
+func (a *App) markAt(buf Buffer, l int) (Mark, bool) {
+	for _, m := range a.Marks(buf) {
+		if m.Line == l {
+			return m, true
+		}
+	}
+	return Mark{}, false
+}
+
+// gutterWidth returns the width of the gutter of the window, 0 if its buffer
+// has no marks.
+func (w *Window) gutterWidth() int {
+	if w.app == nil || len(w.app.marks[w.buffer]) == 0 {
+		return 0
+	}
+	return gutterWidth
+}
+
+// textScreen returns the part of the window's screen where the text of the
+// buffer is drawn, right of the gutter.
+func (w *Window) textScreen(screen ScreenWriter) ScreenWriter {
+	g := w.gutterWidth()
+	if g == 0 {
+		return screen
+	}
+	sz := screen.Size()
+	return screen.SubScreen(Rectangle{
+		Position: Position{X: g},
+		Size:     Size{W: sz.W - g, H: sz.H},
+	})
+}
+
+// drawGutter draws the marks of the visible lines.
+func (w *Window) drawGutter(screen ScreenWriter) {
+	if w.gutterWidth() == 0 {
+		return
+	}
+	h := screen.Size().H
+	for _, m := range w.app.Marks(w.buffer) {
+		if _, hidden := w.foldHiding(m.Line); hidden {
+			continue
+		}
+		if y := w.lineRow(m.Line); y >= 0 && y < h {
+			screen.SetRune(Position{Y: y}, m.Rune, m.Style)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package edit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Binding associates an event sequence with an action in a keymap.
+type Binding struct {
+	Seq     string // as passed to RegisterAction, e.g. "Ctrl-X Ctrl-S"
+	Command string // name of the command, empty if the action is anonymous
+}
+
+// Bindings returns the bindings of the handler, sorted by sequence.
+func (h *EventHandler) Bindings() []Binding {
+	var bindings []Binding
+	for s, sDef := range h.states {
+		if sDef.action == nil {
+			continue
+		}
+		eventNames := strings.Fields(s)
+		for i, field := range sDef.eventFields {
+			if field != "" && i < len(eventNames) {
+				eventNames[i] += "." + field
+			}
+		}
+		bindings = append(bindings, Binding{
+			Seq:     strings.Join(eventNames, " "),
+			Command: sDef.command,
+		})
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].Seq < bindings[j].Seq
+	})
+	return bindings
+}
+
+// describeCommand returns a description of the named command suitable for the
+// status line.
+func (a *App) describeCommand(name string) string {
+	if name == "" {
+		return "an anonymous action"
+	}
+	if cmd := a.GetCommand(name); cmd != nil && cmd.Description != "" {
+		return fmt.Sprintf("%s (%s)", name, cmd.Description)
+	}
+	return name
+}
+
+// DescribeKey reads a key sequence and shows the command it runs, with its
+// description and the keymap defining the binding.
+func (a *App) DescribeKey() {
+	a.ReadKeySequence("Describe key: ", func(res KeyLookup) {
+		if res.Found {
+			a.ShowMessage("%s runs %s in keymap %s", res.Seq, a.describeCommand(res.Command), res.Keymap)
+		} else {
+			a.ShowMessage("%s is undefined", res.Seq)
+		}
+	})
+}
+
+// helpKind is the buffer kind of help buffers.
+const helpKind = "help"
+
+// DescribeBindings shows a help buffer listing the bindings of every keymap,
+// grouped by keymap.  The keymaps currently consulted come first, in order of
+// precedence.
+func (a *App) DescribeBindings() {
+	var handlers []*EventHandler
+	seen := map[*EventHandler]bool{}
+	for _, h := range a.eventHandlerStack() {
+		if !seen[h] {
+			seen[h] = true
+			handlers = append(handlers, h)
+		}
+	}
+	var names []string
+	for name, h := range a.eventHandlers {
+		if !seen[h] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		handlers = append(handlers, a.eventHandlers[name])
+	}
+	buf := &FileBuffer{kind: helpKind, readOnly: true}
+	for _, h := range handlers {
+		bindings := h.Bindings()
+		if len(bindings) == 0 {
+			continue
+		}
+		buf.AppendLine(NewLineFromString(fmt.Sprintf("Keymap %s:", h.Name), nil))
+		width := 0
+		for _, b := range bindings {
+			if len(b.Seq) > width {
+				width = len(b.Seq)
+			}
+		}
+		for _, b := range bindings {
+			line := fmt.Sprintf("  %-*s  %s", width, b.Seq, a.describeCommand(b.Command))
+			buf.AppendLine(NewLineFromString(line, nil))
+		}
+		buf.AppendLine(NewLineFromString("", nil))
+	}
+	if len(buf.lines) == 0 {
+		buf.AppendLine(NewLineFromString("No bindings", nil))
+	}
+	a.ShowBuffer(buf)
+}
@@ -0,0 +1,90 @@
+package edit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// newTestApp returns an App with a single window showing text.
+func newTestApp(text string) (*App, *Window) {
+	buf := &FileBuffer{}
+	for _, l := range strings.Split(text, "\n") {
+		buf.lines = append(buf.lines, NewLineFromString(l, nil))
+	}
+	win := NewWindow(buf)
+	app := NewApp(win)
+	app.Resize(80, 25)
+	win.SetCursorPos(0, 0)
+	return app, win
+}
+
+// bufText returns the text of the buffer shown in w.
+func bufText(w *Window) string {
+	var parts []string
+	for i := 0; i < w.buffer.LineCount(); i++ {
+		l, _ := w.buffer.GetLine(i, 0)
+		parts = append(parts, l.String())
+	}
+	return strings.Join(parts, "\n")
+}
+
+// typeRunes sends a Rune event for each character of s.
+func typeRunes(a *App, s string) {
+	for _, r := range s {
+		a.HandleEvent(Event{EventType: Rune, Rune: r})
+	}
+}
+
+// key sends a Key event.
+func key(a *App, k tcell.Key, mods Modifiers) {
+	a.HandleEvent(Event{EventType: Key, KeyData: KeyData(k), Modifiers: mods})
+}
+
+// pump handles posted events until cond is true, failing the test after a few
+// seconds.
+func pump(t *testing.T, a *App, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		a.HandleEvent(Event{})
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// check fails the test if the buffer shown in w does not contain want.
+func check(t *testing.T, w *Window, want string) {
+	t.Helper()
+	if got := bufText(w); got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+// drawText draws the App on an 80x25 simulation screen and returns its rows.
+func drawText(a *App) []string {
+	sim := tcell.NewSimulationScreen("")
+	sim.Init()
+	sim.SetSize(80, 25)
+	a.Draw(&Screen{tcellScreen: sim})
+	sim.Show()
+	cells, w, h := sim.GetContents()
+	var rows []string
+	for y := 0; y < h; y++ {
+		var row []rune
+		for x := 0; x < w; x++ {
+			c := cells[y*w+x]
+			if len(c.Runes) > 0 {
+				row = append(row, c.Runes[0])
+			} else {
+				row = append(row, ' ')
+			}
+		}
+		rows = append(rows, strings.TrimRight(string(row), " "))
+	}
+	return rows
+}
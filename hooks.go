@@ -0,0 +1,193 @@
+package edit
+
+import (
+	"fmt"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Names of the hooks run by the App.
+const (
+	HookBufferOpened  = "buffer-opened"  // a buffer is shown for the first time
+	HookBeforeSave    = "before-save"    // a buffer is about to be saved
+	HookAfterSave     = "after-save"     // a buffer has been saved
+	HookBufferChanged = "buffer-changed" // the text of a buffer has changed
+	HookCursorMoved   = "cursor-moved"   // an action moved the cursor
+	HookWindowFocused = "window-focused" // another window has been focused
+	HookModeChanged   = "mode-changed"   // the editing mode has changed
+	HookAppQuit       = "app-quit"       // the application is quitting
+)
+
+var hookNames = map[string]bool{
+	HookBufferOpened:  true,
+	HookBeforeSave:    true,
+	HookAfterSave:     true,
+	HookBufferChanged: true,
+	HookCursorMoved:   true,
+	HookWindowFocused: true,
+	HookModeChanged:   true,
+	HookAppQuit:       true,
+}
+
+// A HookEvent is passed to the functions registered for a hook.  Only the
+// fields relevant to the hook are set.
+type HookEvent struct {
+	Name    string
+	Window  *Window
+	Buffer  Buffer
+	Change  *BufferChange // for buffer-changed
+	Mode    string        // for mode-changed
+	OldMode string        // for mode-changed
+}
+
+// A HookFunc is a function registered for a hook.  An error it returns is
+// logged and does not stop the other functions from running.
+type HookFunc func(evt *HookEvent) error
+
+// A HookID identifies a registered hook function so it can be removed.
+type HookID int
+
+type hook struct {
+	id HookID
+	f  HookFunc
+}
+
+// AddHook registers f to run when the named hook is run.  Functions run in the
+// order they were added.
+func (a *App) AddHook(name string, f HookFunc) (HookID, error) {
+	if !hookNames[name] {
+		return 0, fmt.Errorf("unknown hook %q", name)
+	}
+	a.lastHookID++
+	a.hooks[name] = append(a.hooks[name], hook{id: a.lastHookID, f: f})
+	return a.lastHookID, nil
+}
+
+// RemoveHook unregisters a hook function.
+func (a *App) RemoveHook(id HookID) {
+	for name, hooks := range a.hooks {
+		for i, h := range hooks {
+			if h.id == id {
+				a.hooks[name] = append(hooks[:i:i], hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// runHooks runs the functions registered for evt.Name.  A hook does not run
+// again while its functions are running, so e.g. a buffer-changed function may
+// change the buffer.
+func (a *App) runHooks(evt *HookEvent) {
+	if a.runningHooks[evt.Name] {
+		return
+	}
+	a.runningHooks[evt.Name] = true
+	defer delete(a.runningHooks, evt.Name)
+	for _, h := range a.hooks[evt.Name] {
+		if err := h.f(evt); err != nil {
+			a.Logf("Error in %s hook: %s", evt.Name, err)
+		}
+	}
+}
+
+// windowShowing returns the focused window if it shows buf, or else another
+// window showing buf, or nil.
+func (a *App) windowShowing(buf Buffer) *Window {
+	if a.focusedWindow.buffer == buf {
+		return a.focusedWindow
+	}
+	for _, win := range a.windows {
+		if win.buffer == buf {
+			return win
+		}
+	}
+	return nil
+}
+
+// noteBuffer starts tracking buf the first time it is shown in a window.  The
+// buffer-opened hook runs before the next event is handled or the screen is
+// drawn, so that hooks added after the window was created see it.
+func (a *App) noteBuffer(buf Buffer) {
+	if a.openBuffers[buf] {
+		return
+	}
+	a.openBuffers[buf] = true
+	if n, ok := buf.(ChangeNotifier); ok {
+		n.AddChangeListener(func(change BufferChange) {
+			a.runHooks(&HookEvent{
+				Name:   HookBufferChanged,
+				Window: a.windowShowing(buf),
+				Buffer: buf,
+				Change: &change,
+			})
+		})
+	}
+	a.openedBuffers = append(a.openedBuffers, buf)
+}
+
+func (a *App) runBufferOpenedHooks() {
+	for len(a.openedBuffers) > 0 {
+		buf := a.openedBuffers[0]
+		a.openedBuffers = a.openedBuffers[1:]
+		a.runHooks(&HookEvent{
+			Name:   HookBufferOpened,
+			Window: a.windowShowing(buf),
+			Buffer: buf,
+		})
+	}
+}
+
+// SaveBuffer saves buf, running the before-save and after-save hooks.
+func (a *App) SaveBuffer(buf Buffer) error {
+	evt := &HookEvent{
+		Name:   HookBeforeSave,
+		Window: a.windowShowing(buf),
+		Buffer: buf,
+	}
+	a.runHooks(evt)
+	if err := buf.Save(); err != nil {
+		return err
+	}
+	evt.Name = HookAfterSave
+	a.runHooks(evt)
+	return nil
+}
+
+// AddLuaHook registers a Lua function for the named hook.  The function is
+// called with a table with fields name, window, buffer and, depending on the
+// hook, start_line, start_col, end_line, end_col, removed, inserted, mode and
+// old_mode.  The range is that of the inserted text, after the change.
+func (a *App) AddLuaHook(name string, f rt.Value) (HookID, error) {
+	call := a.luaCaller(f)
+	return a.AddHook(name, func(evt *HookEvent) error {
+		_, err := call(a.luaHookEvent(evt))
+		return err
+	})
+}
+
+func (a *App) luaHookEvent(evt *HookEvent) rt.Value {
+	t := rt.NewTable()
+	set := func(k string, v rt.Value) { t.Set(rt.StringValue(k), v) }
+	set("name", rt.StringValue(evt.Name))
+	if evt.Window != nil {
+		set("window", a.LuaWindow(evt.Window))
+	}
+	if evt.Buffer != nil {
+		set("buffer", a.LuaBuffer(evt.Buffer))
+	}
+	if c := evt.Change; c != nil {
+		l1, c1 := c.InsertedEnd()
+		set("start_line", rt.IntValue(int64(c.L0+1)))
+		set("start_col", rt.IntValue(int64(c.C0+1)))
+		set("end_line", rt.IntValue(int64(l1+1)))
+		set("end_col", rt.IntValue(int64(c1+1)))
+		set("removed", rt.StringValue(c.Removed))
+		set("inserted", rt.StringValue(c.Inserted))
+	}
+	if evt.Name == HookModeChanged {
+		set("mode", rt.StringValue(evt.Mode))
+		set("old_mode", rt.StringValue(evt.OldMode))
+	}
+	return rt.TableValue(t)
+}
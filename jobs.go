@@ -0,0 +1,366 @@
+package edit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The App is not safe for concurrent use: it is only used from the goroutine
+// running the event loop.  Other goroutines hand work over to it with Post,
+// which queues a function to run before the next event is handled, and wakes
+// the event loop so that the screen is redrawn afterwards.
+//
+// Jobs build on this: a job runs a function in its own goroutine, or a
+// subprocess, and its output and completion are reported to handlers called
+// from the event loop.
+
+// postQueue holds the functions posted from other goroutines.
+type postQueue struct {
+	mu    sync.Mutex
+	funcs []func()
+	wake  func()
+}
+
+// SetWaker sets the function called to wake the event loop when something is
+// posted.  It must be safe to call from any goroutine, e.g. Screen.Wake.
+func (a *App) SetWaker(wake func()) {
+	a.posted.mu.Lock()
+	a.posted.wake = wake
+	a.posted.mu.Unlock()
+}
+
+// Post queues f to run on the event loop.  It is safe to call from any
+// goroutine.
+func (a *App) Post(f func()) {
+	a.posted.mu.Lock()
+	a.posted.funcs = append(a.posted.funcs, f)
+	wake := a.posted.wake
+	a.posted.mu.Unlock()
+	if wake != nil {
+		wake()
+	}
+}
+
+// PostEvent queues evt to be handled as if it came from the screen.  It is
+// safe to call from any goroutine.
+func (a *App) PostEvent(evt Event) {
+	a.Post(func() { a.HandleEvent(evt) })
+}
+
+// RequestRedraw wakes the event loop so that the screen is redrawn.  It is
+// safe to call from any goroutine.
+func (a *App) RequestRedraw() {
+	a.Post(func() {})
+}
+
+// runPosted runs the functions posted so far.
+func (a *App) runPosted() {
+	a.posted.mu.Lock()
+	funcs := a.posted.funcs
+	a.posted.funcs = nil
+	a.posted.mu.Unlock()
+	for _, f := range funcs {
+		f()
+	}
+}
+
+// JobHandlers are called from the event loop as a job progresses.  Any of them
+// may be nil.
+type JobHandlers struct {
+	OnOutput func(j *Job, line string) // a line of output (stdout for a process)
+	OnError  func(j *Job, line string) // a line of stderr of a process
+	OnExit   func(j *Job, err error)   // the job has finished, err is nil on success
+}
+
+// A Job is some work running outside the event loop.
+type Job struct {
+	ID   int
+	Name string
+
+	app      *App
+	handlers JobHandlers
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stdin    io.WriteCloser // for a process started with StartInteractiveProcess
+	running  bool           // only used from the event loop
+}
+
+// Context returns a context which is cancelled when the job is cancelled.
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Output reports a line of output.  It is safe to call from any goroutine.
+func (j *Job) Output(line string) {
+	j.post(j.handlers.OnOutput, line)
+}
+
+// Error reports a line of error output.  It is safe to call from any
+// goroutine.
+func (j *Job) Error(line string) {
+	j.post(j.handlers.OnError, line)
+}
+
+func (j *Job) post(f func(*Job, string), line string) {
+	if f != nil {
+		j.app.Post(func() { f(j, line) })
+	}
+}
+
+// Cancel asks the job to stop.  A process is killed.  It is safe to call from
+// any goroutine.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Running returns true until the job's OnExit handler has been called.
+func (j *Job) Running() bool {
+	return j.running
+}
+
+// Send writes s to the standard input of the job's process.
+func (j *Job) Send(s string) error {
+	if j.stdin == nil {
+		return errors.New("job has no input")
+	}
+	_, err := io.WriteString(j.stdin, s)
+	return err
+}
+
+// CloseInput closes the standard input of the job's process.
+func (j *Job) CloseInput() error {
+	if j.stdin == nil {
+		return errors.New("job has no input")
+	}
+	return j.stdin.Close()
+}
+
+func (a *App) newJob(name string, h JobHandlers) *Job {
+	a.lastJobID++
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		ID:       a.lastJobID,
+		Name:     name,
+		app:      a,
+		handlers: h,
+		ctx:      ctx,
+		cancel:   cancel,
+		running:  true,
+	}
+	a.jobs[j.ID] = j
+	return j
+}
+
+// finish reports the end of the job from any goroutine.
+func (j *Job) finish(err error) {
+	j.app.Post(func() {
+		j.running = false
+		j.cancel()
+		delete(j.app.jobs, j.ID)
+		if j.handlers.OnExit != nil {
+			j.handlers.OnExit(j, err)
+		}
+	})
+}
+
+// StartJob runs f in a new goroutine.  f should return when the job's context
+// is cancelled.  The error it returns is passed to the OnExit handler.
+func (a *App) StartJob(name string, f func(j *Job) error, h JobHandlers) *Job {
+	j := a.newJob(name, h)
+	go func() {
+		j.finish(f(j))
+	}()
+	return j
+}
+
+// StartProcess starts cmd, which must not have been started.  Its standard
+// output and error are reported line by line.  If cmd.Stdin is nil, the
+// process reads from the null device.  The OnExit handler gets the error
+// returned by cmd.Wait.
+func (a *App) StartProcess(name string, cmd *exec.Cmd, h JobHandlers) (*Job, error) {
+	return a.startProcess(name, cmd, h, false)
+}
+
+// StartInteractiveProcess is like StartProcess, but input is sent to the
+// process with Job.Send until Job.CloseInput is called.  cmd.Stdin must be
+// nil.
+func (a *App) StartInteractiveProcess(name string, cmd *exec.Cmd, h JobHandlers) (*Job, error) {
+	if cmd.Stdin != nil {
+		return nil, errors.New("stdin already set")
+	}
+	return a.startProcess(name, cmd, h, true)
+}
+
+func (a *App) startProcess(name string, cmd *exec.Cmd, h JobHandlers, interactive bool) (*Job, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stdin io.WriteCloser
+	if interactive {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	j := a.newJob(name, h)
+	j.stdin = stdin
+	go func() {
+		<-j.ctx.Done()
+		cmd.Process.Kill()
+		// Children of the process may keep the pipes open.
+		stdout.Close()
+		stderr.Close()
+	}()
+	go func() {
+		var wg sync.WaitGroup
+		var outErr, errErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			outErr = scanLines(stdout, j.Output)
+		}()
+		go func() {
+			defer wg.Done()
+			errErr = scanLines(stderr, j.Error)
+		}()
+		wg.Wait()
+		err := cmd.Wait()
+		if err == nil {
+			err = outErr
+		}
+		if err == nil {
+			err = errErr
+		}
+		j.finish(err)
+	}()
+	return j, nil
+}
+
+// maxOutputLine is the length at which lines of output of processes are
+// truncated.
+const maxOutputLine = 1 << 20
+
+// scanLines calls f with each line read from r until the end of r, truncating
+// lines longer than maxOutputLine.  The pipe is read to the end so that the
+// process is never blocked writing to it.
+func scanLines(r io.Reader, f func(string)) error {
+	return readLines(r, maxOutputLine, func(line []byte) error {
+		f(string(line))
+		return nil
+	})
+}
+
+// readLines calls f with each line read from r, without the end of line,
+// until the end of r or until f returns an error.  Lines longer than max bytes
+// are truncated, the rest of them being skipped.  The line passed to f is only
+// valid until f returns.
+func readLines(r io.Reader, max int, f func(line []byte) error) error {
+	br := bufio.NewReader(r)
+	var line []byte
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n := max - len(line); n > 0 {
+			if len(chunk) > n {
+				chunk = chunk[:n]
+			}
+			line = append(line, chunk...)
+		}
+		if !isPrefix {
+			if err := f(line); err != nil {
+				return err
+			}
+			line = line[:0]
+		}
+	}
+}
+
+// cancelJobs cancels all the running jobs.
+func (a *App) cancelJobs() {
+	for _, j := range a.jobs {
+		j.Cancel()
+	}
+}
+
+// Jobs returns the running jobs, sorted by ID.
+func (a *App) Jobs() []*Job {
+	jobs := make([]*Job, 0, len(a.jobs))
+	for _, j := range a.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs
+}
+
+// A Timer runs a function on the event loop after a delay, possibly
+// repeatedly.
+type Timer struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+// Stop stops the timer.  It is safe to call from any goroutine, and more than
+// once.
+func (t *Timer) Stop() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+// AfterFunc runs f on the event loop after d.
+func (a *App) AfterFunc(d time.Duration, f func()) *Timer {
+	return a.startTimer(d, false, f)
+}
+
+// Every runs f on the event loop every d, until the timer is stopped.
+func (a *App) Every(d time.Duration, f func()) *Timer {
+	return a.startTimer(d, true, f)
+}
+
+func (a *App) startTimer(d time.Duration, repeat bool, f func()) *Timer {
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	t := &Timer{stop: make(chan struct{})}
+	run := func() {
+		select {
+		case <-t.stop:
+		default:
+			f()
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				a.Post(run)
+				if !repeat {
+					return
+				}
+			}
+		}
+	}()
+	return t
+}
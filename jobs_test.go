@@ -0,0 +1,33 @@
+package edit
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestProcessLongLine(t *testing.T) {
+	a, _ := newTestApp("")
+	var lines []string
+	exited := false
+	cmdLine := `head -c 2000000 /dev/zero | tr '\0' x; echo; seq 1 200000`
+	_, err := a.StartProcess("long", exec.Command("sh", "-c", cmdLine), JobHandlers{
+		OnOutput: func(j *Job, line string) { lines = append(lines, line) },
+		OnExit: func(j *Job, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			exited = true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pump(t, a, func() bool { return exited })
+	if len(lines) != 200001 {
+		t.Fatalf("%d lines", len(lines))
+	}
+	if lines[0] != strings.Repeat("x", maxOutputLine) || lines[200000] != "200000" {
+		t.Errorf("lines %d chars, then %q", len(lines[0]), lines[200000])
+	}
+}
@@ -0,0 +1,445 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arnodel/golua/runtime"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Keymaps are event handlers consulted in order of precedence:
+//
+//   - the "completion" keymap, while the completion popup is shown;
+//   - the "snippet" keymap, while a snippet is live;
+//   - the buffer-local keymap of the focused window's buffer;
+//   - the keymaps of the enabled minor modes, most recently enabled first;
+//   - the keymap of the current editing mode (see SetMode);
+//   - the keymap of the buffer kind (the major mode);
+//   - the global keymap.
+//
+// The first keymap which binds an event sequence wins, so a binding shadows
+// bindings for the same sequence in the keymaps below it.  A keymap binding a
+// prefix does not hide the other sequences with the same prefix: e.g. a
+// buffer-local binding for "Ctrl-X x" leaves the global "Ctrl-X Ctrl-S" alone.
+
+// globalKeymap is the name of the keymap consulted last.
+const globalKeymap = "app"
+
+// bufferLocalKeymap is the name given to buffer-local keymaps.
+const bufferLocalKeymap = "buffer-local"
+
+// eventHandlerStack returns the keymaps to consult for an event, in order of
+// precedence.
+func (a *App) eventHandlerStack() []*EventHandler {
+	var handlers []*EventHandler
+	if p := a.completion; p != nil && len(p.shown) > 0 {
+		handlers = append(handlers, a.GetEventHandler(completionKeymap))
+	}
+	if a.snippet != nil {
+		handlers = append(handlers, a.GetEventHandler(snippetKeymap))
+	}
+	if h := a.bufferEventHandlers[a.focusedWindow.buffer]; h != nil {
+		handlers = append(handlers, h)
+	}
+	for i := len(a.minorModes) - 1; i >= 0; i-- {
+		handlers = append(handlers, a.GetEventHandler(minorModeHandlerName(a.minorModes[i])))
+	}
+	// The keymap of read only buffers, e.g. directories, takes precedence over
+	// the editing mode, whose bindings are for changing text.
+	kindHandler := a.focusedWindow.eventHandler
+	if fb, ok := a.focusedWindow.buffer.(*FileBuffer); ok && fb.readOnly && kindHandler != nil {
+		handlers = append(handlers, kindHandler)
+		kindHandler = nil
+	}
+	if a.mode != "" {
+		handlers = append(handlers, a.GetEventHandler(modeHandlerName(a.mode)))
+	}
+	if kindHandler != nil {
+		handlers = append(handlers, kindHandler)
+	}
+	return append(handlers, a.eventHandler)
+}
+
+// BufferEventHandler returns the buffer-local keymap of buf, creating it if
+// necessary.
+func (a *App) BufferEventHandler(buf Buffer) *EventHandler {
+	h, ok := a.bufferEventHandlers[buf]
+	if !ok {
+		h = NewEventHandler()
+		h.Name = bufferLocalKeymap
+		a.bufferEventHandlers[buf] = h
+	}
+	return h
+}
+
+// BindBufferEvents binds a sequence to a Lua function in the buffer-local
+// keymap of buf.
+func (a *App) BindBufferEvents(buf Buffer, seq string, f runtime.Value) error {
+	err := a.BufferEventHandler(buf).RegisterAction(seq, a.LuaActionMaker(f))
+	if err != nil {
+		a.Logf("Error binding events: %s", err)
+	}
+	return err
+}
+
+// UnbindBufferEvents removes a binding from the buffer-local keymap of buf.
+func (a *App) UnbindBufferEvents(buf Buffer, seq string) error {
+	return a.BufferEventHandler(buf).UnregisterAction(seq)
+}
+
+func minorModeHandlerName(mode string) string {
+	return mode + "-minor-mode"
+}
+
+// EnableMinorMode enables a minor mode, whose keymap then takes precedence over
+// those of the other minor modes.
+func (a *App) EnableMinorMode(mode string) {
+	a.DisableMinorMode(mode)
+	a.minorModes = append(a.minorModes, mode)
+}
+
+// DisableMinorMode disables a minor mode.
+func (a *App) DisableMinorMode(mode string) {
+	for i, m := range a.minorModes {
+		if m == mode {
+			a.minorModes = append(a.minorModes[:i], a.minorModes[i+1:]...)
+			return
+		}
+	}
+}
+
+// ToggleMinorMode enables a minor mode if it is disabled, and disables it
+// otherwise.
+func (a *App) ToggleMinorMode(mode string) {
+	if a.MinorModeEnabled(mode) {
+		a.DisableMinorMode(mode)
+	} else {
+		a.EnableMinorMode(mode)
+	}
+}
+
+// MinorModeEnabled returns true if the minor mode is enabled.
+func (a *App) MinorModeEnabled(mode string) bool {
+	for _, m := range a.minorModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// MinorModes returns the enabled minor modes, in the order they were enabled.
+func (a *App) MinorModes() []string {
+	return append([]string(nil), a.minorModes...)
+}
+
+func CmdToggleMinorMode(w *Window) {
+	a := w.App()
+	a.ReadString("Toggle minor mode: ", "", func(mode string) {
+		a.ToggleMinorMode(mode)
+		if a.MinorModeEnabled(mode) {
+			a.ShowMessage("%s minor mode enabled", mode)
+		} else {
+			a.ShowMessage("%s minor mode disabled", mode)
+		}
+	})
+}
+
+// Shadow binds seq to action like RegisterAction, but existing bindings which
+// conflict with it (because seq is a prefix of them or the other way round)
+// are hidden instead of causing an error.  Unshadow restores them.  A nil action
+// makes seq do nothing, which hides the bindings for seq in keymaps of lower
+// precedence.
+func (h *EventHandler) Shadow(seq string, action ActionMaker) error {
+	if h == nil {
+		return errors.New("cannot shadow an action on a nil handler")
+	}
+	if action == nil {
+		action = undefinedActionMaker(seq)
+	}
+	eventNames, eventFields := parseSeq(seq)
+	saved := map[string]stateDef{}
+	s := ""
+	for _, eventName := range eventNames {
+		if sDef := h.states[s]; s != "" && sDef.action != nil {
+			// A shorter sequence is bound, turn it into a prefix.
+			saved[s] = copyStateDef(sDef)
+			sDef.command = ""
+			sDef.action = nil
+			sDef.eventFields = nil
+			h.states[s] = sDef
+		}
+		s = childState(s, eventName)
+	}
+	for k, sDef := range h.states {
+		if k == s || strings.HasPrefix(k, s+" ") {
+			saved[k] = copyStateDef(sDef)
+			delete(h.states, k)
+		}
+	}
+	if h.shadowed == nil {
+		h.shadowed = map[string][]map[string]stateDef{}
+	}
+	h.shadowed[seq] = append(h.shadowed[seq], saved)
+	h.bind(eventNames, eventFields, "", action)
+	return nil
+}
+
+// Unshadow removes the binding made by the last call to Shadow for seq and
+// restores the bindings it hid.
+func (h *EventHandler) Unshadow(seq string) error {
+	if h == nil {
+		return nil
+	}
+	stack := h.shadowed[seq]
+	if len(stack) == 0 {
+		return fmt.Errorf("%s is not shadowed", seq)
+	}
+	saved := stack[len(stack)-1]
+	h.shadowed[seq] = stack[:len(stack)-1]
+	eventNames, _ := parseSeq(seq)
+	s := ""
+	for _, eventName := range eventNames {
+		s = childState(s, eventName)
+	}
+	if sDef, ok := h.states[s]; ok {
+		sDef.command = ""
+		sDef.action = nil
+		sDef.eventFields = nil
+		h.states[s] = sDef
+	}
+	for k, sDef := range saved {
+		cur, ok := h.states[k]
+		if ok {
+			for t, next := range cur.transitions {
+				if sDef.transitions == nil {
+					sDef.transitions = map[string]string{}
+				}
+				sDef.transitions[t] = next
+			}
+		}
+		h.states[k] = sDef
+		// Make sure the restored state can be reached.
+		for k != "" {
+			parent, eventName := parentState(k)
+			pDef := h.states[parent]
+			if pDef.transitions == nil {
+				pDef.transitions = map[string]string{}
+				h.states[parent] = pDef
+			}
+			pDef.transitions[eventName] = k
+			k = parent
+		}
+	}
+	h.prune(s)
+	return nil
+}
+
+func copyStateDef(sDef stateDef) stateDef {
+	if sDef.transitions != nil {
+		transitions := make(map[string]string, len(sDef.transitions))
+		for k, v := range sDef.transitions {
+			transitions[k] = v
+		}
+		sDef.transitions = transitions
+	}
+	return sDef
+}
+
+func undefinedActionMaker(seq string) ActionMaker {
+	return SimpleActionMaker(func(w *Window) {
+		w.App().ShowMessage("%s is undefined", seq)
+	})
+}
+
+// ShadowEvents binds seq to a Lua function in the named keymap, hiding
+// conflicting bindings until UnshadowEvents is called.  If f is nil, seq is
+// made undefined.
+func (a *App) ShadowEvents(name, seq string, f runtime.Value) error {
+	var action ActionMaker
+	if !runtime.IsNil(f) {
+		action = a.LuaActionMaker(f)
+	}
+	return a.GetEventHandler(name).Shadow(seq, action)
+}
+
+// UnshadowEvents undoes the last call to ShadowEvents for seq in the named
+// keymap.
+func (a *App) UnshadowEvents(name, seq string) error {
+	return a.GetEventHandler(name).Unshadow(seq)
+}
+
+// A KeyLookup is the result of looking up an event sequence in the keymaps.
+type KeyLookup struct {
+	Seq     string // names of the events, separated by spaces
+	Keymap  string // name of the keymap which binds the sequence
+	Command string // name of the command bound to the sequence, if any
+	Found   bool   // true if a keymap binds the sequence
+	Prefix  bool   // true if the sequence is the prefix of a binding
+}
+
+// lookup follows the transitions for events from the initial state.  It
+// returns the final state and false if there is no such state.
+func (h *EventHandler) lookup(events []Event) (string, bool) {
+	s := ""
+	for _, evt := range events {
+		sDef := h.states[s]
+		next, ok := sDef.transitions[evt.Name()]
+		if !ok {
+			next, ok = sDef.transitions[evt.EventType.Name()]
+		}
+		if !ok {
+			return "", false
+		}
+		s = next
+	}
+	return s, true
+}
+
+// knowsSequence returns true if one of the handlers binds events or a longer
+// sequence starting with them.
+func knowsSequence(handlers []*EventHandler, events []Event) bool {
+	for _, h := range handlers {
+		if _, ok := h.lookup(events); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupEvents returns which keymap an event sequence resolves to, without
+// running any action.
+func (a *App) LookupEvents(events []Event) KeyLookup {
+	res := KeyLookup{Seq: eventNames(events)}
+	for _, h := range a.eventHandlerStack() {
+		s, ok := h.lookup(events)
+		if !ok {
+			continue
+		}
+		sDef := h.states[s]
+		res.Keymap = h.Name
+		if sDef.action != nil {
+			res.Found = true
+			res.Command = sDef.command
+		} else {
+			res.Prefix = true
+		}
+		return res
+	}
+	return res
+}
+
+// LookupKeys is like LookupEvents but takes the names of the events separated
+// by spaces, e.g. "Ctrl-X Ctrl-S".
+func (a *App) LookupKeys(seq string) (KeyLookup, error) {
+	var events []Event
+	for _, name := range strings.Fields(seq) {
+		evt, err := EventFromName(name)
+		if err != nil {
+			return KeyLookup{}, err
+		}
+		events = append(events, evt)
+	}
+	return a.LookupEvents(events), nil
+}
+
+// A keyReader reads a complete key sequence instead of letting the keymaps run
+// the bound actions.
+type keyReader struct {
+	prompt string
+	events []Event
+	done   func(KeyLookup)
+}
+
+func (r *keyReader) handle(a *App, evt Event) {
+	if evt.EventType == Mouse && evt.Name() == "MouseMove" {
+		return
+	}
+	r.events = append(r.events, evt)
+	res := a.LookupEvents(r.events)
+	if res.Prefix {
+		return
+	}
+	a.keyReader = nil
+	r.done(res)
+}
+
+// ReadKeySequence reads the next complete key sequence, showing prompt in the
+// status line, then calls done with the result of looking it up.
+func (a *App) ReadKeySequence(prompt string, done func(KeyLookup)) {
+	for _, h := range a.eventHandlerStack() {
+		h.Reset()
+	}
+	a.keyReader = &keyReader{prompt: prompt, done: done}
+}
+
+// DescribeKeyBriefly reads a key sequence and shows which keymap it resolves
+// to.
+func (a *App) DescribeKeyBriefly() {
+	a.ReadKeySequence("Describe key briefly: ", func(res KeyLookup) {
+		if res.Found {
+			a.ShowMessage("%s is bound in keymap %s", res.Seq, res.Keymap)
+		} else {
+			a.ShowMessage("%s is undefined", res.Seq)
+		}
+	})
+}
+
+var keysByName map[string]tcell.Key
+
+// EventFromName returns an event whose name is the given name, e.g. "Ctrl-X",
+// "Alt+f" or "Enter".  Mouse and resize events are not supported.
+func EventFromName(name string) (Event, error) {
+	if keysByName == nil {
+		keysByName = make(map[string]tcell.Key, len(tcell.KeyNames))
+		for k, n := range tcell.KeyNames {
+			keysByName[n] = k
+		}
+	}
+	var evt Event
+	rest := name
+	for {
+		switch {
+		case strings.HasPrefix(rest, "Shift+"):
+			evt.Modifiers |= Shift
+			rest = rest[6:]
+			continue
+		case strings.HasPrefix(rest, "Alt+"):
+			evt.Modifiers |= Alt
+			rest = rest[4:]
+			continue
+		case strings.HasPrefix(rest, "Meta+"):
+			evt.Modifiers |= Meta
+			rest = rest[5:]
+			continue
+		}
+		break
+	}
+	if k, ok := keysByName[rest]; ok {
+		evt.EventType = Key
+		evt.KeyData = KeyData(k)
+		if strings.HasPrefix(rest, "Ctrl-") {
+			evt.Modifiers |= Control
+		}
+		return evt, nil
+	}
+	if strings.HasPrefix(rest, "Ctrl-") {
+		evt.Modifiers |= Control
+		rest = rest[5:]
+	}
+	if rest == "Space" {
+		rest = " "
+	}
+	if rest == "Paste" {
+		evt.EventType = Paste
+		return evt, nil
+	}
+	if runes := []rune(rest); len(runes) == 1 {
+		evt.EventType = Rune
+		evt.Rune = runes[0]
+		return evt, nil
+	}
+	return Event{}, fmt.Errorf("unknown event name %q", name)
+}
@@ -0,0 +1,99 @@
+package edit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestBufferLocalPrefixKeepsGlobalBindings(t *testing.T) {
+	a, w := newTestApp("hello")
+	filename := filepath.Join(t.TempDir(), "hello.txt")
+	w.buffer.(*FileBuffer).filename = filename
+	hit := false
+	err := a.BufferEventHandler(w.buffer).RegisterAction("Ctrl-X x", SimpleActionMaker(func(*Window) { hit = true }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key(a, tcell.KeyCtrlX, Control)
+	var keys []string
+	for _, c := range a.Continuations() {
+		keys = append(keys, c.Key)
+	}
+	if !containsString(keys, "x") || !containsString(keys, "Ctrl-S") {
+		t.Errorf("continuations of Ctrl-X: %v", keys)
+	}
+	key(a, tcell.KeyCtrlS, Control)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil || string(data) != "hello\n" {
+		t.Fatalf("Ctrl-X Ctrl-S did not save: %q, %v", data, err)
+	}
+
+	key(a, tcell.KeyCtrlX, Control)
+	typeRunes(a, "x")
+	if !hit {
+		t.Error("Ctrl-X x did not run the buffer-local binding")
+	}
+	check(t, w, "hello")
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDotKeys(t *testing.T) {
+	a, _ := newTestApp("")
+	for _, test := range []struct{ seq, command string }{
+		{"Alt+.", "lsp-definition"},
+		{".", "self-insert"},
+	} {
+		res, err := a.LookupKeys(test.seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Found || res.Command != test.command {
+			t.Errorf("%s: %+v", test.seq, res)
+		}
+	}
+}
+
+func TestReadOnlyKindsInModalEditing(t *testing.T) {
+	a, w := newTestApp("")
+	a.EnableModalEditing()
+	a.ShowLocations("Locations", []string{"a.go:1:1: bad"})
+	for _, test := range []struct {
+		buf      Buffer
+		bindings map[string]string
+	}{
+		{NewDirectoryBuffer(t.TempDir()), map[string]string{
+			"^": "directory-up",
+			"g": "directory-refresh",
+			"+": "directory-create",
+			"R": "directory-rename",
+			"C": "directory-copy",
+			"D": "directory-delete",
+			"j": "vi-move",
+		}},
+		{a.compilation.buf, map[string]string{"g": "recompile", "q": "close-window"}},
+		{&FileBuffer{kind: grepKind, readOnly: true}, map[string]string{"g": "regrep", "k": "kill-grep"}},
+	} {
+		w.SetBuffer(test.buf)
+		for seq, command := range test.bindings {
+			res, err := a.LookupKeys(seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Command != command {
+				t.Errorf("%s in %s buffer: %+v", seq, test.buf.Kind(), res)
+			}
+		}
+	}
+}
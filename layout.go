@@ -0,0 +1,224 @@
+package edit
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Windows are laid out on top of each other, sharing the height of the screen
+// above the status line.  When there are several windows, each one is followed
+// by a mode line showing the name of its buffer.
+
+// BufferName returns a name to display for buf.
+func BufferName(buf Buffer) string {
+	if fb, ok := buf.(*FileBuffer); ok && fb.filename != "" {
+		return fb.filename
+	}
+	return "*" + buf.Kind() + "*"
+}
+
+func (a *App) windowIndex(win *Window) int {
+	for i, w := range a.windows {
+		if w == win {
+			return i
+		}
+	}
+	return -1
+}
+
+// Windows returns the windows on the screen, from top to bottom.
+func (a *App) Windows() []*Window {
+	return append([]*Window(nil), a.windows...)
+}
+
+// FocusedWindow returns the window receiving events.
+func (a *App) FocusedWindow() *Window {
+	return a.focusedWindow
+}
+
+// SplitWindow splits win in two, the bottom half showing buf, and focuses the
+// new window.
+func (a *App) SplitWindow(win *Window, buf Buffer) *Window {
+	newWin := NewWindow(buf)
+	newWin.RegisterWithApp(a)
+	a.insertWindow(win, newWin)
+	a.FocusWindow(newWin)
+	return newWin
+}
+
+// insertWindow lays out newWin below win.
+func (a *App) insertWindow(win, newWin *Window) {
+	i := a.windowIndex(win)
+	if i < 0 {
+		i = len(a.windows) - 1
+	}
+	a.windows = append(a.windows, nil)
+	copy(a.windows[i+2:], a.windows[i+1:])
+	a.windows[i+1] = newWin
+	a.layoutWindows()
+}
+
+// ShowBuffer splits the focused window to show buf.
+func (a *App) ShowBuffer(buf Buffer) *Window {
+	return a.SplitWindow(a.focusedWindow, buf)
+}
+
+// FileBuffer returns the open buffer of the named file, reading it from disk
+// if there is none.
+func (a *App) FileBuffer(filename string) (*FileBuffer, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	for buf := range a.openBuffers {
+		if fb, ok := buf.(*FileBuffer); ok && fb.filename != "" {
+			if fbAbs, err := filepath.Abs(fb.filename); err == nil && fbAbs == abs {
+				return fb, nil
+			}
+		}
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	return a.readFile(filename), nil
+}
+
+// VisitFile shows the named file and focuses its window.  A window already
+// showing the file is reused, otherwise the file is shown in the window below
+// from, which is split if it is the only window.
+func (a *App) VisitFile(filename string, from *Window) (*Window, error) {
+	buf, err := a.FileBuffer(filename)
+	if err != nil {
+		return nil, err
+	}
+	win := a.windowShowing(buf)
+	switch {
+	case win != nil:
+		a.FocusWindow(win)
+	case len(a.windows) == 1:
+		win = a.SplitWindow(from, buf)
+	default:
+		i := a.windowIndex(from)
+		win = a.windows[(i+1)%len(a.windows)]
+		win.SetBuffer(buf)
+		a.FocusWindow(win)
+	}
+	return win, nil
+}
+
+// FocusWindow makes win receive events.
+func (a *App) FocusWindow(win *Window) {
+	if win == a.focusedWindow {
+		return
+	}
+	for _, h := range a.eventHandlerStack() {
+		h.Reset()
+	}
+	a.focusedWindow = win
+	a.noteBuffer(win.buffer)
+	a.runHooks(&HookEvent{Name: HookWindowFocused, Window: win, Buffer: win.buffer})
+}
+
+// OtherWindow focuses the window following the focused one.
+func (a *App) OtherWindow() {
+	i := a.windowIndex(a.focusedWindow)
+	a.FocusWindow(a.windows[(i+1)%len(a.windows)])
+}
+
+// CloseWindow removes a window from the screen.  The last window cannot be
+// closed.
+func (a *App) CloseWindow(win *Window) {
+	i := a.windowIndex(win)
+	if i < 0 {
+		return
+	}
+	if len(a.windows) == 1 {
+		a.ShowMessage("Cannot close the last window")
+		return
+	}
+	a.windows = append(a.windows[:i], a.windows[i+1:]...)
+	if win == a.window {
+		a.window = a.windows[0]
+	}
+	a.layoutWindows()
+	if win == a.focusedWindow {
+		if i > 0 {
+			i--
+		}
+		a.FocusWindow(a.windows[i])
+	}
+}
+
+// layoutWindows shares the screen height between the windows.
+func (a *App) layoutWindows() {
+	n := len(a.windows)
+	avail := a.screenSize.H - 1
+	if n > 1 {
+		avail -= n // mode lines
+	}
+	top := 0
+	for i, win := range a.windows {
+		h := avail / n
+		if i < avail%n {
+			h++
+		}
+		if h < 0 {
+			h = 0
+		}
+		win.top = top
+		win.Resize(a.screenSize.W, h)
+		top += h
+		if n > 1 {
+			top++
+		}
+	}
+}
+
+func (a *App) drawWindows(screen ScreenWriter) {
+	for _, win := range a.windows {
+		wscreen := screen.SubScreen(Rectangle{
+			Position: Position{Y: win.top},
+			Size:     Size{W: win.width, H: win.height},
+		})
+		win.FocusCursor(wscreen)
+		win.Draw(wscreen)
+		if win == a.focusedWindow {
+			win.DrawCursor(wscreen)
+		}
+		if len(a.windows) > 1 {
+			a.drawModeLine(screen.SubScreen(Rectangle{
+				Position: Position{Y: win.top + win.height},
+				Size:     Size{W: win.width, H: 1},
+			}), win)
+		}
+	}
+}
+
+func (a *App) drawModeLine(screen ScreenWriter, win *Window) {
+	style := DefaultStyle.Reverse(true)
+	if win == a.focusedWindow {
+		style = style.Bold(true)
+	}
+	for p := (Position{}); p.X < screen.Size().W; p.X++ {
+		screen.SetRune(p, ' ', style)
+	}
+	WriteString(screen, Position{X: 1}, BufferName(win.buffer), style)
+}
+
+// routeMouseEvent focuses the window under the mouse when a button is pressed,
+// and makes the event position relative to the focused window.
+func (a *App) routeMouseEvent(evt Event) Event {
+	if a.windowIndex(a.focusedWindow) < 0 {
+		return evt
+	}
+	if !evt.ButtonsPressed.Empty() {
+		for _, win := range a.windows {
+			if evt.Y >= win.top && evt.Y < win.top+win.height && win != a.focusedWindow {
+				a.FocusWindow(win)
+				break
+			}
+		}
+	}
+	evt.Y -= a.focusedWindow.top
+	return evt
+}
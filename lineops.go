@@ -0,0 +1,315 @@
+package edit
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Operations on whole lines.  Commands act on the lines of the highlighted
+// region, or on the cursor line (or the whole buffer for sorting and cleaning
+// up) if there is none.  Each operation is undone as one step.
+
+// A LineSort says how SortLines orders lines.
+type LineSort struct {
+	Numeric bool // compare the numbers at the start of lines
+	Reverse bool
+	Unique  bool // remove duplicate lines
+}
+
+// lines returns the text of lines l0 to l1.
+func (w *Window) lines(l0, l1 int) []string {
+	var lines []string
+	for l := l0; l <= l1; l++ {
+		line, _ := w.buffer.GetLine(l, 0)
+		lines = append(lines, string(line.Runes))
+	}
+	return lines
+}
+
+// replaceLines replaces lines l0 to l1 with lines, which can be empty.
+func (w *Window) replaceLines(l0, l1 int, lines []string) error {
+	if len(lines) > 0 {
+		_, _, err := ReplaceRegion(w.buffer, l0, 0, l1, w.lineLen(l1), strings.Join(lines, "\n"))
+		return err
+	}
+	switch {
+	case l1+1 < w.buffer.LineCount():
+		return w.buffer.DeleteRegion(l0, 0, l1+1, 0)
+	case l0 > 0:
+		return w.buffer.DeleteRegion(l0-1, w.lineLen(l0-1), l1, w.lineLen(l1))
+	default:
+		return w.buffer.DeleteRegion(0, 0, l1, w.lineLen(l1))
+	}
+}
+
+// editLines replaces lines l0 to l1 with the result of f, as one undo step.
+func (w *Window) editLines(l0, l1 int, f func(lines []string) []string) (err error) {
+	if l1 < l0 {
+		l0, l1 = l1, l0
+	}
+	if max := w.buffer.LineCount() - 1; l1 > max {
+		l1 = max
+	}
+	lines := f(w.lines(l0, l1))
+	WithUndoGroup(w.buffer, func() { err = w.replaceLines(l0, l1, lines) })
+	return
+}
+
+// MoveLines moves lines l0 to l1 by dl lines, down if dl > 0.  The cursor and
+// the highlighted region move with them.
+func (w *Window) MoveLines(l0, l1, dl int) error {
+	if l1 < l0 {
+		l0, l1 = l1, l0
+	}
+	if l0+dl < 0 {
+		dl = -l0
+	}
+	if max := w.buffer.LineCount() - 1; l1+dl > max {
+		dl = max - l1
+	}
+	if dl == 0 {
+		return errors.New("cannot move lines further")
+	}
+	a, b := l0+dl, l1
+	if dl > 0 {
+		a, b = l0, l1+dl
+	}
+	err := w.editLines(a, b, func(lines []string) []string {
+		n := l1 - l0 + 1
+		if dl > 0 {
+			return append(lines[n:], lines[:n]...)
+		}
+		return append(lines[-dl:], lines[:-dl]...)
+	})
+	if err != nil {
+		return err
+	}
+	w.shiftLines(l0, l1, dl)
+	return nil
+}
+
+// shiftLines moves the cursor, anchor and highlighted region by dl lines if
+// they are in lines l0 to l1.
+func (w *Window) shiftLines(l0, l1, dl int) {
+	shift := func(l *int) {
+		if *l >= l0 && *l <= l1 {
+			*l += dl
+		}
+	}
+	shift(&w.l)
+	if w.HasAnchor() {
+		shift(&w.anchorL)
+	}
+	if w.copyEndC != -1 {
+		shift(&w.copyStartL)
+		shift(&w.copyEndL)
+	}
+}
+
+// DuplicateLines inserts a copy of lines l0 to l1 after them, and moves the
+// cursor and the highlighted region to the copy.
+func (w *Window) DuplicateLines(l0, l1 int) error {
+	if l1 < l0 {
+		l0, l1 = l1, l0
+	}
+	err := w.editLines(l0, l1, func(lines []string) []string {
+		return append(lines, lines...)
+	})
+	if err != nil {
+		return err
+	}
+	w.shiftLines(l0, l1, l1-l0+1)
+	return nil
+}
+
+// JoinLines joins lines l0 to l1, or line l0 and the next one if they are the
+// same.  The indentation of the joined lines is removed and a single space
+// separates them, except after an opening bracket or before a closing one.
+// The cursor goes to the last join.
+func (w *Window) JoinLines(l0, l1 int) error {
+	if l1 < l0 {
+		l0, l1 = l1, l0
+	}
+	if l1 == l0 {
+		l1++
+	}
+	if l1 >= w.buffer.LineCount() {
+		return errors.New("no line to join")
+	}
+	var c int
+	err := w.editLines(l0, l1, func(lines []string) []string {
+		joined := strings.TrimRightFunc(lines[0], unicode.IsSpace)
+		for _, line := range lines[1:] {
+			line = strings.TrimSpace(line)
+			if joined != "" && line != "" && !strings.ContainsAny(joined[len(joined)-1:], "([{") && !strings.ContainsAny(line[:1], ")]},;") {
+				joined += " "
+			}
+			c = len([]rune(joined))
+			joined += line
+		}
+		return []string{joined}
+	})
+	if err != nil {
+		return err
+	}
+	w.SetCursorPos(l0, c)
+	return nil
+}
+
+// numberPrefix matches the number at the start of a line for numeric sorts.
+var numberPrefix = regexp.MustCompile(`^\s*[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?`)
+
+func lineNumber(s string) float64 {
+	x, _ := strconv.ParseFloat(strings.TrimSpace(numberPrefix.FindString(s)), 64)
+	return x
+}
+
+// SortLines sorts lines l0 to l1.  Lines which do not start with a number
+// count as 0 in a numeric sort, and equal numbers are compared as text.
+func (w *Window) SortLines(l0, l1 int, how LineSort) error {
+	err := w.editLines(l0, l1, func(lines []string) []string {
+		less := func(i, j int) bool { return lines[i] < lines[j] }
+		if how.Numeric {
+			less = func(i, j int) bool {
+				x, y := lineNumber(lines[i]), lineNumber(lines[j])
+				if x != y {
+					return x < y
+				}
+				return lines[i] < lines[j]
+			}
+		}
+		if how.Reverse {
+			sort.SliceStable(lines, func(i, j int) bool { return less(j, i) })
+		} else {
+			sort.SliceStable(lines, less)
+		}
+		if how.Unique {
+			unique := lines[:0]
+			for i, line := range lines {
+				if i == 0 || line != lines[i-1] {
+					unique = append(unique, line)
+				}
+			}
+			lines = unique
+		}
+		return lines
+	})
+	if err != nil {
+		return err
+	}
+	w.SetCursorPos(w.l, w.c)
+	return nil
+}
+
+// DeleteBlankLines deletes the lines which only contain spaces in lines l0 to
+// l1.
+func (w *Window) DeleteBlankLines(l0, l1 int) error {
+	err := w.editLines(l0, l1, func(lines []string) []string {
+		var kept []string
+		for _, line := range lines {
+			if strings.TrimSpace(line) != "" {
+				kept = append(kept, line)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		return err
+	}
+	w.SetCursorPos(w.l, w.c)
+	return nil
+}
+
+// TrimTrailingWhitespace removes the spaces at the end of lines l0 to l1.
+// Only the lines which change are replaced.
+func (w *Window) TrimTrailingWhitespace(l0, l1 int) error {
+	if l1 < l0 {
+		l0, l1 = l1, l0
+	}
+	var err error
+	WithUndoGroup(w.buffer, func() {
+		for l, line := range w.lines(l0, l1) {
+			trimmed := strings.TrimRightFunc(line, unicode.IsSpace)
+			if trimmed == line {
+				continue
+			}
+			c := len([]rune(trimmed))
+			if err = w.deleteInLine(l0+l, c, len([]rune(line))-c); err != nil {
+				return
+			}
+		}
+	})
+	return err
+}
+
+// lineRange returns the lines of the highlighted region, or all the lines of
+// the buffer if there is none.
+func (w *Window) lineRange() (int, int) {
+	if _, _, _, _, ok := w.HighlightedRegion(); ok {
+		return w.regionLines()
+	}
+	return 0, w.buffer.LineCount() - 1
+}
+
+// showError shows the error returned by an editing method, if any.
+func showError(w *Window, err error) {
+	if err != nil {
+		w.App().ShowMessage("%s", err)
+	}
+}
+
+func CmdMoveLinesUp(w *Window, n int) {
+	l0, l1 := w.regionLines()
+	showError(w, w.MoveLines(l0, l1, -n))
+}
+
+func CmdMoveLinesDown(w *Window, n int) {
+	l0, l1 := w.regionLines()
+	showError(w, w.MoveLines(l0, l1, n))
+}
+
+func CmdDuplicateLines(w *Window) {
+	l0, l1 := w.regionLines()
+	showError(w, w.DuplicateLines(l0, l1))
+}
+
+func CmdJoinLines(w *Window, n int) {
+	l0, l1 := w.regionLines()
+	if l0 == l1 && n > 1 {
+		l1 = l0 + n - 1
+	}
+	w.ClearAnchor()
+	showError(w, w.JoinLines(l0, l1))
+}
+
+// CmdJoinPreviousLine joins the cursor line to the previous one, like
+// delete-indentation in emacs.
+func CmdJoinPreviousLine(w *Window) {
+	if w.l > 0 {
+		showError(w, w.JoinLines(w.l-1, w.l))
+	}
+}
+
+func CmdSortLines(how LineSort) func(w *Window) {
+	return func(w *Window) {
+		l0, l1 := w.lineRange()
+		w.ClearAnchor()
+		showError(w, w.SortLines(l0, l1, how))
+	}
+}
+
+func CmdDeleteBlankLines(w *Window) {
+	l0, l1 := w.lineRange()
+	w.ClearAnchor()
+	showError(w, w.DeleteBlankLines(l0, l1))
+}
+
+func CmdTrimTrailingWhitespace(w *Window) {
+	l0, l1 := w.lineRange()
+	w.ClearAnchor()
+	showError(w, w.TrimTrailingWhitespace(l0, l1))
+}
@@ -0,0 +1,91 @@
+package edit
+
+import "testing"
+
+func TestSortLines(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		l0, l1 int
+		how    LineSort
+		want   string
+	}{
+		{"c\na\nb", 0, 2, LineSort{}, "a\nb\nc"},
+		{"c\na\nb", 0, 2, LineSort{Reverse: true}, "c\nb\na"},
+		{"c\na\nb\nZ", 0, 1, LineSort{}, "a\nc\nb\nZ"},
+		{"10\n9\n-1.5\nx\n1e1", 0, 4, LineSort{Numeric: true}, "-1.5\nx\n9\n10\n1e1"},
+		{"10 b\n10 a\n 2", 0, 2, LineSort{Numeric: true, Reverse: true}, "10 b\n10 a\n 2"},
+		{"b\na\nb\na", 0, 3, LineSort{Unique: true}, "a\nb"},
+		{"1\n01\n1", 0, 2, LineSort{Numeric: true, Unique: true}, "01\n1"},
+	} {
+		_, w := newTestApp(test.text)
+		if err := w.SortLines(test.l0, test.l1, test.how); err != nil {
+			t.Fatal(err)
+		}
+		if got := bufText(w); got != test.want {
+			t.Errorf("%q %+v: got %q want %q", test.text, test.how, got, test.want)
+		}
+	}
+}
+
+func TestJoinLines(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		l0, l1 int
+		want   string
+		wantC  int
+	}{
+		{"a\nb", 0, 0, "a b", 2},
+		{"a  \n   b\nc", 0, 1, "a b\nc", 2},
+		{"a\nb\n  c", 0, 2, "a b c", 4},
+		{"f(\n  x\n)", 0, 2, "f(x)", 3},
+		{"a\n  ;", 0, 1, "a;", 1},
+		{"a\n\nb", 0, 2, "a b", 2},
+		{"", 0, 0, "", -1},
+	} {
+		_, w := newTestApp(test.text)
+		err := w.JoinLines(test.l0, test.l1)
+		if test.wantC < 0 {
+			if err == nil {
+				t.Errorf("%q: joined", test.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, c := w.CursorPos()
+		if got := bufText(w); got != test.want || c != test.wantC {
+			t.Errorf("%q: got %q at %d want %q at %d", test.text, got, c, test.want, test.wantC)
+		}
+	}
+}
+
+func TestMoveLines(t *testing.T) {
+	for _, test := range []struct {
+		l0, l1, dl int
+		want       string
+		wantL      int
+	}{
+		{1, 1, 1, "0\n2\n1\n3", 2},
+		{1, 2, -1, "1\n2\n0\n3", 0},
+		{0, 1, 5, "2\n3\n0\n1", 2},
+		{2, 3, -1, "0\n2\n3\n1", 1},
+		{0, 0, -1, "0\n1\n2\n3", -1},
+		{3, 3, 1, "0\n1\n2\n3", -1},
+	} {
+		_, w := newTestApp("0\n1\n2\n3")
+		w.SetCursorPos(test.l0, 0)
+		err := w.MoveLines(test.l0, test.l1, test.dl)
+		if test.wantL < 0 {
+			if err == nil {
+				t.Errorf("%+v: moved", test)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		l, _ := w.CursorPos()
+		if got := bufText(w); got != test.want || test.wantL >= 0 && l != test.wantL {
+			t.Errorf("%+v: got %q with the cursor on %d", test, got, l)
+		}
+	}
+}
@@ -0,0 +1,707 @@
+package edit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// The App talks to language servers with the Language Server Protocol, i.e.
+// JSON-RPC messages over the standard input and output of the server.  There
+// is one server per buffer kind, started when the first buffer of that kind
+// read from a file is opened.  The server is told about the buffers of its
+// kind when they are opened, changed and saved.
+//
+// Messages from the server are handled on the event loop, so the client is
+// only used from the event loop, except for the goroutines reading and writing
+// messages.
+
+// A LanguageServer says how to start the language server for a buffer kind.
+type LanguageServer struct {
+	Command []string // command line of the server, run in the current directory
+
+	// Connect, if not nil, is used instead of Command to connect to the
+	// server, e.g. a fake server running in the same process.
+	Connect func() (io.ReadWriteCloser, error)
+}
+
+// SetLanguageServer sets the language server for buffers of the given kind.  A
+// nil ls removes it.  A server already running is not affected.
+func (a *App) SetLanguageServer(kind string, ls *LanguageServer) {
+	if ls == nil {
+		delete(a.languageServers, kind)
+	} else {
+		a.languageServers[kind] = ls
+	}
+}
+
+//
+// Protocol
+//
+
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return e.Message
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+
+	// For a LocationLink
+	TargetURI            string    `json:"targetUri"`
+	TargetSelectionRange *lspRange `json:"targetSelectionRange"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspContentChange struct {
+	Range *lspRange `json:"range,omitempty"`
+	Text  string    `json:"text"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextDocument struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version,omitempty"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+}
+
+// Values of TextDocumentSyncKind
+const (
+	lspSyncNone        = 0
+	lspSyncFull        = 1
+	lspSyncIncremental = 2
+)
+
+// readLSPMessage reads the body of the next message.
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if i := strings.IndexByte(line, ':'); i >= 0 && strings.EqualFold(line[:i], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return nil, fmt.Errorf("bad Content-Length: %s", err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+func writeLSPMessage(w io.Writer, body []byte) error {
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// fileURI returns the file URI of filename.
+func fileURI(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		abs = filename
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// uriFilename returns the filename of a file URI.
+func uriFilename(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("not a file URI: %s", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// utf16Len returns the number of UTF-16 code units encoding runes, which is
+// how LSP positions count characters.
+func utf16Len(runes []rune) int {
+	n := 0
+	for _, r := range runes {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// lspPos returns the LSP position of (l, c) in buf.
+func lspPos(buf Buffer, l, c int) lspPosition {
+	line, err := buf.GetLine(l, 0)
+	if err != nil {
+		return lspPosition{Line: l}
+	}
+	if c > line.Len() {
+		c = line.Len()
+	}
+	return lspPosition{Line: l, Character: utf16Len(line.Runes[:c])}
+}
+
+// bufferPos returns the position in buf of an LSP position.  Positions past
+// the end of the buffer are moved to its end.
+func bufferPos(buf Buffer, p lspPosition) (int, int) {
+	if p.Line >= buf.LineCount() {
+		return buf.EndPos()
+	}
+	line, _ := buf.GetLine(p.Line, 0)
+	n := 0
+	for c, r := range line.Runes {
+		if n >= p.Character {
+			return p.Line, c
+		}
+		n += utf16.RuneLen(r)
+	}
+	return p.Line, line.Len()
+}
+
+// documentText returns the text of buf as saved.
+func documentText(buf *FileBuffer) string {
+	var b strings.Builder
+	for _, line := range buf.lines {
+		b.WriteString(line.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+//
+// Client
+//
+
+type lspClient struct {
+	app  *App
+	kind string
+	job  *Job
+
+	mu     sync.Mutex
+	outbox [][]byte
+	signal chan struct{}
+
+	lastID  int
+	pending map[int]func(json.RawMessage, error)
+	ready   bool     // the server is initialized
+	queued  []func() // run when the server is initialized
+	sync    int      // TextDocumentSyncKind of the server
+	didSave bool     // the server wants didSave notifications
+	docs    map[*FileBuffer]*lspDocument
+}
+
+type lspDocument struct {
+	uri     string
+	version int
+	changes []lspContentChange // not sent yet
+}
+
+// lspClient returns the client for the language server of the kind of buf,
+// starting the server if necessary.  It returns nil if there is no server for
+// buf.
+func (a *App) lspClient(buf Buffer) *lspClient {
+	fb, ok := buf.(*FileBuffer)
+	if !ok || fb.filename == "" {
+		return nil
+	}
+	kind := buf.Kind()
+	if c := a.lspClients[kind]; c != nil {
+		return c
+	}
+	ls := a.languageServers[kind]
+	if ls == nil {
+		return nil
+	}
+	c, err := a.startLanguageServer(kind, ls)
+	if err != nil {
+		a.Logf("Cannot start language server for %s: %s", kind, err)
+		return nil
+	}
+	a.lspClients[kind] = c
+	return c
+}
+
+// processConn is a connection to the standard input and output of a process.
+type processConn struct {
+	io.ReadCloser
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+}
+
+func (p *processConn) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+func (p *processConn) Close() error {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.ReadCloser.Close()
+	return p.cmd.Wait()
+}
+
+func (a *App) startServerProcess(kind string, argv []string) (io.ReadWriteCloser, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("no command")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go scanLines(stderr, func(line string) {
+		a.Post(func() { a.Logf("%s language server: %s", kind, line) })
+	})
+	return &processConn{ReadCloser: stdout, stdin: stdin, cmd: cmd}, nil
+}
+
+func (a *App) startLanguageServer(kind string, ls *LanguageServer) (*lspClient, error) {
+	var conn io.ReadWriteCloser
+	var err error
+	if ls.Connect != nil {
+		conn, err = ls.Connect()
+	} else {
+		conn, err = a.startServerProcess(kind, ls.Command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &lspClient{
+		app:     a,
+		kind:    kind,
+		signal:  make(chan struct{}, 1),
+		pending: map[int]func(json.RawMessage, error){},
+		docs:    map[*FileBuffer]*lspDocument{},
+	}
+	c.job = a.StartJob(kind+" language server", func(j *Job) error {
+		go func() {
+			<-j.Context().Done()
+			conn.Close()
+		}()
+		go c.writeMessages(j, conn)
+		err := c.readMessages(conn)
+		if j.Context().Err() != nil {
+			return nil
+		}
+		return err
+	}, JobHandlers{
+		OnExit: func(j *Job, err error) {
+			c.stopped(err)
+		},
+	})
+	c.initialize()
+	return c, nil
+}
+
+func (c *lspClient) writeMessages(j *Job, w io.Writer) {
+	for {
+		select {
+		case <-j.Context().Done():
+			return
+		case <-c.signal:
+		}
+		c.mu.Lock()
+		msgs := c.outbox
+		c.outbox = nil
+		c.mu.Unlock()
+		for _, msg := range msgs {
+			if err := writeLSPMessage(w, msg); err != nil {
+				j.Cancel()
+				return
+			}
+		}
+	}
+}
+
+func (c *lspClient) readMessages(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		body, err := readLSPMessage(br)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			c.app.Post(func() { c.app.Logf("Bad message from %s language server: %s", c.kind, err) })
+			continue
+		}
+		c.app.Post(func() { c.handle(&msg) })
+	}
+}
+
+// stopped forgets the client once the server has exited.
+func (c *lspClient) stopped(err error) {
+	a := c.app
+	if a.lspClients[c.kind] == c {
+		delete(a.lspClients, c.kind)
+	}
+	for buf := range c.docs {
+		a.SetMarks(buf, lspMarkSource, nil)
+	}
+	c.docs = nil
+	for _, f := range c.pending {
+		f(nil, errors.New("language server exited"))
+	}
+	c.pending = nil
+	if err != nil {
+		a.Logf("%s language server stopped: %s", c.kind, err)
+	} else {
+		a.Logf("%s language server stopped", c.kind)
+	}
+}
+
+func (c *lspClient) send(msg *lspMessage) {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.app.Logf("Cannot encode message to %s language server: %s", c.kind, err)
+		return
+	}
+	c.mu.Lock()
+	c.outbox = append(c.outbox, body)
+	c.mu.Unlock()
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// notify sends a notification.  Params which cannot be marshalled are logged.
+func (c *lspClient) notify(method string, params interface{}) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		c.app.Logf("Cannot send %s to %s language server: %s", method, c.kind, err)
+		return
+	}
+	c.send(&lspMessage{Method: method, Params: data})
+}
+
+// call sends a request once the server is initialized.  The changes to the
+// documents are sent before.  done is called on the event loop with the
+// result.
+func (c *lspClient) call(method string, params interface{}, done func(json.RawMessage, error)) {
+	c.whenReady(func() {
+		c.flushChanges()
+		c.request(method, params, done)
+	})
+}
+
+func (c *lspClient) request(method string, params interface{}, done func(json.RawMessage, error)) {
+	if c.pending == nil {
+		done(nil, errors.New("language server exited"))
+		return
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		done(nil, err)
+		return
+	}
+	c.lastID++
+	c.pending[c.lastID] = done
+	c.send(&lspMessage{
+		ID:     json.RawMessage(strconv.Itoa(c.lastID)),
+		Method: method,
+		Params: data,
+	})
+}
+
+// whenReady runs f when the server is initialized.
+func (c *lspClient) whenReady(f func()) {
+	if c.ready {
+		f()
+	} else {
+		c.queued = append(c.queued, f)
+	}
+}
+
+func (c *lspClient) handle(msg *lspMessage) {
+	switch {
+	case msg.Method != "" && msg.ID != nil:
+		// Requests from the server are not supported, but they must get
+		// a response.
+		c.send(&lspMessage{ID: msg.ID, Result: json.RawMessage("null")})
+	case msg.Method != "":
+		c.handleNotification(msg.Method, msg.Params)
+	default:
+		id, err := strconv.Atoi(string(msg.ID))
+		if err != nil {
+			return
+		}
+		done := c.pending[id]
+		if done == nil {
+			return
+		}
+		delete(c.pending, id)
+		if msg.Error != nil {
+			done(nil, msg.Error)
+		} else {
+			done(msg.Result, nil)
+		}
+	}
+}
+
+func (c *lspClient) handleNotification(method string, params json.RawMessage) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		var p struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		if err := json.Unmarshal(params, &p); err == nil {
+			c.publishDiagnostics(p.URI, p.Diagnostics)
+		}
+	case "window/showMessage":
+		var p struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(params, &p); err == nil {
+			c.app.ShowMessage("%s", p.Message)
+		}
+	case "window/logMessage":
+		var p struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(params, &p); err == nil {
+			c.app.Logf("%s language server: %s", c.kind, p.Message)
+		}
+	}
+}
+
+func (c *lspClient) initialize() {
+	root, _ := os.Getwd()
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI(root),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization":    map[string]interface{}{"didSave": true},
+				"hover":              map[string]interface{}{"contentFormat": []string{"plaintext"}},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+		},
+	}
+	c.request("initialize", params, func(result json.RawMessage, err error) {
+		if err != nil {
+			c.app.Logf("Cannot initialize %s language server: %s", c.kind, err)
+			c.job.Cancel()
+			return
+		}
+		c.setCapabilities(result)
+		c.notify("initialized", struct{}{})
+		c.ready = true
+		for _, f := range c.queued {
+			f()
+		}
+		c.queued = nil
+	})
+}
+
+func (c *lspClient) setCapabilities(result json.RawMessage) {
+	var init struct {
+		Capabilities struct {
+			TextDocumentSync json.RawMessage `json:"textDocumentSync"`
+		} `json:"capabilities"`
+	}
+	c.sync = lspSyncFull
+	if err := json.Unmarshal(result, &init); err != nil {
+		return
+	}
+	sync := init.Capabilities.TextDocumentSync
+	var options struct {
+		Change *int            `json:"change"`
+		Save   json.RawMessage `json:"save"`
+	}
+	if err := json.Unmarshal(sync, &c.sync); err == nil {
+		return
+	}
+	if err := json.Unmarshal(sync, &options); err == nil {
+		if options.Change != nil {
+			c.sync = *options.Change
+		}
+		c.didSave = options.Save != nil && string(options.Save) != "false"
+	}
+}
+
+//
+// Document synchronisation
+//
+
+// openDocument tells the server about buf.
+func (c *lspClient) openDocument(buf *FileBuffer) {
+	if c.docs[buf] != nil {
+		return
+	}
+	doc := &lspDocument{uri: fileURI(buf.filename), version: 1}
+	c.docs[buf] = doc
+	c.whenReady(func() {
+		doc.changes = nil
+		c.notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri":        doc.uri,
+				"languageId": c.kind,
+				"version":    doc.version,
+				"text":       documentText(buf),
+			},
+		})
+	})
+}
+
+// changeDocument records a change to buf, to be sent to the server before the
+// next event is handled.
+func (c *lspClient) changeDocument(buf *FileBuffer, change *BufferChange) {
+	doc := c.docs[buf]
+	if doc == nil {
+		return
+	}
+	if len(doc.changes) == 0 {
+		c.app.Post(func() {
+			c.whenReady(c.flushChanges)
+		})
+	}
+	start := lspPos(buf, change.L0, change.C0)
+	end := start
+	removed := strings.Split(change.Removed, "\n")
+	end.Line += len(removed) - 1
+	if len(removed) > 1 {
+		end.Character = 0
+	}
+	end.Character += utf16Len([]rune(removed[len(removed)-1]))
+	doc.changes = append(doc.changes, lspContentChange{
+		Range: &lspRange{Start: start, End: end},
+		Text:  change.Inserted,
+	})
+}
+
+// flushChanges sends the changes made to the documents.
+func (c *lspClient) flushChanges() {
+	for buf, doc := range c.docs {
+		if len(doc.changes) == 0 {
+			continue
+		}
+		changes := doc.changes
+		doc.changes = nil
+		switch c.sync {
+		case lspSyncNone:
+			continue
+		case lspSyncFull:
+			changes = []lspContentChange{{Text: documentText(buf)}}
+		}
+		doc.version++
+		c.notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   lspTextDocument{URI: doc.uri, Version: doc.version},
+			"contentChanges": changes,
+		})
+	}
+}
+
+// saveDocument tells the server that buf was saved.
+func (c *lspClient) saveDocument(buf *FileBuffer) {
+	doc := c.docs[buf]
+	if doc == nil || !c.didSave {
+		return
+	}
+	c.whenReady(func() {
+		c.flushChanges()
+		c.notify("textDocument/didSave", map[string]interface{}{
+			"textDocument": lspTextDocument{URI: doc.uri},
+		})
+	})
+}
+
+// addLSPHooks keeps the language servers in sync with the buffers.  Changes
+// are followed with a change listener rather than the buffer-changed hook,
+// which does not see the changes made by its own functions.
+func (a *App) addLSPHooks() {
+	a.AddHook(HookBufferOpened, func(evt *HookEvent) error {
+		fb, ok := evt.Buffer.(*FileBuffer)
+		if !ok || fb.filename == "" {
+			return nil
+		}
+		fb.AddChangeListener(func(change BufferChange) {
+			if c := a.lspClients[fb.Kind()]; c != nil {
+				c.changeDocument(fb, &change)
+			}
+		})
+		if c := a.lspClient(fb); c != nil {
+			c.openDocument(fb)
+		}
+		return nil
+	})
+	a.AddHook(HookAfterSave, func(evt *HookEvent) error {
+		if fb, ok := evt.Buffer.(*FileBuffer); ok {
+			if c := a.lspClients[fb.Kind()]; c != nil {
+				c.saveDocument(fb)
+			}
+		}
+		return nil
+	})
+}
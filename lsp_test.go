@@ -0,0 +1,213 @@
+package edit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf16"
+)
+
+// testServer is a language server running in the test process.  It keeps the
+// text of the open documents up to date with incremental changes, reports an
+// error on the second line of documents when they are opened, and says that
+// everything is defined on line 3, column 5.
+type testServer struct {
+	mu      sync.Mutex
+	texts   map[string]string
+	changes []lspContentChange
+	out     io.Writer
+}
+
+// serverConn is the client end of the connection to a testServer.
+type serverConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (c serverConn) Close() error {
+	c.WriteCloser.Close()
+	return c.Reader.(*io.PipeReader).Close()
+}
+
+func newTestServer() (*testServer, *LanguageServer) {
+	s := &testServer{texts: map[string]string{}}
+	return s, &LanguageServer{Connect: func() (io.ReadWriteCloser, error) {
+		clientIn, serverOut := io.Pipe()
+		serverIn, clientOut := io.Pipe()
+		s.out = serverOut
+		go s.run(serverIn)
+		return serverConn{clientIn, clientOut}, nil
+	}}
+}
+
+func (s *testServer) text(uri string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.texts[uri]
+}
+
+func (s *testServer) send(m *lspMessage) {
+	m.JSONRPC = "2.0"
+	body, _ := json.Marshal(m)
+	writeLSPMessage(s.out, body)
+}
+
+func (s *testServer) reply(id json.RawMessage, result interface{}) {
+	data, _ := json.Marshal(result)
+	s.send(&lspMessage{ID: id, Result: data})
+}
+
+func (s *testServer) run(in io.Reader) {
+	r := bufio.NewReader(in)
+	for {
+		body, err := readLSPMessage(r)
+		if err != nil {
+			return
+		}
+		var m lspMessage
+		json.Unmarshal(body, &m)
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+			ContentChanges []lspContentChange `json:"contentChanges"`
+		}
+		json.Unmarshal(m.Params, &p)
+		uri := p.TextDocument.URI
+		switch m.Method {
+		case "initialize":
+			s.reply(m.ID, map[string]interface{}{
+				"capabilities": map[string]interface{}{"textDocumentSync": lspSyncIncremental},
+			})
+		case "textDocument/didOpen":
+			s.mu.Lock()
+			s.texts[uri] = p.TextDocument.Text
+			s.mu.Unlock()
+			diags := []lspDiagnostic{{Range: lspRange{Start: lspPosition{Line: 1}}, Severity: 1, Message: "bad"}}
+			data, _ := json.Marshal(map[string]interface{}{"uri": uri, "diagnostics": diags})
+			s.send(&lspMessage{Method: "textDocument/publishDiagnostics", Params: data})
+		case "textDocument/didChange":
+			s.mu.Lock()
+			text := s.texts[uri]
+			for _, ch := range p.ContentChanges {
+				s.changes = append(s.changes, ch)
+				if ch.Range == nil {
+					text = ch.Text
+					continue
+				}
+				i, j := textOffset(text, ch.Range.Start), textOffset(text, ch.Range.End)
+				text = text[:i] + ch.Text + text[j:]
+			}
+			s.texts[uri] = text
+			s.mu.Unlock()
+		case "textDocument/definition":
+			s.reply(m.ID, []lspLocation{{URI: uri, Range: lspRange{Start: lspPosition{Line: 2, Character: 5}}}})
+		case "shutdown":
+			s.reply(m.ID, nil)
+		}
+	}
+}
+
+// textOffset returns the byte offset of p in text, p counting UTF-16 code
+// units.
+func textOffset(text string, p lspPosition) int {
+	lines := strings.SplitAfter(text, "\n")
+	if p.Line >= len(lines) {
+		return len(text)
+	}
+	off := 0
+	for _, l := range lines[:p.Line] {
+		off += len(l)
+	}
+	n := 0
+	for i, r := range lines[p.Line] {
+		if n >= p.Character {
+			return off + i
+		}
+		n += utf16.RuneLen(r)
+	}
+	return off + len(lines[p.Line])
+}
+
+func TestLanguageServer(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.go")
+	text := "package a\n\nfunc f𝔸() {}\n"
+	if err := ioutil.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf := NewBufferFromFile(filename)
+	w := NewWindow(buf)
+	a := NewApp(w)
+	a.Resize(80, 25)
+	s, ls := newTestServer()
+	a.SetLanguageServer("go", ls)
+	uri := fileURI(filename)
+
+	// didOpen sends the text, and the diagnostic is shown in the gutter.
+	pump(t, a, func() bool { return len(a.Marks(buf)) == 1 })
+	if got := s.text(uri); got != text {
+		t.Errorf("opened with %q", got)
+	}
+	if m, ok := a.markAt(buf, 1); !ok || m.Rune != 'E' || m.Message != "error: bad" {
+		t.Errorf("mark %+v", m)
+	}
+	if rows := drawText(a); rows[0] != "  package a" || rows[1] != "E" {
+		t.Errorf("gutter rows %q", rows[:2])
+	}
+
+	// Changes are sent incrementally, with UTF-16 columns.
+	w.SetCursorPos(2, 8)
+	typeRunes(a, "x")
+	w.SetCursorPos(1, 0)
+	typeRunes(a, "// hi")
+	want := bufText(w) + "\n"
+	pump(t, a, func() bool { return s.text(uri) == want })
+	s.mu.Lock()
+	for _, ch := range s.changes {
+		if ch.Range == nil {
+			t.Errorf("full change %q", ch.Text)
+		}
+	}
+	s.mu.Unlock()
+
+	// Go to definition moves the cursor.
+	w.SetCursorPos(0, 0)
+	CmdGotoDefinition(w)
+	pump(t, a, func() bool {
+		l, c := w.CursorPos()
+		return l == 2 && c == 5
+	})
+
+	a.Quit()
+	pump(t, a, func() bool { return len(a.lspClients) == 0 })
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	insert := func(l, c int, text string) lspTextEdit {
+		p := lspPosition{Line: l, Character: c}
+		return lspTextEdit{Range: lspRange{Start: p, End: p}, NewText: text}
+	}
+	for _, test := range []struct {
+		text  string
+		edits []lspTextEdit
+		want  string
+	}{
+		{"ab", []lspTextEdit{insert(0, 1, "X"), insert(0, 1, "Y")}, "aXYb"},
+		{"ab\ncd", []lspTextEdit{insert(1, 0, "Z"), insert(0, 1, "X"), insert(0, 1, "Y")}, "aXYb\nZcd"},
+		{"ab", []lspTextEdit{{Range: lspRange{End: lspPosition{Character: 1}}, NewText: "A"}, insert(0, 1, "X")}, "AXb"},
+	} {
+		_, w := newTestApp(test.text)
+		if err := applyTextEdits(w.buffer, test.edits); err != nil {
+			t.Fatal(err)
+		}
+		if got := bufText(w); got != test.want {
+			t.Errorf("%q: got %q want %q", test.text, got, test.want)
+		}
+	}
+}
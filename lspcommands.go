@@ -0,0 +1,400 @@
+package edit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// The language server features: diagnostics shown as marks, and commands
+// asking the language server of the buffer of the focused window about the
+// cursor position.
+
+// lspMarkSource is the source of the marks showing diagnostics.
+const lspMarkSource = "lsp"
+
+// lspHoverKind is the buffer kind of hover information too long for the
+// status line.
+const lspHoverKind = "lsp-hover"
+
+var lspSeverities = []struct {
+	name  string
+	mark  rune
+	style Style
+}{
+	1: {"error", 'E', DefaultStyle.Foreground(tcell.ColorRed)},
+	2: {"warning", 'W', DefaultStyle.Foreground(tcell.ColorYellow)},
+	3: {"info", 'I', DefaultStyle.Foreground(tcell.ColorBlue)},
+	4: {"hint", 'H', DefaultStyle.Foreground(tcell.ColorGray)},
+}
+
+func (c *lspClient) publishDiagnostics(uri string, diags []lspDiagnostic) {
+	var buf *FileBuffer
+	for b, doc := range c.docs {
+		if doc.uri == uri {
+			buf = b
+		}
+	}
+	if buf == nil {
+		return
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		di, dj := diags[i], diags[j]
+		if di.Range.Start.Line != dj.Range.Start.Line {
+			return di.Range.Start.Line < dj.Range.Start.Line
+		}
+		return di.Severity != 0 && di.Severity < dj.Severity
+	})
+	var marks []Mark
+	for _, d := range diags {
+		if d.Severity < 1 || d.Severity >= len(lspSeverities) {
+			d.Severity = 1
+		}
+		sev := lspSeverities[d.Severity]
+		msg := strings.SplitN(d.Message, "\n", 2)[0]
+		if d.Source != "" {
+			msg = d.Source + ": " + msg
+		}
+		l, _ := bufferPos(buf, d.Range.Start)
+		marks = append(marks, Mark{
+			Line:    l,
+			Rune:    sev.mark,
+			Style:   sev.style,
+			Message: sev.name + ": " + msg,
+		})
+	}
+	c.app.SetMarks(buf, lspMarkSource, marks)
+}
+
+// lspCall sends a request about the buffer of w to its language server.  done
+// is only called if the request succeeds.
+func (a *App) lspCall(w *Window, method string, params map[string]interface{}, done func(json.RawMessage)) {
+	c := a.lspClient(w.buffer)
+	if c == nil {
+		a.ShowMessage("No language server for %s buffers", w.buffer.Kind())
+		return
+	}
+	fb := w.buffer.(*FileBuffer)
+	c.openDocument(fb)
+	params["textDocument"] = lspTextDocument{URI: c.docs[fb].uri}
+	c.call(method, params, func(result json.RawMessage, err error) {
+		if err != nil {
+			a.ShowMessage("%s failed: %s", method, err)
+			return
+		}
+		done(result)
+	})
+}
+
+// lspPositionCall is like lspCall with the cursor position of w in the params.
+func (a *App) lspPositionCall(w *Window, method string, params map[string]interface{}, done func(json.RawMessage)) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	params["position"] = lspPos(w.buffer, w.l, w.c)
+	a.lspCall(w, method, params, done)
+}
+
+//
+// Hover
+//
+
+// hoverText returns the text of the contents of a Hover.
+func hoverText(contents json.RawMessage) string {
+	var s string
+	if json.Unmarshal(contents, &s) == nil {
+		return s
+	}
+	var markup struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(contents, &markup) == nil && markup.Value != "" {
+		return markup.Value
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(contents, &list) == nil {
+		var parts []string
+		for _, item := range list {
+			if text := hoverText(item); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// Hover shows information about the symbol at the cursor of w, in the status
+// line if it fits on one line.
+func (a *App) Hover(w *Window) {
+	a.lspPositionCall(w, "textDocument/hover", nil, func(result json.RawMessage) {
+		var hover struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		json.Unmarshal(result, &hover)
+		text := strings.TrimSpace(hoverText(hover.Contents))
+		switch {
+		case text == "":
+			a.ShowMessage("No information")
+		case !strings.Contains(text, "\n"):
+			a.ShowMessage("%s", text)
+		default:
+			buf := &FileBuffer{kind: lspHoverKind, readOnly: true}
+			for _, line := range strings.Split(text, "\n") {
+				buf.AppendLine(NewLineFromString(line, nil))
+			}
+			a.ShowBuffer(buf)
+		}
+	})
+}
+
+//
+// Locations
+//
+
+// parseLocations returns the locations in a result which is a Location, a
+// list of Locations or a list of LocationLinks.
+func parseLocations(result json.RawMessage) []lspLocation {
+	var locs []lspLocation
+	if json.Unmarshal(result, &locs) != nil {
+		var loc lspLocation
+		if json.Unmarshal(result, &loc) != nil || loc.URI == "" {
+			return nil
+		}
+		locs = []lspLocation{loc}
+	}
+	for i, loc := range locs {
+		if loc.TargetURI != "" && loc.TargetSelectionRange != nil {
+			locs[i].URI = loc.TargetURI
+			locs[i].Range = *loc.TargetSelectionRange
+		}
+	}
+	return locs
+}
+
+// visitLocation shows the location in w.
+func (a *App) visitLocation(w *Window, loc lspLocation) error {
+	filename, err := uriFilename(loc.URI)
+	if err != nil {
+		return err
+	}
+	buf, err := a.FileBuffer(filename)
+	if err != nil {
+		return err
+	}
+	if w.buffer != buf {
+		w.SetBuffer(buf)
+	}
+	w.SetCursorPos(bufferPos(buf, loc.Range.Start))
+	return nil
+}
+
+// GotoDefinition moves the cursor of w to the definition of the symbol at the
+// cursor.
+func (a *App) GotoDefinition(w *Window) {
+	a.lspPositionCall(w, "textDocument/definition", nil, func(result json.RawMessage) {
+		locs := parseLocations(result)
+		if len(locs) == 0 {
+			a.ShowMessage("No definition found")
+			return
+		}
+		if err := a.visitLocation(w, locs[0]); err != nil {
+			a.ShowMessage("Cannot visit definition: %s", err)
+		}
+	})
+}
+
+// FindReferences lists the references to the symbol at the cursor of w in the
+// compilation buffer.
+func (a *App) FindReferences(w *Window) {
+	params := map[string]interface{}{
+		"context": map[string]bool{"includeDeclaration": true},
+	}
+	a.lspPositionCall(w, "textDocument/references", params, func(result json.RawMessage) {
+		locs := parseLocations(result)
+		if len(locs) == 0 {
+			a.ShowMessage("No references found")
+			return
+		}
+		dir, _ := os.Getwd()
+		buffers := map[string]*FileBuffer{}
+		var lines []string
+		for _, loc := range locs {
+			filename, err := uriFilename(loc.URI)
+			if err != nil {
+				continue
+			}
+			buf, ok := buffers[filename]
+			if !ok {
+				buf, _ = a.FileBuffer(filename)
+				buffers[filename] = buf
+			}
+			name := filename
+			if rel, err := filepath.Rel(dir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+				name = rel
+			}
+			text := ""
+			l, c := loc.Range.Start.Line, loc.Range.Start.Character
+			if buf != nil {
+				l, c = bufferPos(buf, loc.Range.Start)
+				if line, err := buf.GetLine(l, 0); err == nil {
+					text = strings.TrimSpace(line.String())
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%s:%d:%d: %s", name, l+1, c+1, text))
+		}
+		a.ShowLocations(fmt.Sprintf("%d references", len(lines)), lines)
+	})
+}
+
+//
+// Edits
+//
+
+// applyTextEdits applies edits to buf as one undoable step.
+func applyTextEdits(buf Buffer, edits []lspTextEdit) error {
+	// Edits are applied from the last one so that they do not move each
+	// other's positions.  Edits starting at the same position are applied in
+	// reverse order too, so that inserted texts end up in the order of the
+	// edits.
+	order := make([]int, len(edits))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		si, sj := edits[order[i]].Range.Start, edits[order[j]].Range.Start
+		if si != sj {
+			return si.Line > sj.Line || si.Line == sj.Line && si.Character > sj.Character
+		}
+		return order[i] > order[j]
+	})
+	var err error
+	WithUndoGroup(buf, func() {
+		n := buf.LineCount()
+		for _, i := range order {
+			edit := edits[i]
+			text := edit.NewText
+			// The buffer has no empty line after the last newline.
+			if edit.Range.Start.Line >= n {
+				text = "\n" + strings.TrimSuffix(text, "\n")
+			} else if edit.Range.End.Line >= n {
+				text = strings.TrimSuffix(text, "\n")
+			}
+			l0, c0 := bufferPos(buf, edit.Range.Start)
+			l1, c1 := bufferPos(buf, edit.Range.End)
+			if _, _, err = ReplaceRegion(buf, l0, c0, l1, c1, text); err != nil {
+				return
+			}
+		}
+	})
+	return err
+}
+
+// applyWorkspaceEdit applies the edits of a WorkspaceEdit.  Files which were
+// not open are saved.  It returns the number of files changed.
+func (a *App) applyWorkspaceEdit(result json.RawMessage) (int, error) {
+	var edit struct {
+		Changes         map[string][]lspTextEdit `json:"changes"`
+		DocumentChanges []struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+			Edits        []lspTextEdit   `json:"edits"`
+		} `json:"documentChanges"`
+	}
+	if err := json.Unmarshal(result, &edit); err != nil {
+		return 0, err
+	}
+	changes := edit.Changes
+	if len(edit.DocumentChanges) > 0 {
+		changes = map[string][]lspTextEdit{}
+		for _, dc := range edit.DocumentChanges {
+			if dc.TextDocument.URI == "" {
+				return 0, errors.New("creating, renaming and deleting files is not supported")
+			}
+			changes[dc.TextDocument.URI] = append(changes[dc.TextDocument.URI], dc.Edits...)
+		}
+	}
+	for uri, edits := range changes {
+		filename, err := uriFilename(uri)
+		if err != nil {
+			return 0, err
+		}
+		buf, err := a.FileBuffer(filename)
+		if err != nil {
+			return 0, err
+		}
+		if err := applyTextEdits(buf, edits); err != nil {
+			return 0, err
+		}
+		if !a.openBuffers[buf] {
+			if err := buf.Save(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(changes), nil
+}
+
+// Rename renames the symbol at the cursor of w everywhere.
+func (a *App) Rename(w *Window, newName string) {
+	params := map[string]interface{}{"newName": newName}
+	a.lspPositionCall(w, "textDocument/rename", params, func(result json.RawMessage) {
+		n, err := a.applyWorkspaceEdit(result)
+		if err != nil {
+			a.ShowMessage("Cannot rename: %s", err)
+			return
+		}
+		a.ShowMessage("Renamed to %s in %d file(s)", newName, n)
+	})
+}
+
+// FormatBuffer formats the buffer of w.
+func (a *App) FormatBuffer(w *Window) {
+	params := map[string]interface{}{
+		"options": map[string]interface{}{
+			"tabSize":      w.tabSize,
+			"insertSpaces": false,
+		},
+	}
+	buf := w.buffer
+	a.lspCall(w, "textDocument/formatting", params, func(result json.RawMessage) {
+		var edits []lspTextEdit
+		if err := json.Unmarshal(result, &edits); err != nil {
+			a.ShowMessage("Cannot format: %s", err)
+			return
+		}
+		l, c := w.CursorPos()
+		if err := applyTextEdits(buf, edits); err != nil {
+			a.ShowMessage("Cannot format: %s", err)
+			return
+		}
+		if w.buffer == buf {
+			w.SetCursorPos(l, c)
+		}
+	})
+}
+
+func CmdHover(w *Window)          { w.App().Hover(w) }
+func CmdGotoDefinition(w *Window) { w.App().GotoDefinition(w) }
+func CmdFindReferences(w *Window) { w.App().FindReferences(w) }
+func CmdFormatBuffer(w *Window)   { w.App().FormatBuffer(w) }
+
+func CmdRename(w *Window) {
+	a := w.App()
+	c0, c1 := w.wordBounds(w.l, w.c, false)
+	line, _ := w.buffer.GetLine(w.l, 0)
+	name := ""
+	if c1 <= line.Len() {
+		name = string(line.Runes[c0:c1])
+	}
+	a.ReadString("Rename to: ", name, func(newName string) {
+		if newName != "" {
+			a.Rename(w, newName)
+		}
+	})
+}
@@ -0,0 +1,1642 @@
+package edit
+
+import (
+	"github.com/arnodel/golua/lib/golib"
+	"github.com/arnodel/golua/lib/packagelib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// The "edit" Lua module gives scripts access to the editor.  It is available
+// as the global edit and with require("edit").  Lines and columns are counted
+// from 1 and the end of a range is exclusive, so buf:text(1, 1, 2, 1) is the
+// first line including its line break.
+//
+// Module functions:
+//
+//	edit.app()                        the App
+//	edit.log(msg)                     append msg to the log
+//
+// App methods:
+//
+//	app:window()                      the focused Window
+//	app:windows()                     list of the Windows on the screen
+//	app:new_buffer([text [, kind]])   create a Buffer, not shown in any window
+//	app:open(filename)                create a Buffer with the file's contents
+//	app:show(buf)                     split the focused window to show buf
+//	app:log(msg)                      append msg to the log
+//	app:message(msg)                  show msg in the status line
+//	app:mode()                        the current editing mode
+//	app:set_mode(mode)
+//	app:enable_minor_mode(mode)       enable a minor mode, whose keymap is mode.."-minor-mode"
+//	app:disable_minor_mode(mode)
+//	app:toggle_minor_mode(mode)
+//	app:minor_modes()                 list of the enabled minor modes
+//	app:bind(keymap, seq, f)          bind seq to a function in a keymap
+//	app:unbind(keymap, seq)
+//	app:shadow(keymap, seq [, f])     bind seq hiding conflicting bindings, nil f hides seq
+//	app:unshadow(keymap, seq)         undo the last app:shadow for seq
+//	app:lookup_keys(seq)              {keymap=, command=, found=, prefix=} for e.g. "Ctrl-X Ctrl-S"
+//	app:set_key_timeout(keymap, ms)   abandon sequences of keymap after ms milliseconds, 0 for never
+//	app:set_which_key_delay(ms)       show the continuations of a sequence after ms, -1 for never
+//	app:define_command(name, description, f)
+//	app:bind_command(keymap, seq, name)
+//	app:run_command(name [, count])   run a command in the focused window
+//	app:commands()                    sorted list of command names
+//	app:add_hook(name, f)             run f(event) on a hook, returning an id
+//	app:remove_hook(id)
+//	app:plugins()                     names of the plugins in the config directory
+//	app:enable_plugins(names)         only load the listed plugins
+//	app:disable_plugins(names)        do not load the listed plugins
+//	app:reload_config()
+//	app:limits()                      {cpu=, memory=} limits of calls to Lua, 0 for none
+//	app:set_limits(limits)            change some of the limits
+//	app:set_safe_mode(on)             load untrusted plugins in a sandbox
+//	app:trust_plugins(names)          load the listed plugins outside the sandbox
+//	app:start_job(argv [, opts])      start a process, returning a Job (or nil, error)
+//	app:jobs()                        list of the running Jobs
+//	app:after(ms, f)                  call f() after ms milliseconds, returning a Timer
+//	app:every(ms, f)                  call f() every ms milliseconds, returning a Timer
+//	app:set_language_server(kind, argv) use the server started with argv for buffers of kind
+//	app:set_file_kind(ext, kind)      read files with extension ext (e.g. ".go") into buffers of kind
+//	app:add_completion_source(name, f) complete with f(win, prefix), nil f removes it
+//	app:add_snippet(kind, trigger, body [, description]) kind "" for all kinds
+//	app:load_snippets(kind, filename) add the snippets of a JSON file
+//	app:set_fold_provider(kind, p)    p is "indent", "bracket", nil or f(buf) returning {{first, last}, ...}
+//	app:set_syntax(kind, syntax)      change the syntax of buffers of kind (see below)
+//	app:start_macro()                 start recording a macro, abandoning one being recorded
+//	app:stop_macro()                  stop recording, the macro becomes the last one
+//	app:recording_macro()             true if a macro is being recorded
+//	app:name_macro(name)              name the last recorded macro
+//	app:run_macro([name [, count]])   run a named macro, or the last one
+//	app:run_macro_on_region(win [, name]) run a macro on each line of the selection of win
+//	app:save_macros([filename])       save the named macros, by default in the config directory
+//	app:load_macros([filename])
+//	app:quit()
+//
+// Plugins running in the sandbox (see SetSafeMode) cannot call app:open,
+// app:run_command, app:start_job, app:set_language_server, app:load_snippets,
+// app:save_macros, app:load_macros, app:set_limits, app:set_safe_mode,
+// app:trust_plugins, app:enable_plugins, app:disable_plugins,
+// win:run_command and buf:save.
+//
+// Functions bound with app:bind or app:define_command are called with the
+// Window, the fields captured by the key sequence (characters and pasted text
+// as strings, positions as {x=, y=}, sizes as {w=, h=}) and the repeat count.
+//
+// Window methods:
+//
+//	win:buffer()
+//	win:cursor()                      line, column
+//	win:set_cursor(line, col)
+//	win:selection()                   l0, c0, l1, c1 or nil
+//	win:set_selection(l0, c0, l1, c1) select from (l0, c0) to the cursor at (l1, c1)
+//	win:clear_selection()
+//	win:insert(text)                  insert text at the cursor
+//	win:size()                        width, height
+//	win:split([buf])                  split the window, showing buf or the same buffer
+//	win:focus()
+//	win:close()
+//	win:run_command(name [, count])
+//
+// Buffer methods:
+//
+//	buf:line_count()
+//	buf:line(l)                       the Line l
+//	buf:lines([first [, last]])       list of strings, last included
+//	buf:text([l0, c0, l1, c1])        text of the range, or of the whole buffer
+//	buf:set_text(l0, c0, l1, c1, text) replace the range with text
+//	buf:insert(l, c, text)            insert text, returning the end position
+//	buf:append(text)                  append text as new lines
+//	buf:end_pos()                     line, column of the end of the buffer
+//	buf:kind()
+//	buf:name()
+//	buf:save()
+//	buf:bind(seq, f)                  bind seq to a function in the buffer-local keymap
+//	buf:unbind(seq)
+//
+// Line methods:
+//
+//	line:text()                       also tostring(line)
+//	line:len()                        also #line
+//
+// The options of app:start_job are dir, the working directory, stdin, a string
+// to use as the standard input, input, true to write the standard input with
+// job:send instead, and the callbacks on_output(job, line) and
+// on_error(job, line), called for each line of standard output and error, and
+// on_exit(job, status [, error]).  The status is -1 if the process did not
+// exit normally.  Without stdin or input, the process reads no input.
+//
+// Job methods:
+//
+//	job:id()
+//	job:name()
+//	job:send(text)                    write text to the standard input, if started with input
+//	job:close_input()
+//	job:cancel()                      kill the process
+//	job:running()
+//
+// Timer methods:
+//
+//	timer:stop()                      a timer is also stopped if f fails
+//
+// The syntax given to app:set_syntax is a table whose fields change the
+// current syntax of the kind: line_comment (e.g. "//") and block_comment (e.g.
+// {"/*", "*/"}), also used by toggle-comment, quotes and raw_quotes (strings
+// of delimiters, raw strings having no escapes and spanning lines), pairs and
+// auto_pairs (lists of brackets such as "()", auto pairs being closed as they
+// are typed with the auto-pair minor mode).
+//
+// A completion source function returns a list of candidates to replace the
+// word before the cursor, each either a string or a table {text=, detail=}.
+
+// luaAPI holds the state of the "edit" module.  Userdata values are cached so
+// that the same Go value is always the same Lua value.
+type luaAPI struct {
+	appMeta, windowMeta, bufferMeta, lineMeta *rt.Table
+	jobMeta, timerMeta                        *rt.Table
+
+	app     *rt.UserData
+	windows map[*Window]*rt.UserData
+	buffers map[Buffer]*rt.UserData
+	jobs    map[*Job]*rt.UserData
+
+	timers  map[*Timer]bool // started from Lua, stopped on reload
+	started map[*Job]bool   // started from Lua, cancelled on reload
+}
+
+func (a *App) loadLuaAPI() {
+	packagelib.Loader{
+		Name: "edit",
+		Load: a.loadEditModule,
+	}.Run(a.lua)
+}
+
+func (a *App) loadEditModule(r *rt.Runtime) (rt.Value, func()) {
+	api := &luaAPI{
+		appMeta:    luaMetatable(r, "App"),
+		windowMeta: luaMetatable(r, "Window"),
+		bufferMeta: luaMetatable(r, "Buffer"),
+		lineMeta:   luaMetatable(r, "Line"),
+		windows:    map[*Window]*rt.UserData{},
+		buffers:    map[Buffer]*rt.UserData{},
+	}
+	api.app = rt.NewUserData(a, api.appMeta)
+	a.luaAPI = api
+
+	methods := api.appMeta.Get(rt.StringValue("__index")).AsTable()
+	r.SetEnvGoFunc(methods, "window", a.luaAppWindow, 1, false)
+	r.SetEnvGoFunc(methods, "windows", a.luaAppWindows, 1, false)
+	r.SetEnvGoFunc(methods, "new_buffer", a.luaAppNewBuffer, 3, false)
+	r.SetEnvGoFunc(methods, "open", a.notSandboxed("app:open", a.luaAppOpen), 2, false)
+	r.SetEnvGoFunc(methods, "show", a.luaAppShow, 2, false)
+	r.SetEnvGoFunc(methods, "log", a.luaAppLog, 2, false)
+	r.SetEnvGoFunc(methods, "message", a.luaAppMessage, 2, false)
+	r.SetEnvGoFunc(methods, "mode", a.luaAppMode, 1, false)
+	r.SetEnvGoFunc(methods, "set_mode", a.luaAppSetMode, 2, false)
+	r.SetEnvGoFunc(methods, "enable_minor_mode", a.luaAppEnableMinorMode, 2, false)
+	r.SetEnvGoFunc(methods, "disable_minor_mode", a.luaAppDisableMinorMode, 2, false)
+	r.SetEnvGoFunc(methods, "toggle_minor_mode", a.luaAppToggleMinorMode, 2, false)
+	r.SetEnvGoFunc(methods, "minor_modes", a.luaAppMinorModes, 1, false)
+	r.SetEnvGoFunc(methods, "bind", a.luaAppBind, 4, false)
+	r.SetEnvGoFunc(methods, "unbind", a.luaAppUnbind, 3, false)
+	r.SetEnvGoFunc(methods, "shadow", a.luaAppShadow, 4, false)
+	r.SetEnvGoFunc(methods, "unshadow", a.luaAppUnshadow, 3, false)
+	r.SetEnvGoFunc(methods, "lookup_keys", a.luaAppLookupKeys, 2, false)
+	r.SetEnvGoFunc(methods, "set_key_timeout", a.luaAppSetKeyTimeout, 3, false)
+	r.SetEnvGoFunc(methods, "set_which_key_delay", a.luaAppSetWhichKeyDelay, 2, false)
+	r.SetEnvGoFunc(methods, "define_command", a.luaAppDefineCommand, 4, false)
+	r.SetEnvGoFunc(methods, "bind_command", a.luaAppBindCommand, 4, false)
+	r.SetEnvGoFunc(methods, "run_command", a.notSandboxed("app:run_command", a.luaAppRunCommand), 3, false)
+	r.SetEnvGoFunc(methods, "commands", a.luaAppCommands, 1, false)
+	r.SetEnvGoFunc(methods, "add_hook", a.luaAppAddHook, 3, false)
+	r.SetEnvGoFunc(methods, "remove_hook", a.luaAppRemoveHook, 2, false)
+	r.SetEnvGoFunc(methods, "plugins", a.luaAppPlugins, 1, false)
+	r.SetEnvGoFunc(methods, "enable_plugins", a.notSandboxed("app:enable_plugins", a.luaAppEnablePlugins), 2, false)
+	r.SetEnvGoFunc(methods, "disable_plugins", a.notSandboxed("app:disable_plugins", a.luaAppDisablePlugins), 2, false)
+	r.SetEnvGoFunc(methods, "reload_config", a.luaAppReloadConfig, 1, false)
+	r.SetEnvGoFunc(methods, "limits", a.luaAppLimits, 1, false)
+	r.SetEnvGoFunc(methods, "set_limits", a.notSandboxed("app:set_limits", a.luaAppSetLimits), 2, false)
+	r.SetEnvGoFunc(methods, "set_safe_mode", a.notSandboxed("app:set_safe_mode", a.luaAppSetSafeMode), 2, false)
+	r.SetEnvGoFunc(methods, "trust_plugins", a.notSandboxed("app:trust_plugins", a.luaAppTrustPlugins), 2, false)
+	r.SetEnvGoFunc(methods, "set_language_server", a.notSandboxed("app:set_language_server", a.luaAppSetLanguageServer), 3, false)
+	r.SetEnvGoFunc(methods, "set_file_kind", a.luaAppSetFileKind, 3, false)
+	r.SetEnvGoFunc(methods, "add_completion_source", a.luaAppAddCompletionSource, 3, false)
+	r.SetEnvGoFunc(methods, "add_snippet", a.luaAppAddSnippet, 5, false)
+	r.SetEnvGoFunc(methods, "load_snippets", a.notSandboxed("app:load_snippets", a.luaAppLoadSnippets), 3, false)
+	r.SetEnvGoFunc(methods, "set_fold_provider", a.luaAppSetFoldProvider, 3, false)
+	r.SetEnvGoFunc(methods, "set_syntax", a.luaAppSetSyntax, 3, false)
+	r.SetEnvGoFunc(methods, "start_macro", a.luaAppStartMacro, 1, false)
+	r.SetEnvGoFunc(methods, "stop_macro", a.luaAppStopMacro, 1, false)
+	r.SetEnvGoFunc(methods, "recording_macro", a.luaAppRecordingMacro, 1, false)
+	r.SetEnvGoFunc(methods, "name_macro", a.luaAppNameMacro, 2, false)
+	r.SetEnvGoFunc(methods, "run_macro", a.luaAppRunMacro, 3, false)
+	r.SetEnvGoFunc(methods, "run_macro_on_region", a.luaAppRunMacroOnRegion, 3, false)
+	r.SetEnvGoFunc(methods, "save_macros", a.notSandboxed("app:save_macros", a.luaAppSaveMacros), 2, false)
+	r.SetEnvGoFunc(methods, "load_macros", a.notSandboxed("app:load_macros", a.luaAppLoadMacros), 2, false)
+	r.SetEnvGoFunc(methods, "quit", a.luaAppQuit, 1, false)
+
+	methods = api.windowMeta.Get(rt.StringValue("__index")).AsTable()
+	r.SetEnvGoFunc(methods, "buffer", a.luaWindowBuffer, 1, false)
+	r.SetEnvGoFunc(methods, "cursor", a.luaWindowCursor, 1, false)
+	r.SetEnvGoFunc(methods, "set_cursor", a.luaWindowSetCursor, 3, false)
+	r.SetEnvGoFunc(methods, "selection", a.luaWindowSelection, 1, false)
+	r.SetEnvGoFunc(methods, "set_selection", a.luaWindowSetSelection, 5, false)
+	r.SetEnvGoFunc(methods, "clear_selection", a.luaWindowClearSelection, 1, false)
+	r.SetEnvGoFunc(methods, "insert", a.luaWindowInsert, 2, false)
+	r.SetEnvGoFunc(methods, "size", a.luaWindowSize, 1, false)
+	r.SetEnvGoFunc(methods, "split", a.luaWindowSplit, 2, false)
+	r.SetEnvGoFunc(methods, "focus", a.luaWindowFocus, 1, false)
+	r.SetEnvGoFunc(methods, "close", a.luaWindowClose, 1, false)
+	r.SetEnvGoFunc(methods, "run_command", a.notSandboxed("win:run_command", a.luaWindowRunCommand), 3, false)
+
+	methods = api.bufferMeta.Get(rt.StringValue("__index")).AsTable()
+	r.SetEnvGoFunc(methods, "line_count", a.luaBufferLineCount, 1, false)
+	r.SetEnvGoFunc(methods, "line", a.luaBufferLine, 2, false)
+	r.SetEnvGoFunc(methods, "lines", a.luaBufferLines, 3, false)
+	r.SetEnvGoFunc(methods, "text", a.luaBufferText, 5, false)
+	r.SetEnvGoFunc(methods, "set_text", a.luaBufferSetText, 6, false)
+	r.SetEnvGoFunc(methods, "insert", a.luaBufferInsert, 4, false)
+	r.SetEnvGoFunc(methods, "append", a.luaBufferAppend, 2, false)
+	r.SetEnvGoFunc(methods, "end_pos", a.luaBufferEndPos, 1, false)
+	r.SetEnvGoFunc(methods, "kind", a.luaBufferKind, 1, false)
+	r.SetEnvGoFunc(methods, "name", a.luaBufferName, 1, false)
+	r.SetEnvGoFunc(methods, "save", a.notSandboxed("buf:save", a.luaBufferSave), 1, false)
+	r.SetEnvGoFunc(methods, "bind", a.luaBufferBind, 3, false)
+	r.SetEnvGoFunc(methods, "unbind", a.luaBufferUnbind, 2, false)
+
+	methods = api.lineMeta.Get(rt.StringValue("__index")).AsTable()
+	r.SetEnvGoFunc(methods, "text", luaLineText, 1, false)
+	r.SetEnvGoFunc(methods, "len", luaLineLen, 1, false)
+	r.SetEnvGoFunc(api.lineMeta, "__tostring", luaLineText, 1, false)
+	r.SetEnvGoFunc(api.lineMeta, "__len", luaLineLen, 1, false)
+
+	pkg := rt.NewTable()
+	r.SetEnvGoFunc(pkg, "app", a.luaApp, 0, false)
+	r.SetEnvGoFunc(pkg, "log", a.luaLog, 1, false)
+
+	a.loadLuaJobs(r, api)
+
+	declareCompliance(
+		pkg,
+		api.appMeta.Get(rt.StringValue("__index")).AsTable(),
+		api.windowMeta.Get(rt.StringValue("__index")).AsTable(),
+		api.bufferMeta.Get(rt.StringValue("__index")).AsTable(),
+		api.lineMeta.Get(rt.StringValue("__index")).AsTable(),
+		api.lineMeta,
+		api.jobMeta.Get(rt.StringValue("__index")).AsTable(),
+		api.timerMeta.Get(rt.StringValue("__index")).AsTable(),
+	)
+	return rt.TableValue(pkg), nil
+}
+
+// luaMetatable returns a metatable for a userdata type, with an empty table of
+// methods.
+func luaMetatable(r *rt.Runtime, name string) *rt.Table {
+	meta := rt.NewTable()
+	r.SetEnv(meta, "__name", rt.StringValue(name))
+	r.SetEnv(meta, "__index", rt.TableValue(rt.NewTable()))
+	return meta
+}
+
+//
+// Conversion between Go and Lua values
+//
+
+// LuaApp returns the Lua value for the App.
+func (a *App) LuaApp() rt.Value {
+	return rt.UserDataValue(a.luaAPI.app)
+}
+
+// LuaWindow returns the Lua value for win.
+func (a *App) LuaWindow(win *Window) rt.Value {
+	u, ok := a.luaAPI.windows[win]
+	if !ok {
+		u = rt.NewUserData(win, a.luaAPI.windowMeta)
+		a.luaAPI.windows[win] = u
+	}
+	return rt.UserDataValue(u)
+}
+
+// LuaBuffer returns the Lua value for buf.
+func (a *App) LuaBuffer(buf Buffer) rt.Value {
+	u, ok := a.luaAPI.buffers[buf]
+	if !ok {
+		u = rt.NewUserData(buf, a.luaAPI.bufferMeta)
+		a.luaAPI.buffers[buf] = u
+	}
+	return rt.UserDataValue(u)
+}
+
+// LuaLine returns the Lua value for line.
+func (a *App) LuaLine(line Line) rt.Value {
+	return rt.UserDataValue(rt.NewUserData(line, a.luaAPI.lineMeta))
+}
+
+// luaEventArg converts a field captured from an event to a Lua value.
+func (a *App) luaEventArg(arg interface{}) rt.Value {
+	switch x := arg.(type) {
+	case rune:
+		return rt.StringValue(string(x))
+	case string:
+		return rt.StringValue(x)
+	case Position:
+		t := rt.NewTable()
+		t.Set(rt.StringValue("x"), rt.IntValue(int64(x.X)))
+		t.Set(rt.StringValue("y"), rt.IntValue(int64(x.Y)))
+		return rt.TableValue(t)
+	case Size:
+		t := rt.NewTable()
+		t.Set(rt.StringValue("w"), rt.IntValue(int64(x.W)))
+		t.Set(rt.StringValue("h"), rt.IntValue(int64(x.H)))
+		return rt.TableValue(t)
+	default:
+		return golib.NewGoValue(a.lua, arg)
+	}
+}
+
+// stringListArg returns arg n as a list of strings.
+func stringListArg(c *rt.GoCont, n int) ([]string, *rt.Error) {
+	t, err := c.TableArg(n)
+	if err != nil {
+		return nil, err
+	}
+	items := []string{}
+	for i := int64(1); i <= t.Len(); i++ {
+		s, ok := t.Get(rt.IntValue(i)).TryString()
+		if !ok {
+			return nil, rt.NewErrorF("#%d must be a list of strings", n+1)
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+func luaStringList(items []string) rt.Value {
+	t := rt.NewTable()
+	for i, s := range items {
+		t.Set(rt.IntValue(int64(i+1)), rt.StringValue(s))
+	}
+	return rt.TableValue(t)
+}
+
+func appArg(c *rt.GoCont, n int) (*App, *rt.Error) {
+	if u, ok := c.Arg(n).TryUserData(); ok {
+		if a, ok := u.Value().(*App); ok {
+			return a, nil
+		}
+	}
+	return nil, rt.NewErrorF("#%d must be an App", n+1)
+}
+
+func windowArg(c *rt.GoCont, n int) (*Window, *rt.Error) {
+	if u, ok := c.Arg(n).TryUserData(); ok {
+		if w, ok := u.Value().(*Window); ok {
+			return w, nil
+		}
+	}
+	return nil, rt.NewErrorF("#%d must be a Window", n+1)
+}
+
+func bufferArg(c *rt.GoCont, n int) (Buffer, *rt.Error) {
+	if u, ok := c.Arg(n).TryUserData(); ok {
+		if b, ok := u.Value().(Buffer); ok {
+			return b, nil
+		}
+	}
+	return nil, rt.NewErrorF("#%d must be a Buffer", n+1)
+}
+
+func lineArg(c *rt.GoCont, n int) (Line, *rt.Error) {
+	if u, ok := c.Arg(n).TryUserData(); ok {
+		if l, ok := u.Value().(Line); ok {
+			return l, nil
+		}
+	}
+	return Line{}, rt.NewErrorF("#%d must be a Line", n+1)
+}
+
+// posArgs returns the 0-based position from the 1-based line and column in
+// args n and n+1.
+func posArgs(c *rt.GoCont, n int) (int, int, *rt.Error) {
+	l, err := c.IntArg(n)
+	if err != nil {
+		return 0, 0, err
+	}
+	col, err := c.IntArg(n + 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(l) - 1, int(col) - 1, nil
+}
+
+// optIntArg returns arg n as an int, or def if it is nil.
+func optIntArg(c *rt.GoCont, n int, def int) (int, *rt.Error) {
+	if c.Arg(n).IsNil() {
+		return def, nil
+	}
+	i, err := c.IntArg(n)
+	return int(i), err
+}
+
+func pushPos(t *rt.Thread, c *rt.GoCont, l, col int) (rt.Cont, *rt.Error) {
+	return c.PushingNext(t.Runtime, rt.IntValue(int64(l+1)), rt.IntValue(int64(col+1))), nil
+}
+
+//
+// Module functions
+//
+
+func (a *App) luaApp(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return c.PushingNext1(t.Runtime, a.LuaApp()), nil
+}
+
+func (a *App) luaLog(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	msg, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+	a.Log(msg)
+	return c.Next(), nil
+}
+
+//
+// App methods
+//
+
+func (a *App) luaAppWindow(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, a.LuaWindow(a.focusedWindow)), nil
+}
+
+func (a *App) luaAppWindows(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	res := rt.NewTable()
+	for i, win := range a.windows {
+		res.Set(rt.IntValue(int64(i+1)), a.LuaWindow(win))
+	}
+	return c.PushingNext1(t.Runtime, rt.TableValue(res)), nil
+}
+
+func (a *App) luaAppNewBuffer(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	buf := NewEmptyFileBuffer()
+	if !c.Arg(1).IsNil() {
+		text, err := c.StringArg(1)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := buf.InsertString(text, 0, 0); err != nil {
+			return nil, rt.NewErrorE(err)
+		}
+	}
+	if !c.Arg(2).IsNil() {
+		kind, err := c.StringArg(2)
+		if err != nil {
+			return nil, err
+		}
+		buf.kind = kind
+	}
+	return c.PushingNext1(t.Runtime, a.LuaBuffer(buf)), nil
+}
+
+func (a *App) luaAppOpen(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	filename, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, a.LuaBuffer(a.readFile(filename))), nil
+}
+
+func (a *App) luaAppShow(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	buf, err := bufferArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, a.LuaWindow(a.ShowBuffer(buf))), nil
+}
+
+func (a *App) luaAppLog(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	msg, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	a.Log(msg)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppMessage(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	msg, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	a.ShowMessage("%s", msg)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(a.Mode())), nil
+}
+
+func (a *App) luaAppSetMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	mode, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	a.SetMode(mode)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppEnableMinorMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return a.luaMinorMode(c, a.EnableMinorMode)
+}
+
+func (a *App) luaAppDisableMinorMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return a.luaMinorMode(c, a.DisableMinorMode)
+}
+
+func (a *App) luaAppToggleMinorMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return a.luaMinorMode(c, a.ToggleMinorMode)
+}
+
+func (a *App) luaMinorMode(c *rt.GoCont, f func(mode string)) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	mode, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	f(mode)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppMinorModes(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, luaStringList(a.MinorModes())), nil
+}
+
+func (a *App) luaAppBind(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	keymap, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.CallableArg(3); err != nil {
+		return nil, err
+	}
+	if err := a.BindEvents(keymap, seq, c.Arg(3)); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppUnbind(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	keymap, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.UnbindEvents(keymap, seq); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppShadow(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	keymap, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Arg(3).IsNil() {
+		if _, err := c.CallableArg(3); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.ShadowEvents(keymap, seq, c.Arg(3)); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppUnshadow(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	keymap, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.UnshadowEvents(keymap, seq); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppLookupKeys(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	res, lookupErr := a.LookupKeys(seq)
+	if lookupErr != nil {
+		return nil, rt.NewErrorE(lookupErr)
+	}
+	tbl := rt.NewTable()
+	tbl.Set(rt.StringValue("keymap"), rt.StringValue(res.Keymap))
+	tbl.Set(rt.StringValue("command"), rt.StringValue(res.Command))
+	tbl.Set(rt.StringValue("found"), rt.BoolValue(res.Found))
+	tbl.Set(rt.StringValue("prefix"), rt.BoolValue(res.Prefix))
+	return c.PushingNext1(t.Runtime, rt.TableValue(tbl)), nil
+}
+
+func (a *App) luaAppSetKeyTimeout(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	keymap, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := c.IntArg(2)
+	if err != nil {
+		return nil, err
+	}
+	a.SetKeyTimeout(keymap, int(ms))
+	return c.Next(), nil
+}
+
+func (a *App) luaAppSetWhichKeyDelay(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	ms, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+	a.SetWhichKeyDelay(int(ms))
+	return c.Next(), nil
+}
+
+func (a *App) luaAppDefineCommand(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	name, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	description, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.CallableArg(3); err != nil {
+		return nil, err
+	}
+	if err := a.DefineCommand(name, description, c.Arg(3)); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppBindCommand(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	keymap, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	name, err := c.StringArg(3)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.BindCommand(keymap, seq, name); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppRunCommand(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	return a.luaRunCommand(a.focusedWindow, c)
+}
+
+func (a *App) luaRunCommand(win *Window, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	name, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	count, err := optIntArg(c, 2, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.RunCommand(win, name, count); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppCommands(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, cmd := range a.Commands() {
+		names = append(names, cmd.Name)
+	}
+	return c.PushingNext1(t.Runtime, luaStringList(names)), nil
+}
+
+func (a *App) luaAppAddHook(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	name, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.CallableArg(2); err != nil {
+		return nil, err
+	}
+	id, hookErr := a.AddLuaHook(name, c.Arg(2))
+	if hookErr != nil {
+		return nil, rt.NewErrorE(hookErr)
+	}
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(id))), nil
+}
+
+func (a *App) luaAppRemoveHook(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	id, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+	a.RemoveHook(HookID(id))
+	return c.Next(), nil
+}
+
+func (a *App) luaAppPlugins(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, luaStringList(a.Plugins())), nil
+}
+
+func (a *App) luaAppEnablePlugins(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	names, err := stringListArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	a.SetEnabledPlugins(names)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppDisablePlugins(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	names, err := stringListArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	a.SetDisabledPlugins(names)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppReloadConfig(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	if err := a.ReloadConfig(); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppLimits(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	res := rt.NewTable()
+	res.Set(rt.StringValue("cpu"), rt.IntValue(int64(a.luaLimits.CPU)))
+	res.Set(rt.StringValue("memory"), rt.IntValue(int64(a.luaLimits.Memory)))
+	return c.PushingNext1(t.Runtime, rt.TableValue(res)), nil
+}
+
+func (a *App) luaAppSetLimits(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	tbl, err := c.TableArg(1)
+	if err != nil {
+		return nil, err
+	}
+	limits := a.luaLimits
+	for _, f := range []struct {
+		name  string
+		limit *uint64
+	}{{"cpu", &limits.CPU}, {"memory", &limits.Memory}} {
+		v := tbl.Get(rt.StringValue(f.name))
+		if v.IsNil() {
+			continue
+		}
+		n, ok := v.TryInt()
+		if !ok || n < 0 {
+			return nil, rt.NewErrorF("%s must be a non-negative integer", f.name)
+		}
+		*f.limit = uint64(n)
+	}
+	a.SetLuaLimits(limits)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppSetSafeMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	a.SetSafeMode(rt.Truth(c.Arg(1)))
+	return c.Next(), nil
+}
+
+func (a *App) luaAppTrustPlugins(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	names, err := stringListArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	a.TrustPlugins(names)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppSetLanguageServer(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	kind, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if c.Arg(2).IsNil() {
+		a.SetLanguageServer(kind, nil)
+		return c.Next(), nil
+	}
+	argv, err := stringListArg(c, 2)
+	if err != nil {
+		return nil, err
+	}
+	a.SetLanguageServer(kind, &LanguageServer{Command: argv})
+	return c.Next(), nil
+}
+
+func (a *App) luaAppSetFileKind(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	ext, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	kind, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	a.SetFileKind(ext, kind)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppAddCompletionSource(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	name, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if c.Arg(2).IsNil() {
+		a.RemoveCompletionSource(name)
+		return c.Next(), nil
+	}
+	if _, err := c.CallableArg(2); err != nil {
+		return nil, err
+	}
+	a.AddLuaCompletionSource(name, c.Arg(2))
+	return c.Next(), nil
+}
+
+func (a *App) luaAppAddSnippet(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	var args [3]string
+	for i := range args {
+		s, err := c.StringArg(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = s
+	}
+	desc := ""
+	if !c.Arg(4).IsNil() {
+		s, err := c.StringArg(4)
+		if err != nil {
+			return nil, err
+		}
+		desc = s
+	}
+	a.AddSnippet(args[0], Snippet{Trigger: args[1], Body: args[2], Description: desc})
+	return c.Next(), nil
+}
+
+func (a *App) luaAppLoadSnippets(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	kind, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	filename, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.LoadSnippets(kind, filename); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppSetFoldProvider(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	kind, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	p := c.Arg(2)
+	if name, ok := p.TryString(); ok {
+		provider := namedFoldProviders[name]
+		if provider == nil {
+			return nil, rt.NewErrorF("unknown fold provider %q", name)
+		}
+		a.SetFoldProvider(kind, provider)
+		return c.Next(), nil
+	}
+	if p.IsNil() {
+		a.SetFoldProvider(kind, nil)
+		return c.Next(), nil
+	}
+	if _, err := c.CallableArg(2); err != nil {
+		return nil, err
+	}
+	a.SetFoldProvider(kind, a.luaFoldProvider(p))
+	return c.Next(), nil
+}
+
+// luaFoldProvider returns a provider calling f with a buffer, which returns a
+// list of {first, last} line ranges.
+func (a *App) luaFoldProvider(f rt.Value) FoldProvider {
+	call := a.luaCaller(f)
+	return func(buf Buffer) []FoldRange {
+		res, err := call(a.LuaBuffer(buf))
+		if err != nil {
+			a.Logf("Fold provider: %s", err)
+			return nil
+		}
+		list, _ := res.TryTable()
+		if list == nil {
+			return nil
+		}
+		var ranges []FoldRange
+		for i := int64(1); i <= list.Len(); i++ {
+			r, _ := list.Get(rt.IntValue(i)).TryTable()
+			if r == nil {
+				continue
+			}
+			first, ok1 := r.Get(rt.IntValue(1)).TryInt()
+			last, ok2 := r.Get(rt.IntValue(2)).TryInt()
+			if ok1 && ok2 && first < last {
+				ranges = append(ranges, FoldRange{int(first) - 1, int(last) - 1})
+			}
+		}
+		return ranges
+	}
+}
+
+func (a *App) luaAppSetSyntax(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	kind, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	tbl, err := c.TableArg(2)
+	if err != nil {
+		return nil, err
+	}
+	syn := *a.Syntax(kind)
+	get := func(name string) rt.Value { return tbl.Get(rt.StringValue(name)) }
+	str := func(name string, s *string) *rt.Error {
+		v := get(name)
+		if v.IsNil() {
+			return nil
+		}
+		var ok bool
+		if *s, ok = v.TryString(); !ok {
+			return rt.NewErrorF("%s must be a string", name)
+		}
+		return nil
+	}
+	list := func(name string, items *[]string) *rt.Error {
+		v := get(name)
+		if v.IsNil() {
+			return nil
+		}
+		l, ok := v.TryTable()
+		if !ok {
+			return rt.NewErrorF("%s must be a list of strings", name)
+		}
+		*items = []string{}
+		for i := int64(1); i <= l.Len(); i++ {
+			s, ok := l.Get(rt.IntValue(i)).TryString()
+			if !ok {
+				return rt.NewErrorF("%s must be a list of strings", name)
+			}
+			*items = append(*items, s)
+		}
+		return nil
+	}
+	var block []string
+	for _, err := range []*rt.Error{
+		str("line_comment", &syn.LineComment),
+		str("quotes", &syn.Quotes),
+		str("raw_quotes", &syn.RawQuotes),
+		list("block_comment", &block),
+		list("pairs", &syn.Pairs),
+		list("auto_pairs", &syn.AutoPairs),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch len(block) {
+	case 0:
+		if !get("block_comment").IsNil() {
+			syn.BlockComment = [2]string{}
+		}
+	case 2:
+		syn.BlockComment = [2]string{block[0], block[1]}
+	default:
+		return nil, rt.NewErrorS("block_comment must have a start and an end")
+	}
+	a.SetSyntax(kind, &syn)
+	return c.Next(), nil
+}
+
+func (a *App) luaAppStartMacro(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	a.StartMacroRecording()
+	return c.Next(), nil
+}
+
+func (a *App) luaAppStopMacro(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	if err := a.StopMacroRecording(); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppRecordingMacro(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.BoolValue(a.RecordingMacro())), nil
+}
+
+func (a *App) luaAppNameMacro(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	name, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.NameLastMacro(name); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppRunMacro(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	name := ""
+	if !c.Arg(1).IsNil() {
+		var err *rt.Error
+		if name, err = c.StringArg(1); err != nil {
+			return nil, err
+		}
+	}
+	count, err := optIntArg(c, 2, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.RunMacro(name, count); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppRunMacroOnRegion(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	win, err := windowArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	name := ""
+	if !c.Arg(2).IsNil() {
+		if name, err = c.StringArg(2); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.RunMacroOnRegion(win, name); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppSaveMacros(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return a.luaMacroFile(c, a.SaveMacros)
+}
+
+func (a *App) luaAppLoadMacros(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return a.luaMacroFile(c, a.LoadMacros)
+}
+
+func (a *App) luaMacroFile(c *rt.GoCont, f func(filename string) error) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	filename := MacroFile()
+	if !c.Arg(1).IsNil() {
+		var err *rt.Error
+		if filename, err = c.StringArg(1); err != nil {
+			return nil, err
+		}
+	}
+	if err := f(filename); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaAppQuit(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	a.Quit()
+	return c.Next(), nil
+}
+
+//
+// Window methods
+//
+
+func (a *App) luaWindowBuffer(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, a.LuaBuffer(win.buffer)), nil
+}
+
+func (a *App) luaWindowCursor(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return pushPos(t, c, win.l, win.c)
+}
+
+func (a *App) luaWindowSetCursor(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l, col, err := posArgs(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	win.SetCursorPos(l, col)
+	win.updateAnchoredRegion()
+	return c.Next(), nil
+}
+
+func (a *App) luaWindowSelection(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l0, c0, l1, c1, ok := win.HighlightedRegion()
+	if !ok {
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+	// The highlighted region includes its last character.
+	l1, c1 = win.buffer.AdvancePos(l1, c1, 0, 1)
+	return c.PushingNext(t.Runtime,
+		rt.IntValue(int64(l0+1)), rt.IntValue(int64(c0+1)),
+		rt.IntValue(int64(l1+1)), rt.IntValue(int64(c1+1))), nil
+}
+
+func (a *App) luaWindowSetSelection(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l0, c0, err := posArgs(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	l1, c1, err := posArgs(c, 3)
+	if err != nil {
+		return nil, err
+	}
+	win.SetCursorPos(l0, c0)
+	win.SetAnchor(false)
+	win.SetCursorPos(l1, c1)
+	win.updateAnchoredRegion()
+	return c.Next(), nil
+}
+
+func (a *App) luaWindowClearSelection(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	win.ClearAnchor()
+	return c.Next(), nil
+}
+
+func (a *App) luaWindowInsert(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	text, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := win.PasteString(text); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaWindowSize(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext(t.Runtime, rt.IntValue(int64(win.width)), rt.IntValue(int64(win.height))), nil
+}
+
+func (a *App) luaWindowSplit(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := win.buffer
+	if !c.Arg(1).IsNil() {
+		buf, err = bufferArg(c, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.PushingNext1(t.Runtime, a.LuaWindow(a.SplitWindow(win, buf))), nil
+}
+
+func (a *App) luaWindowFocus(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	a.FocusWindow(win)
+	return c.Next(), nil
+}
+
+func (a *App) luaWindowClose(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	a.CloseWindow(win)
+	return c.Next(), nil
+}
+
+func (a *App) luaWindowRunCommand(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	win, err := windowArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.luaRunCommand(win, c)
+}
+
+//
+// Buffer methods
+//
+
+func (a *App) luaBufferLineCount(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(buf.LineCount()))), nil
+}
+
+func (a *App) luaBufferLine(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+	line, lineErr := buf.GetLine(int(l)-1, 0)
+	if lineErr != nil {
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+	return c.PushingNext1(t.Runtime, a.LuaLine(line)), nil
+}
+
+func (a *App) luaBufferLines(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	first, err := optIntArg(c, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	last, err := optIntArg(c, 2, buf.LineCount())
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for l := first - 1; l < last; l++ {
+		line, lineErr := buf.GetLine(l, 0)
+		if lineErr != nil {
+			break
+		}
+		lines = append(lines, line.String())
+	}
+	return c.PushingNext1(t.Runtime, luaStringList(lines)), nil
+}
+
+// rangeArgs returns the 0-based range in args n to n+3, or the whole buffer if
+// they are nil.
+func rangeArgs(c *rt.GoCont, n int, buf Buffer) (l0, c0, l1, c1 int, err *rt.Error) {
+	if c.Arg(n).IsNil() {
+		l1, c1 = buf.EndPos()
+		return
+	}
+	if l0, c0, err = posArgs(c, n); err != nil {
+		return
+	}
+	l1, c1, err = posArgs(c, n+2)
+	return
+}
+
+func (a *App) luaBufferText(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l0, c0, l1, c1, err := rangeArgs(c, 1, buf)
+	if err != nil {
+		return nil, err
+	}
+	text, textErr := RegionString(buf, l0, c0, l1, c1)
+	if textErr != nil {
+		return nil, rt.NewErrorE(textErr)
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(text)), nil
+}
+
+func (a *App) luaBufferSetText(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l0, c0, l1, c1, err := rangeArgs(c, 1, buf)
+	if err != nil {
+		return nil, err
+	}
+	text, err := c.StringArg(5)
+	if err != nil {
+		return nil, err
+	}
+	l, col, setErr := ReplaceRegion(buf, l0, c0, l1, c1, text)
+	if setErr != nil {
+		return nil, rt.NewErrorE(setErr)
+	}
+	return pushPos(t, c, l, col)
+}
+
+func (a *App) luaBufferInsert(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l, col, err := posArgs(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	text, err := c.StringArg(3)
+	if err != nil {
+		return nil, err
+	}
+	l, col, insErr := buf.InsertString(text, l, col)
+	if insErr != nil {
+		return nil, rt.NewErrorE(insErr)
+	}
+	return pushPos(t, c, l, col)
+}
+
+func (a *App) luaBufferAppend(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	text, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range splitString(text) {
+		buf.AppendLine(NewLineFromString(s, nil))
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaBufferEndPos(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	l, col := buf.EndPos()
+	return pushPos(t, c, l, col)
+}
+
+func (a *App) luaBufferKind(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(buf.Kind())), nil
+}
+
+func (a *App) luaBufferName(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(BufferName(buf))), nil
+}
+
+func (a *App) luaBufferSave(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.SaveBuffer(buf); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaBufferBind(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.CallableArg(2); err != nil {
+		return nil, err
+	}
+	if err := a.BindBufferEvents(buf, seq, c.Arg(2)); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaBufferUnbind(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	buf, err := bufferArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.UnbindBufferEvents(buf, seq); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+//
+// Line methods
+//
+
+func luaLineText(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	line, err := lineArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(line.String())), nil
+}
+
+func luaLineLen(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	line, err := lineArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(line.Len()))), nil
+}
@@ -0,0 +1,291 @@
+package edit
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Jobs and timers in the "edit" Lua module.  Their callbacks are called from
+// the event loop, like bindings.
+
+func (a *App) loadLuaJobs(r *rt.Runtime, api *luaAPI) {
+	api.jobMeta = luaMetatable(r, "Job")
+	api.timerMeta = luaMetatable(r, "Timer")
+	api.jobs = map[*Job]*rt.UserData{}
+	api.timers = map[*Timer]bool{}
+	api.started = map[*Job]bool{}
+
+	methods := api.appMeta.Get(rt.StringValue("__index")).AsTable()
+	r.SetEnvGoFunc(methods, "start_job", a.notSandboxed("app:start_job", a.luaAppStartJob), 3, false)
+	r.SetEnvGoFunc(methods, "jobs", a.luaAppJobs, 1, false)
+	r.SetEnvGoFunc(methods, "after", a.luaAppAfter, 3, false)
+	r.SetEnvGoFunc(methods, "every", a.luaAppEvery, 3, false)
+
+	methods = api.jobMeta.Get(rt.StringValue("__index")).AsTable()
+	r.SetEnvGoFunc(methods, "id", a.luaJobID, 1, false)
+	r.SetEnvGoFunc(methods, "name", a.luaJobName, 1, false)
+	r.SetEnvGoFunc(methods, "send", a.luaJobSend, 2, false)
+	r.SetEnvGoFunc(methods, "close_input", a.luaJobCloseInput, 1, false)
+	r.SetEnvGoFunc(methods, "cancel", a.luaJobCancel, 1, false)
+	r.SetEnvGoFunc(methods, "running", a.luaJobRunning, 1, false)
+
+	methods = api.timerMeta.Get(rt.StringValue("__index")).AsTable()
+	r.SetEnvGoFunc(methods, "stop", a.luaTimerStop, 1, false)
+}
+
+// LuaJob returns the Lua value for j.
+func (a *App) LuaJob(j *Job) rt.Value {
+	u, ok := a.luaAPI.jobs[j]
+	if !ok {
+		u = rt.NewUserData(j, a.luaAPI.jobMeta)
+		a.luaAPI.jobs[j] = u
+	}
+	return rt.UserDataValue(u)
+}
+
+func jobArg(c *rt.GoCont, n int) (*Job, *rt.Error) {
+	if u, ok := c.Arg(n).TryUserData(); ok {
+		if j, ok := u.Value().(*Job); ok {
+			return j, nil
+		}
+	}
+	return nil, rt.NewErrorF("#%d must be a Job", n+1)
+}
+
+func timerArg(c *rt.GoCont, n int) (*Timer, *rt.Error) {
+	if u, ok := c.Arg(n).TryUserData(); ok {
+		if t, ok := u.Value().(*Timer); ok {
+			return t, nil
+		}
+	}
+	return nil, rt.NewErrorF("#%d must be a Timer", n+1)
+}
+
+// exitCode returns the exit status of a process from the error returned by
+// Wait, or -1 if it did not exit normally.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// luaJobLineHandler returns a handler calling the function in field name of
+// opts with the job and the line, or nil if there is no such function.
+func (a *App) luaJobLineHandler(opts *rt.Table, name string) func(*Job, string) {
+	f := opts.Get(rt.StringValue(name))
+	if f.IsNil() {
+		return nil
+	}
+	return func(j *Job, line string) {
+		if !a.luaAPI.started[j] {
+			// The job was cancelled when the configuration was reloaded.
+			return
+		}
+		if _, err := a.callLua(f, a.LuaJob(j), rt.StringValue(line)); err != nil {
+			a.Logf("Error in %s: %s", name, err)
+		}
+	}
+}
+
+func (a *App) luaAppStartJob(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	argv, err := stringListArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(argv) == 0 {
+		return nil, rt.NewErrorS("#2 must not be empty")
+	}
+	opts := rt.NewTable()
+	if !c.Arg(2).IsNil() {
+		if opts, err = c.TableArg(2); err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if dir, ok := opts.Get(rt.StringValue("dir")).TryString(); ok {
+		cmd.Dir = dir
+	}
+	start := a.StartProcess
+	if stdin, ok := opts.Get(rt.StringValue("stdin")).TryString(); ok {
+		cmd.Stdin = strings.NewReader(stdin)
+	} else if rt.Truth(opts.Get(rt.StringValue("input"))) {
+		start = a.StartInteractiveProcess
+	}
+	onExit := opts.Get(rt.StringValue("on_exit"))
+	job, startErr := start(argv[0], cmd, JobHandlers{
+		OnOutput: a.luaJobLineHandler(opts, "on_output"),
+		OnError:  a.luaJobLineHandler(opts, "on_error"),
+		OnExit: func(j *Job, err error) {
+			defer delete(a.luaAPI.jobs, j)
+			started := a.luaAPI.started[j]
+			delete(a.luaAPI.started, j)
+			if onExit.IsNil() || !started {
+				return
+			}
+			args := []rt.Value{a.LuaJob(j), rt.IntValue(int64(exitCode(err)))}
+			if err != nil {
+				args = append(args, rt.StringValue(err.Error()))
+			}
+			if _, err := a.callLua(onExit, args...); err != nil {
+				a.Logf("Error in on_exit: %s", err)
+			}
+		},
+	})
+	if startErr != nil {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(startErr.Error())), nil
+	}
+	a.luaAPI.started[job] = true
+	return c.PushingNext1(t.Runtime, a.LuaJob(job)), nil
+}
+
+func (a *App) luaAppJobs(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	res := rt.NewTable()
+	for i, j := range a.Jobs() {
+		res.Set(rt.IntValue(int64(i+1)), a.LuaJob(j))
+	}
+	return c.PushingNext1(t.Runtime, rt.TableValue(res)), nil
+}
+
+func (a *App) luaAppAfter(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return a.luaStartTimer(t, c, false)
+}
+
+func (a *App) luaAppEvery(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	return a.luaStartTimer(t, c, true)
+}
+
+func (a *App) luaStartTimer(t *rt.Thread, c *rt.GoCont, repeat bool) (rt.Cont, *rt.Error) {
+	if _, err := appArg(c, 0); err != nil {
+		return nil, err
+	}
+	ms, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+	f := c.Arg(2)
+	if _, ok := f.TryCallable(); !ok {
+		return nil, rt.NewErrorS("#3 must be a function")
+	}
+	call := a.luaCaller(f)
+	var timer *Timer
+	timer = a.startTimer(time.Duration(ms)*time.Millisecond, repeat, func() {
+		if !repeat {
+			delete(a.luaAPI.timers, timer)
+		}
+		if _, err := call(); err != nil {
+			a.Logf("Error in timer: %s", err)
+			a.stopLuaTimer(timer)
+		}
+	})
+	a.luaAPI.timers[timer] = true
+	return c.PushingNext1(t.Runtime, rt.UserDataValue(rt.NewUserData(timer, a.luaAPI.timerMeta))), nil
+}
+
+//
+// Job methods
+//
+
+func (a *App) luaJobID(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	j, err := jobArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(j.ID))), nil
+}
+
+func (a *App) luaJobName(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	j, err := jobArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(j.Name)), nil
+}
+
+func (a *App) luaJobSend(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	j, err := jobArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.Send(s); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaJobCloseInput(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	j, err := jobArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.CloseInput(); err != nil {
+		return nil, rt.NewErrorE(err)
+	}
+	return c.Next(), nil
+}
+
+func (a *App) luaJobCancel(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	j, err := jobArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	j.Cancel()
+	return c.Next(), nil
+}
+
+func (a *App) luaJobRunning(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	j, err := jobArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.BoolValue(j.Running())), nil
+}
+
+//
+// Timer methods
+//
+
+func (a *App) luaTimerStop(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	timer, err := timerArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	a.stopLuaTimer(timer)
+	return c.Next(), nil
+}
+
+func (a *App) stopLuaTimer(timer *Timer) {
+	timer.Stop()
+	delete(a.luaAPI.timers, timer)
+}
+
+// stopLuaTasks stops the timers and cancels the jobs started from Lua.  The
+// callbacks of the jobs are not called any more.
+func (a *App) stopLuaTasks() {
+	for timer := range a.luaAPI.timers {
+		a.stopLuaTimer(timer)
+	}
+	for j := range a.luaAPI.started {
+		delete(a.luaAPI.started, j)
+		j.Cancel()
+	}
+}
@@ -0,0 +1,195 @@
+package edit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// maxMacroDepth limits how deeply macros can invoke other macros, so that a
+// macro which runs itself does not loop forever.
+const maxMacroDepth = 16
+
+// A Macro is a recorded sequence of events that can be replayed.
+type Macro struct {
+	Name   string
+	Events []Event
+}
+
+// macroRecorder accumulates events while a macro is being recorded.  Events are
+// kept pending until they trigger an action, so that incomplete or invalid key
+// sequences, and the sequence that stops the recording, are not recorded.
+type macroRecorder struct {
+	macro   *Macro
+	pending []Event
+}
+
+func (r *macroRecorder) add(evt Event) {
+	r.pending = append(r.pending, evt)
+}
+
+func (r *macroRecorder) commit() {
+	r.macro.Events = append(r.macro.Events, r.pending...)
+	r.pending = nil
+}
+
+func (r *macroRecorder) discard() {
+	r.pending = nil
+}
+
+// commitMacroEvents records the pending events in the macro being recorded,
+// if any.
+func (a *App) commitMacroEvents() {
+	if a.macroRecorder != nil && a.macroDepth == 0 {
+		a.macroRecorder.commit()
+	}
+}
+
+// recordable returns true if the event is worth recording in a macro.
+func recordable(evt Event) bool {
+	switch evt.EventType {
+	case NoEvent, Resize:
+		return false
+	case Mouse:
+		return evt.Name() != "MouseMove"
+	default:
+		return true
+	}
+}
+
+// StartMacroRecording starts recording a new macro.  Any recording in
+// progress is abandoned.
+func (a *App) StartMacroRecording() {
+	a.macroRecorder = &macroRecorder{macro: &Macro{}}
+	a.Log("Recording macro")
+}
+
+// StopMacroRecording stops recording the current macro, which then becomes the
+// last macro.
+func (a *App) StopMacroRecording() error {
+	if a.macroRecorder == nil {
+		return errors.New("not recording a macro")
+	}
+	a.lastMacro = a.macroRecorder.macro
+	a.macroRecorder = nil
+	a.Logf("Recorded macro with %d events", len(a.lastMacro.Events))
+	return nil
+}
+
+// RecordingMacro returns true if a macro is being recorded.
+func (a *App) RecordingMacro() bool {
+	return a.macroRecorder != nil
+}
+
+// NameLastMacro gives a name to the last recorded macro so that it can be run,
+// saved and loaded by name.
+func (a *App) NameLastMacro(name string) error {
+	if a.lastMacro == nil {
+		return errors.New("no macro recorded")
+	}
+	m := &Macro{Name: name, Events: a.lastMacro.Events}
+	a.macros[name] = m
+	return nil
+}
+
+// GetMacro returns the macro with the given name.  The empty name refers to
+// the last recorded macro.
+func (a *App) GetMacro(name string) (*Macro, error) {
+	if name == "" {
+		if a.lastMacro == nil {
+			return nil, errors.New("no macro recorded")
+		}
+		return a.lastMacro, nil
+	}
+	m, ok := a.macros[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown macro %q", name)
+	}
+	return m, nil
+}
+
+// RunMacro replays the macro with the given name n times in the focused
+// window.  The empty name refers to the last recorded macro.
+func (a *App) RunMacro(name string, n int) error {
+	m, err := a.GetMacro(name)
+	if err != nil {
+		return err
+	}
+	return a.replayMacro(m, n)
+}
+
+// RunMacroOnRegion replays the macro with the given name once for each line
+// of the highlighted region in the window, with the cursor at the start of the
+// line.
+func (a *App) RunMacroOnRegion(win *Window, name string) error {
+	m, err := a.GetMacro(name)
+	if err != nil {
+		return err
+	}
+	l0, _, l1, _, ok := win.HighlightedRegion()
+	if !ok {
+		return errors.New("no highlight region")
+	}
+	win.ResetHighlightRegion()
+	for l := l0; l <= l1 && l < win.buffer.LineCount(); l++ {
+		count := win.buffer.LineCount()
+		win.l, win.c = win.buffer.AdvancePos(l, 0, 0, 0)
+		if err := a.replayMacro(m, 1); err != nil {
+			return err
+		}
+		// Account for lines added or removed by the macro.
+		l1 += win.buffer.LineCount() - count
+	}
+	return nil
+}
+
+func (a *App) replayMacro(m *Macro, n int) error {
+	if a.macroDepth >= maxMacroDepth {
+		return errors.New("macro nested too deeply")
+	}
+	a.macroDepth++
+	defer func() { a.macroDepth-- }()
+	a.focusedWindow.eventHandler.Reset()
+	a.eventHandler.Reset()
+	for i := 0; i < n && a.running; i++ {
+		for _, evt := range m.Events {
+			a.HandleEvent(evt)
+		}
+	}
+	return nil
+}
+
+// MacroFile returns the file where named macros are saved by default,
+// macros.json in the configuration directory.
+func MacroFile() string {
+	return filepath.Join(ConfigDir(), "macros.json")
+}
+
+// SaveMacros writes all named macros to a file in JSON format.
+func (a *App) SaveMacros(filename string) error {
+	data, err := json.MarshalIndent(a.macros, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// LoadMacros reads named macros from a file written by SaveMacros.  They are
+// added to the existing named macros, replacing those with the same name.
+func (a *App) LoadMacros(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var macros map[string]*Macro
+	if err := json.Unmarshal(data, &macros); err != nil {
+		return err
+	}
+	for name, m := range macros {
+		m.Name = name
+		a.macros[name] = m
+	}
+	return nil
+}
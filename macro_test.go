@@ -0,0 +1,60 @@
+package edit
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestLuaMacros(t *testing.T) {
+	a, w := newTestApp("a\nb\nc")
+	lua := func(code string) {
+		t.Helper()
+		if err := a.InitLuaCode("test", []byte(code)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lua(`edit.app():start_macro()`)
+	if !a.RecordingMacro() {
+		t.Fatal("not recording")
+	}
+	typeRunes(a, "-")
+	lua(`local app = edit.app() app:stop_macro() assert(not app:recording_macro()) app:name_macro("dash")`)
+	check(t, w, "-a\nb\nc")
+
+	lua(`local app = edit.app() local win = app:window()
+win:set_selection(2, 1, 3, 1)
+app:run_macro_on_region(win, "dash")`)
+	check(t, w, "-a\n-b\n-c")
+	lua(`assert(not pcall(edit.app().stop_macro, edit.app()))`)
+}
+
+func TestMacroRecordsPrompts(t *testing.T) {
+	a, w := newTestApp("")
+	if err := a.BindCommand(globalKeymap, "Ctrl-T", "toggle-minor-mode"); err != nil {
+		t.Fatal(err)
+	}
+	key(a, tcell.KeyCtrlX, Control)
+	typeRunes(a, "(")
+	key(a, tcell.KeyCtrlT, Control)
+	typeRunes(a, autoPairMode)
+	key(a, tcell.KeyEnter, 0)
+	typeRunes(a, "(")
+	key(a, tcell.KeyCtrlX, Control)
+	typeRunes(a, ")")
+	check(t, w, "()")
+	if n := len(a.lastMacro.Events); n != 3+len(autoPairMode) {
+		t.Fatalf("recorded %d events", n)
+	}
+
+	// The macro enables the mode again before typing.
+	a.ToggleMinorMode(autoPairMode)
+	w.SetCursorPos(0, 2)
+	if err := a.RunMacro("", 1); err != nil {
+		t.Fatal(err)
+	}
+	check(t, w, "()()")
+	if !a.MinorModeEnabled(autoPairMode) || a.stringReader != nil {
+		t.Error("the prompt was not answered")
+	}
+}
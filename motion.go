@@ -0,0 +1,180 @@
+package edit
+
+import "unicode"
+
+// runeAt returns the rune at position (l, c) in the buffer.  The end of a line
+// is represented by '\n'.
+func (w *Window) runeAt(l, c int) rune {
+	line, err := w.buffer.GetLine(l, c)
+	if err != nil || c >= line.Len() {
+		return '\n'
+	}
+	return line.Runes[c]
+}
+
+// lineLen returns the length of line l, or 0 if it does not exist.
+func (w *Window) lineLen(l int) int {
+	line, _ := w.buffer.GetLine(l, 0)
+	return line.Len()
+}
+
+// nextPos returns the position following (l, c), and false if (l, c) is the end
+// of the buffer.
+func (w *Window) nextPos(l, c int) (int, int, bool) {
+	l1, c1 := w.buffer.AdvancePos(l, c, 0, 1)
+	return l1, c1, l1 != l || c1 != c
+}
+
+// prevPos returns the position preceding (l, c), and false if (l, c) is the
+// start of the buffer.
+func (w *Window) prevPos(l, c int) (int, int, bool) {
+	if l <= 0 && c <= 0 {
+		return 0, 0, false
+	}
+	l, c = w.buffer.AdvancePos(l, c, 0, -1)
+	return l, c, true
+}
+
+// runeClass classifies runes for the purpose of word motions: 0 for spaces, 1
+// for word characters and 2 for punctuation.  If big is true, all non space
+// characters are in the same class.
+func runeClass(r rune, big bool) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case big || isWordRune(r):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// emptyLine returns true if (l, c) is on an empty line.  Empty lines count as
+// words for word motions.
+func (w *Window) emptyLine(l, c int) bool {
+	return c == 0 && w.lineLen(l) == 0
+}
+
+// nextWordStart returns the position of the start of the word following (l,
+// c).
+func (w *Window) nextWordStart(l, c int, big bool) (int, int) {
+	l0 := l
+	ok := true
+	if cls := runeClass(w.runeAt(l, c), big); cls != 0 {
+		for ok && runeClass(w.runeAt(l, c), big) == cls {
+			l, c, ok = w.nextPos(l, c)
+		}
+	}
+	for ok && runeClass(w.runeAt(l, c), big) == 0 && !(l != l0 && w.emptyLine(l, c)) {
+		l, c, ok = w.nextPos(l, c)
+	}
+	return l, c
+}
+
+// prevWordStart returns the position of the start of the word preceding (l,
+// c).
+func (w *Window) prevWordStart(l, c int, big bool) (int, int) {
+	l, c, ok := w.prevPos(l, c)
+	for ok && runeClass(w.runeAt(l, c), big) == 0 && !w.emptyLine(l, c) {
+		l, c, ok = w.prevPos(l, c)
+	}
+	cls := runeClass(w.runeAt(l, c), big)
+	if cls == 0 {
+		return l, c
+	}
+	for {
+		l1, c1, ok := w.prevPos(l, c)
+		if !ok || runeClass(w.runeAt(l1, c1), big) != cls {
+			return l, c
+		}
+		l, c = l1, c1
+	}
+}
+
+// wordEnd returns the position of the last character of the word ending after
+// (l, c).
+func (w *Window) wordEnd(l, c int, big bool) (int, int) {
+	l, c, ok := w.nextPos(l, c)
+	for ok && runeClass(w.runeAt(l, c), big) == 0 {
+		l, c, ok = w.nextPos(l, c)
+	}
+	cls := runeClass(w.runeAt(l, c), big)
+	for {
+		l1, c1, ok := w.nextPos(l, c)
+		if !ok || runeClass(w.runeAt(l1, c1), big) != cls {
+			return l, c
+		}
+		l, c = l1, c1
+	}
+}
+
+// firstNonBlank returns the column of the first non blank character of line
+// l.
+func (w *Window) firstNonBlank(l int) int {
+	line, _ := w.buffer.GetLine(l, 0)
+	for i, r := range line.Runes {
+		if !unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return line.Len()
+}
+
+// wordBounds returns the bounds of the run of characters of the same class
+// containing (l, c), the end being exclusive.
+func (w *Window) wordBounds(l, c int, big bool) (int, int) {
+	line, _ := w.buffer.GetLine(l, 0)
+	runes := line.Runes
+	if c >= len(runes) {
+		return c, c
+	}
+	cls := runeClass(runes[c], big)
+	c0, c1 := c, c+1
+	for c0 > 0 && runeClass(runes[c0-1], big) == cls {
+		c0--
+	}
+	for c1 < len(runes) && runeClass(runes[c1], big) == cls {
+		c1++
+	}
+	return c0, c1
+}
+
+// findOpenBracket returns the position of the unmatched open bracket before (l,
+// c), which is included in the search.
+func (w *Window) findOpenBracket(l, c int, open, close rune) (int, int, bool) {
+	depth := 0
+	for ok := true; ok; l, c, ok = w.prevPos(l, c) {
+		switch w.runeAt(l, c) {
+		case close:
+			depth++
+		case open:
+			if depth == 0 {
+				return l, c, true
+			}
+			depth--
+		}
+	}
+	return 0, 0, false
+}
+
+// findCloseBracket returns the position of the unmatched close bracket after (l,
+// c), which is included in the search.
+func (w *Window) findCloseBracket(l, c int, open, close rune) (int, int, bool) {
+	depth := 0
+	for ok := true; ok; l, c, ok = w.nextPos(l, c) {
+		switch w.runeAt(l, c) {
+		case open:
+			depth++
+		case close:
+			if depth == 0 {
+				return l, c, true
+			}
+			depth--
+		}
+	}
+	return 0, 0, false
+}
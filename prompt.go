@@ -0,0 +1,51 @@
+package edit
+
+import "github.com/gdamore/tcell/v2"
+
+// A stringReader reads a line of text in the status line instead of letting
+// the keymaps run the bound actions.
+type stringReader struct {
+	prompt string
+	input  []rune
+	done   func(string)
+}
+
+func (r *stringReader) handle(a *App, evt Event) {
+	switch evt.EventType {
+	case Rune:
+		r.input = append(r.input, evt.Rune)
+	case Paste:
+		r.input = append(r.input, []rune(evt.PasteString)...)
+	case Key:
+		switch tcell.Key(evt.KeyData) {
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if len(r.input) > 0 {
+				r.input = r.input[:len(r.input)-1]
+			}
+		case tcell.KeyCtrlU:
+			r.input = nil
+		case tcell.KeyEnter:
+			a.stringReader = nil
+			r.done(string(r.input))
+		case tcell.KeyEsc, tcell.KeyCtrlG:
+			a.stringReader = nil
+			a.ShowMessage("Cancelled")
+		}
+	}
+}
+
+// ReadString reads a line of text in the status line, showing prompt before
+// it, then calls done with the text.  Enter accepts the text, Esc or Ctrl-G
+// cancels.
+func (a *App) ReadString(prompt, initial string, done func(string)) {
+	for _, h := range a.eventHandlerStack() {
+		h.Reset()
+	}
+	a.stringReader = &stringReader{prompt: prompt, input: []rune(initial), done: done}
+}
+
+func (a *App) drawStringReader(screen ScreenWriter, p Position) {
+	r := a.stringReader
+	p = WriteString(screen, p, r.prompt+string(r.input), DefaultStyle)
+	screen.Reverse(p)
+}
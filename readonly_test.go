@@ -0,0 +1,106 @@
+package edit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// tryEditing tries to change the buffer shown in w with the keyboard.
+func tryEditing(a *App, w *Window) {
+	w.SetCursorPos(0, 1)
+	typeRunes(a, "xyz")
+	key(a, tcell.KeyBackspace2, 0)
+	key(a, tcell.KeyDelete, 0)
+	a.HandleEvent(Event{EventType: Paste, PasteString: "pasted"})
+}
+
+func TestReadOnlyDirectoryBuffer(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, w := newTestApp("")
+	w.SetBuffer(NewDirectoryBuffer(dir))
+	want := bufText(w)
+	tryEditing(a, w)
+	check(t, w, want)
+
+	// The buffer can still be refreshed.
+	if err := ioutil.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.RefreshDirectory()
+	if got := w.buffer.LineCount(); got != 4 {
+		t.Errorf("%d lines after refresh:\n%s", got, bufText(w))
+	}
+}
+
+// shownBuffer returns the buffer of the given kind shown in a window of a.
+func shownBuffer(t *testing.T, a *App, kind string) Buffer {
+	t.Helper()
+	for _, w := range a.Windows() {
+		if w.buffer.Kind() == kind {
+			return w.buffer
+		}
+	}
+	t.Fatalf("no %s buffer", kind)
+	return nil
+}
+
+func TestReadOnlyGrepBuffer(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("needle\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, w := newTestApp("")
+	if err := a.Grep("needle", dir); err != nil {
+		t.Fatal(err)
+	}
+	pump(t, a, func() bool { return len(a.Jobs()) == 0 })
+	w.SetBuffer(a.grep.buf)
+	want := bufText(w)
+	tryEditing(a, w)
+	check(t, w, want)
+}
+
+func TestReadOnlyCompilationBuffer(t *testing.T) {
+	a, w := newTestApp("")
+	if err := a.Compile("echo a.go:1:1: bad"); err != nil {
+		t.Fatal(err)
+	}
+	pump(t, a, func() bool { return len(a.Jobs()) == 0 })
+	w.SetBuffer(a.compilation.buf)
+	want := bufText(w)
+	tryEditing(a, w)
+	check(t, w, want)
+}
+
+func TestReadOnlyShellOutputBuffer(t *testing.T) {
+	a, w := newTestApp("")
+	if err := a.ShellCommandToBuffer("echo one; echo two"); err != nil {
+		t.Fatal(err)
+	}
+	pump(t, a, func() bool { return len(a.Jobs()) == 0 })
+	w.SetBuffer(shownBuffer(t, a, shellOutputKind))
+	check(t, w, "one\ntwo")
+	tryEditing(a, w)
+	check(t, w, "one\ntwo")
+}
+
+func TestReadOnlyBufferUndo(t *testing.T) {
+	a, w := newTestApp("")
+	a.ShowLocations("Locations", []string{"a.go:1:1: bad", "b.go:2:1: worse"})
+	buf := a.compilation.buf
+	w.SetBuffer(buf)
+	w.SetCursorPos(2, 3)
+	CmdUndo(w)
+	if l, c := w.CursorPos(); l != 2 || c != 3 || a.message != "Buffer is read only" {
+		t.Errorf("cursor at (%d, %d), message %q", l, c, a.message)
+	}
+	if len(buf.history.done) != 0 {
+		t.Errorf("%d steps recorded", len(buf.history.done))
+	}
+}
@@ -0,0 +1,420 @@
+package edit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arnodel/golua/lib/debuglib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Lua code run by the editor (bindings, commands, hooks, the console, init.lua
+// and plugins) runs under CPU and memory limits, so that e.g. an infinite loop
+// in a binding is stopped with an error in the log instead of freezing the
+// editor.  Each call from the editor is accounted for separately.
+//
+// With limits set, Go functions called from Lua must declare that they comply
+// with them (see runtime.SolemnlyDeclareCompliance).  The functions of the
+// "edit" module do, as do most functions of the standard library.
+
+// LuaLimits are the resources a call to Lua may use.  Zero means no limit.
+type LuaLimits struct {
+	CPU    uint64 // roughly the number of VM instructions
+	Memory uint64 // bytes allocated
+}
+
+// DefaultLuaLimits are the limits of a new App.
+var DefaultLuaLimits = LuaLimits{
+	CPU:    50000000,
+	Memory: 500 << 20,
+}
+
+// LuaLimits returns the limits of calls to Lua.
+func (a *App) LuaLimits() LuaLimits {
+	return a.luaLimits
+}
+
+// SetLuaLimits sets the limits of calls to Lua.
+func (a *App) SetLuaLimits(l LuaLimits) {
+	a.luaLimits = l
+}
+
+// callLuaContext runs f in a new runtime context with the App's limits.
+func (a *App) callLuaContext(f func(t *rt.Thread) *rt.Error) error {
+	t := a.lua.MainThread()
+	def := rt.RuntimeContextDef{
+		HardLimits: rt.RuntimeResources{
+			Cpu:    a.luaLimits.CPU,
+			Memory: a.luaLimits.Memory,
+		},
+		MessageHandler: debuglib.Traceback,
+	}
+	ctx, err := t.CallContext(def, func() *rt.Error { return f(t) })
+	if ctx != nil && ctx.Status() == rt.StatusKilled {
+		return limitError(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// callLua calls f with args under the App's limits and returns its first
+// result.  The call stack of f starts with the call, it does not include the
+// continuation the thread was left in by a previous call which failed.
+func (a *App) callLua(f rt.Value, args ...rt.Value) (rt.Value, error) {
+	var res rt.Value
+	err := a.callLuaContext(func(t *rt.Thread) *rt.Error {
+		term := rt.NewTerminationWith(nil, 1, false)
+		if err := rt.Call(t, f, args, term); err != nil {
+			return err
+		}
+		res = term.Get(0)
+		return nil
+	})
+	return res, err
+}
+
+// limitError describes why a context was killed.  The resource that was
+// exhausted is the one closest to its limit, as the context is killed before
+// using more than the limit.
+func limitError(ctx rt.RuntimeContext) error {
+	used, limits := ctx.UsedResources(), ctx.HardLimits()
+	cpu, mem := usedRatio(used.Cpu, limits.Cpu), usedRatio(used.Memory, limits.Memory)
+	switch {
+	case cpu == 0 && mem == 0:
+		return fmt.Errorf("Lua code stopped")
+	case cpu >= mem:
+		return fmt.Errorf("Lua code stopped: CPU limit of %d exceeded", limits.Cpu)
+	default:
+		return fmt.Errorf("Lua code stopped: memory limit of %d bytes exceeded", limits.Memory)
+	}
+}
+
+func usedRatio(used, limit uint64) float64 {
+	if limit == 0 {
+		return 0
+	}
+	return float64(used) / float64(limit)
+}
+
+// declareLibCompliance lets require and the message handler be called under
+// limits.  The module loaded by require runs under the same limits.  The Lua
+// module searcher is replaced because its loader is not declared compliant and
+// is not accessible.
+func (a *App) declareLibCompliance() {
+	pkg := a.packageTable()
+	searchers := pkg.Get(rt.StringValue("searchers")).AsTable()
+	searchers.Set(rt.IntValue(2), rt.FunctionValue(luaSearcher))
+	declareCompliance(a.lua.GlobalEnv(), pkg, searchers)
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe,
+
+		debuglib.Traceback,
+		luaLoader,
+	)
+}
+
+var (
+	luaSearcher = rt.NewGoFunction(searchLua, "searchlua", 1, false)
+	luaLoader   = rt.NewGoFunction(loadLua, "loadlua", 2, false)
+)
+
+// searchLua looks for a Lua module in package.path.
+func searchLua(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	name, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+	pkg := t.Registry(rt.StringValue("package")).AsTable()
+	path, ok := pkg.Get(rt.StringValue("path")).TryString()
+	if !ok {
+		return nil, rt.NewErrorS("package.path must be a string")
+	}
+	namePath := strings.Replace(name, ".", string(filepath.Separator), -1)
+	var tried []string
+	for _, template := range strings.Split(path, ";") {
+		file := strings.Replace(template, "?", namePath, -1)
+		if _, err := os.Stat(file); err == nil {
+			return c.PushingNext(t.Runtime, rt.FunctionValue(luaLoader), rt.StringValue(file)), nil
+		}
+		tried = append(tried, "no file '"+file+"'")
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(strings.Join(tried, "\n"))), nil
+}
+
+// loadLua runs the Lua module in the file given as second argument.
+func loadLua(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+	file, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	src, readErr := ioutil.ReadFile(file)
+	if readErr != nil {
+		return nil, rt.NewErrorF("error reading file: %s", readErr)
+	}
+	clos, compErr := t.LoadFromSourceOrCode(file, src, "bt", rt.TableValue(t.GlobalEnv()), true)
+	if compErr != nil {
+		return nil, rt.NewErrorF("error compiling file: %s", compErr)
+	}
+	return rt.Continue(t, rt.FunctionValue(clos), c.Next())
+}
+
+// declareCompliance declares the Go functions in the tables compliant with the
+// CPU and memory limits.  They do a bounded amount of work, and any Lua code
+// they call runs under its own limits.
+func declareCompliance(tables ...*rt.Table) {
+	for _, t := range tables {
+		for k, v, ok := t.Next(rt.NilValue); ok && !k.IsNil(); k, v, ok = t.Next(k) {
+			if f, ok := v.TryCallable(); ok {
+				if gof, ok := f.(*rt.GoFunction); ok {
+					gof.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe)
+				}
+			}
+		}
+	}
+}
+
+// In safe mode, plugins which are not trusted run in a sandbox.  Their global
+// environment is their own, and falls back to the shared one except for io,
+// debug, package, golib, dofile, loadfile and collectgarbage, which are false,
+// and os, which only has clock, date, difftime and time.  The sandbox has its
+// own copies of the string, table, math, utf8, coroutine and edit modules, so
+// that it cannot replace functions used by other code, and getmetatable only
+// returns the metatables of tables.  require only gives access to modules
+// already loaded, and load compiles chunks in the sandbox.  A sandboxed
+// plugin must be a single file.
+//
+// The methods of the "edit" module which run processes, read or write files or
+// lift the restrictions fail when called from sandboxed code.  Code is
+// sandboxed while the plugin is loaded, while functions it registered (e.g.
+// bindings, hooks or timers) are called, and while one of its functions is
+// on the Lua call stack.
+
+// sandboxHidden are the globals not available in the sandbox.
+var sandboxHidden = []string{
+	"io", "debug", "package", "golib", "dofile", "loadfile", "collectgarbage",
+}
+
+// sandboxOSFuncs are the functions of os available in the sandbox.
+var sandboxOSFuncs = []string{"clock", "date", "difftime", "time"}
+
+// sandboxCopied are the modules copied in the sandbox.
+var sandboxCopied = []string{"string", "table", "math", "utf8", "coroutine", "edit"}
+
+// SetSafeMode turns safe mode on or off.
+func (a *App) SetSafeMode(on bool) {
+	a.safeMode = on
+}
+
+// SafeMode returns true if safe mode is on.
+func (a *App) SafeMode() bool {
+	return a.safeMode
+}
+
+// TrustPlugins lets the named plugins run outside the sandbox in safe mode.
+func (a *App) TrustPlugins(names []string) {
+	for _, name := range names {
+		a.trustedPlugins[name] = true
+	}
+}
+
+func (a *App) pluginSandboxed(name string) bool {
+	return a.safeMode && !a.trustedPlugins[name]
+}
+
+// notSandboxed returns a Go function which fails when called from sandboxed
+// code, and calls f otherwise.
+func (a *App) notSandboxed(name string, f func(*rt.Thread, *rt.GoCont) (rt.Cont, *rt.Error)) func(*rt.Thread, *rt.GoCont) (rt.Cont, *rt.Error) {
+	return func(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+		if a.callerSandboxed(c) {
+			return nil, rt.NewErrorF("%s is not available in safe mode", name)
+		}
+		return f(t, c)
+	}
+}
+
+// callerSandboxed returns true if sandboxed code is running, or if a function
+// compiled in a sandbox is on the call stack of c.
+func (a *App) callerSandboxed(c rt.Cont) bool {
+	if a.luaSandboxed {
+		return true
+	}
+	for ; c != nil; c = c.Parent() {
+		if info := c.DebugInfo(); info != nil && a.sandboxSources[info.Source] {
+			return true
+		}
+	}
+	return false
+}
+
+// luaCaller returns a function calling f like callLua.  If f is registered by
+// sandboxed code, it runs sandboxed.
+func (a *App) luaCaller(f rt.Value) func(args ...rt.Value) (rt.Value, error) {
+	sandboxed := a.luaSandboxed
+	return func(args ...rt.Value) (rt.Value, error) {
+		return a.callLuaSandboxed(sandboxed, f, args...)
+	}
+}
+
+func (a *App) callLuaSandboxed(sandboxed bool, f rt.Value, args ...rt.Value) (rt.Value, error) {
+	defer func(old bool) { a.luaSandboxed = old }(a.luaSandboxed)
+	a.luaSandboxed = sandboxed
+	return a.callLua(f, args...)
+}
+
+// newSandbox returns a new sandbox environment for the chunk named source.
+// Chunks compiled with load in the sandbox are named after source.
+func (a *App) newSandbox(source string) *rt.Table {
+	a.sandboxSources[source] = true
+	r := a.lua
+	global := r.GlobalEnv()
+	env := rt.NewTable()
+	meta := rt.NewTable()
+	r.SetEnv(meta, "__index", rt.TableValue(global))
+	r.SetEnv(meta, "__metatable", rt.BoolValue(false))
+	env.SetMetatable(meta)
+	for _, name := range sandboxHidden {
+		r.SetEnv(env, name, rt.BoolValue(false))
+	}
+	r.SetEnv(env, "_G", rt.TableValue(env))
+
+	safeOS := rt.NewTable()
+	if osLib, ok := global.Get(rt.StringValue("os")).TryTable(); ok {
+		for _, name := range sandboxOSFuncs {
+			r.SetEnv(safeOS, name, osLib.Get(rt.StringValue(name)))
+		}
+	}
+	r.SetEnv(env, "os", rt.TableValue(safeOS))
+	own := map[string]*rt.Table{"os": safeOS}
+	for _, name := range sandboxCopied {
+		lib, ok := global.Get(rt.StringValue(name)).TryTable()
+		if !ok {
+			continue
+		}
+		libCopy := rt.NewTable()
+		for k, v, ok := lib.Next(rt.NilValue); ok && !k.IsNil(); k, v, ok = lib.Next(k) {
+			libCopy.Set(k, v)
+		}
+		r.SetEnv(env, name, rt.TableValue(libCopy))
+		own[name] = libCopy
+	}
+
+	loaded := a.packageTable().Get(rt.StringValue("loaded")).AsTable()
+	require := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+		name, err := c.StringArg(0)
+		if err != nil {
+			return nil, err
+		}
+		if lib, ok := own[name]; ok {
+			return c.PushingNext1(t.Runtime, rt.TableValue(lib)), nil
+		}
+		mod := loaded.Get(rt.StringValue(name))
+		if mod.IsNil() || sandboxHides(name) {
+			return nil, rt.NewErrorF("module %q is not available in safe mode", name)
+		}
+		return c.PushingNext1(t.Runtime, mod), nil
+	}
+	load := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+		src, err := c.StringArg(0)
+		if err != nil {
+			return nil, err
+		}
+		chunkName := "(load)"
+		if c.NArgs() >= 2 && !c.Arg(1).IsNil() {
+			if chunkName, err = c.StringArg(1); err != nil {
+				return nil, err
+			}
+		}
+		chunkName = source + ":" + chunkName
+		a.sandboxSources[chunkName] = true
+		chunkEnv := rt.TableValue(env)
+		if c.NArgs() >= 4 {
+			chunkEnv = c.Arg(3)
+		}
+		clos, cerr := t.Runtime.CompileAndLoadLuaChunk(chunkName, []byte(src), chunkEnv)
+		if cerr != nil {
+			return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(cerr.Error())), nil
+		}
+		return c.PushingNext1(t.Runtime, rt.FunctionValue(clos)), nil
+	}
+	getmetatable := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, *rt.Error) {
+		if err := c.Check1Arg(); err != nil {
+			return nil, err
+		}
+		if _, ok := c.Arg(0).TryTable(); !ok {
+			return c.PushingNext1(t.Runtime, rt.BoolValue(false)), nil
+		}
+		return c.PushingNext1(t.Runtime, t.Metatable(c.Arg(0))), nil
+	}
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe,
+
+		r.SetEnvGoFunc(env, "require", require, 1, false),
+		r.SetEnvGoFunc(env, "load", load, 4, false),
+		r.SetEnvGoFunc(env, "getmetatable", getmetatable, 1, false),
+	)
+	return env
+}
+
+func sandboxHides(name string) bool {
+	for _, hidden := range sandboxHidden {
+		if name == hidden {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginFile returns the file of the named plugin.
+func (a *App) pluginFile(name string) (string, error) {
+	dir := filepath.Join(a.configDir, "plugins")
+	for _, path := range []string{
+		filepath.Join(dir, name+".lua"),
+		filepath.Join(dir, name, "init.lua"),
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("plugin %s not found", name)
+}
+
+// loadSandboxedPlugin runs the plugin in a new sandbox and, if it returns a
+// function, calls it with the App.  The result is recorded in package.loaded
+// like with require.
+func (a *App) loadSandboxedPlugin(name string) error {
+	path, err := a.pluginFile(name)
+	if err != nil {
+		return err
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	clos, err := a.lua.CompileAndLoadLuaChunk(path, src, rt.TableValue(a.newSandbox(path)))
+	if err != nil {
+		return err
+	}
+	mod, err := a.callLuaSandboxed(true, rt.FunctionValue(clos), rt.StringValue(name))
+	if err != nil {
+		return err
+	}
+	if _, ok := mod.TryCallable(); ok {
+		if _, err := a.callLuaSandboxed(true, mod, a.LuaApp()); err != nil {
+			return err
+		}
+	}
+	if mod.IsNil() {
+		mod = rt.BoolValue(true)
+	}
+	loaded := a.packageTable().Get(rt.StringValue("loaded")).AsTable()
+	loaded.Set(rt.StringValue(name), mod)
+	return nil
+}
@@ -0,0 +1,108 @@
+package edit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The plugin tries each way out of the sandbox, asserting that it fails.
+const escapePlugin = `
+local out, saved, secret, macros = %q, %q, %q, %q
+local app = edit.app()
+local win = app:window()
+local buf = win:buffer()
+
+local function refused(what, f, ...)
+  local ok, err = pcall(f, ...)
+  assert(not ok, what .. " allowed")
+  assert(tostring(err):find("not available in safe mode"), what .. ": " .. tostring(err))
+end
+
+refused("start_job", app.start_job, app, {"touch", out})
+refused("open", app.open, app, secret)
+buf:set_text(1, 1, 1, 1, "pwned")
+refused("save", buf.save, buf)
+refused("run_command", app.run_command, app, "save-buffer")
+refused("win:run_command", win.run_command, win, "save-buffer")
+refused("save_macros", app.save_macros, app, macros)
+refused("load_macros", app.load_macros, app, secret)
+refused("set_language_server", app.set_language_server, app, "text", {"touch", out})
+refused("set_safe_mode", app.set_safe_mode, app, false)
+refused("trust_plugins", app.trust_plugins, app, {"escape"})
+refused("coroutine", coroutine.wrap(app.start_job), app, {"touch", out})
+refused("load", load("local app, out = ... return app:start_job{'touch', out}"), app, out)
+
+-- The shared modules and metatables cannot be changed, and callbacks run
+-- sandboxed.
+assert(getmetatable(app) == false and getmetatable("") == false)
+string.escape = function() return app:start_job{"touch", out} end
+edit.app = string.escape
+app:define_command("escape", "", function(w) app:start_job{"touch", out} end)
+app:add_hook("buffer-changed", function() app:start_job{"touch", out} end)
+`
+
+func TestSandboxEscapes(t *testing.T) {
+	dir, tmp := t.TempDir(), t.TempDir()
+	out := filepath.Join(tmp, "out")
+	saved := filepath.Join(tmp, "saved")
+	secret := filepath.Join(tmp, "secret")
+	macros := filepath.Join(tmp, "macros.json")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "plugins"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "init.lua"), []byte(`edit.app():set_safe_mode(true)`), 0644)
+	plugin := fmt.Sprintf(escapePlugin, out, saved, secret, macros)
+	ioutil.WriteFile(filepath.Join(dir, "plugins", "escape.lua"), []byte(plugin), 0644)
+
+	a, w := newTestApp("x")
+	w.buffer.(*FileBuffer).filename = saved
+	if err := a.LoadConfig(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !a.SafeMode() || !a.pluginSandboxed("escape") {
+		t.Fatal("the plugin lifted the sandbox")
+	}
+	a.RunCommand(w, "escape", 1)
+	if err := a.InitLuaCode("trusted", []byte(`assert(string.escape == nil) local w = edit.app():window() pcall(w.insert, w, "y")`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Jobs()) != 0 {
+		t.Fatalf("jobs started: %d", len(a.Jobs()))
+	}
+	for _, f := range []string{out, saved, macros} {
+		if _, err := os.Stat(f); err == nil {
+			t.Fatalf("%s was written", f)
+		}
+	}
+
+	// Trusted code can still use the methods.
+	code := fmt.Sprintf(`edit.app():save_macros(%q)`, macros)
+	if err := a.InitLuaCode("trusted", []byte(code)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(macros); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileKindsPerApp(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.go")
+	if err := ioutil.WriteFile(filename, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a1, _ := newTestApp("")
+	a2, _ := newTestApp("")
+	if err := a1.InitLuaCode("test", []byte(`edit.app():set_file_kind(".go", "text")`)); err != nil {
+		t.Fatal(err)
+	}
+	if kind := a1.readFile(filename).Kind(); kind != "text" {
+		t.Errorf("kind in the first app: %s", kind)
+	}
+	if kind := a2.readFile(filename).Kind(); kind != "go" {
+		t.Errorf("kind in the second app: %s", kind)
+	}
+}
@@ -1,6 +1,10 @@
 package edit
 
-import "github.com/gdamore/tcell/v2"
+import (
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
 
 type ScreenWriter interface {
 	Size() Size
@@ -36,6 +40,25 @@ func (s *Screen) PollEvent() Event {
 	return s.eventConverter.EventFromTcell(s.tcellScreen.PollEvent())
 }
 
+// PollEventTimeout waits for the next event for at most d, returning an event
+// of type NoEvent if there is none in that time.  If d is 0, it waits
+// indefinitely.
+func (s *Screen) PollEventTimeout(d time.Duration) Event {
+	if d > 0 {
+		timer := time.AfterFunc(d, func() {
+			s.tcellScreen.PostEvent(tcell.NewEventInterrupt(nil))
+		})
+		defer timer.Stop()
+	}
+	return s.PollEvent()
+}
+
+// Wake makes PollEvent return an event of type NoEvent.  It is safe to call
+// from any goroutine.
+func (s *Screen) Wake() {
+	s.tcellScreen.PostEvent(tcell.NewEventInterrupt(nil))
+}
+
 func (s *Screen) Fill(c rune) {
 	s.tcellScreen.Fill(' ', tcell.StyleDefault)
 }
@@ -88,12 +111,23 @@ func (s SubScreen) Reverse(p Position) {
 }
 
 func (s SubScreen) SubScreen(rect Rectangle) ScreenWriter {
+	rect.Position = rect.Position.MoveBy(s.rect.Position)
 	return &SubScreen{
 		rect:   rect.Intersect(s.rect),
 		screen: s.screen,
 	}
 }
 
+// WriteString writes s on a single line of the screen starting at p and returns
+// the position following the last rune written.
+func WriteString(s ScreenWriter, p Position, str string, style Style) Position {
+	for _, r := range str {
+		s.SetRune(p, r, style)
+		p = p.MoveByX(1)
+	}
+	return p
+}
+
 // A Printer knows how to print lines on a screen
 type Printer struct {
 	Offset   int
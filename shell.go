@@ -0,0 +1,199 @@
+package edit
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Shell commands run with sh -c as jobs, so the editor keeps handling events
+// while they run.  A command running for longer than the shell timeout is
+// killed.  A command which fails or times out does not change the buffer it
+// was run on, and is reported in the status line.
+
+// shellOutputKind is the buffer kind of the output of shell commands.
+const shellOutputKind = "shell-output"
+
+// DefaultShellTimeout is the shell timeout of a new App.
+const DefaultShellTimeout = 10 * time.Second
+
+// SetShellTimeout sets how long shell commands may run.
+func (a *App) SetShellTimeout(d time.Duration) {
+	a.shellTimeout = d
+}
+
+// runShellCommand runs cmdLine with stdin as its standard input.  onLine, if
+// not nil, is called with each line of output, standard error included.  done
+// is called with the standard output if the command succeeds.
+func (a *App) runShellCommand(cmdLine, stdin string, onLine func(string), done func(out []string)) error {
+	cmd := exec.Command("sh", "-c", cmdLine)
+	cmd.Stdin = strings.NewReader(stdin)
+	var (
+		out, errOut []string
+		timedOut    bool
+		timer       *Timer
+	)
+	job, err := a.StartProcess(cmdLine, cmd, JobHandlers{
+		OnOutput: func(j *Job, line string) {
+			out = append(out, line)
+			if onLine != nil {
+				onLine(line)
+			}
+		},
+		OnError: func(j *Job, line string) {
+			errOut = append(errOut, line)
+			if onLine != nil {
+				onLine(line)
+			}
+		},
+		OnExit: func(j *Job, err error) {
+			timer.Stop()
+			for _, line := range errOut {
+				a.Logf("%s: %s", cmdLine, line)
+			}
+			switch {
+			case timedOut:
+				a.ShowMessage("Shell command timed out after %s: %s", a.shellTimeout, cmdLine)
+			case err != nil:
+				msg := ""
+				if len(errOut) > 0 {
+					msg = ": " + errOut[len(errOut)-1]
+				}
+				a.ShowMessage("Shell command exited with status %d%s", exitCode(err), msg)
+			default:
+				done(out)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	timer = a.AfterFunc(a.shellTimeout, func() {
+		timedOut = true
+		job.Cancel()
+	})
+	return nil
+}
+
+// ShellCommandToBuffer runs cmdLine and shows its output in a new buffer as it
+// comes.
+func (a *App) ShellCommandToBuffer(cmdLine string) error {
+	buf := &FileBuffer{
+		kind:     shellOutputKind,
+		readOnly: true,
+		lines:    []Line{NewLineFromString("", nil)},
+	}
+	empty := true
+	onLine := func(line string) {
+		if empty {
+			buf.Truncate(0)
+			empty = false
+		}
+		buf.AppendLine(NewLineFromString(line, nil))
+	}
+	done := func(out []string) {
+		if empty {
+			a.ShowMessage("Shell command succeeded with no output")
+		}
+	}
+	if err := a.runShellCommand(cmdLine, "", onLine, done); err != nil {
+		return err
+	}
+	a.ShowBuffer(buf)
+	return nil
+}
+
+// ShellCommandInsert runs cmdLine and inserts its output at the cursor.
+func (w *Window) ShellCommandInsert(cmdLine string) error {
+	buf := w.buffer
+	l, c := w.CursorPos()
+	return w.app.runShellCommand(cmdLine, "", nil, func(out []string) {
+		if len(out) == 0 {
+			return
+		}
+		l1, c1, err := buf.InsertString(strings.Join(out, "\n"), l, c)
+		if err != nil {
+			w.app.ShowMessage("Cannot insert shell command output: %s", err)
+			return
+		}
+		if w.buffer == buf {
+			w.SetCursorPos(l1, c1)
+		}
+	})
+}
+
+// ShellCommandOnRegion runs cmdLine with the text from (l0, c0) up to but not
+// including (l1, c1) as its standard input, and replaces that text with its
+// output.  The replacement is undone in one step.
+func (w *Window) ShellCommandOnRegion(cmdLine string, l0, c0, l1, c1 int) error {
+	buf := w.buffer
+	text, err := RegionString(buf, l0, c0, l1, c1)
+	if err != nil {
+		return err
+	}
+	return w.app.runShellCommand(cmdLine, text, nil, func(out []string) {
+		if current, err := RegionString(buf, l0, c0, l1, c1); err != nil || current != text {
+			w.app.ShowMessage("Region changed while the shell command was running")
+			return
+		}
+		result := strings.Join(out, "\n")
+		if len(out) > 0 && strings.HasSuffix(text, "\n") {
+			result += "\n"
+		}
+		WithUndoGroup(buf, func() {
+			_, _, err = ReplaceRegion(buf, l0, c0, l1, c1, result)
+		})
+		if err != nil {
+			w.app.ShowMessage("Cannot replace region: %s", err)
+			return
+		}
+		if w.buffer == buf {
+			w.ResetHighlightRegion()
+			w.SetCursorPos(l0, c0)
+		}
+	})
+}
+
+// highlightedRange returns the highlighted region of the window with an
+// exclusive end.
+func (w *Window) highlightedRange() (l0, c0, l1, c1 int, err error) {
+	l0, c0, l1, c1, ok := w.HighlightedRegion()
+	if !ok {
+		return 0, 0, 0, 0, errors.New("no region")
+	}
+	l1, c1 = w.buffer.AdvancePos(l1, c1, 0, 1)
+	return l0, c0, l1, c1, nil
+}
+
+func CmdShellCommand(w *Window) {
+	a := w.App()
+	a.ReadString("Shell command: ", "", func(cmdLine string) {
+		if err := a.ShellCommandToBuffer(cmdLine); err != nil {
+			a.ShowMessage("Cannot run shell command: %s", err)
+		}
+	})
+}
+
+func CmdShellCommandInsert(w *Window) {
+	a := w.App()
+	a.ReadString("Shell command (insert output): ", "", func(cmdLine string) {
+		if err := w.ShellCommandInsert(cmdLine); err != nil {
+			a.ShowMessage("Cannot run shell command: %s", err)
+		}
+	})
+}
+
+func CmdShellCommandOnRegion(w *Window) {
+	a := w.App()
+	l0, c0, l1, c1, err := w.highlightedRange()
+	if err != nil {
+		a.ShowMessage("No region to run a shell command on")
+		return
+	}
+	a.ReadString("Shell command on region: ", "", func(cmdLine string) {
+		if err := w.ShellCommandOnRegion(cmdLine, l0, c0, l1, c1); err != nil {
+			a.ShowMessage("Cannot run shell command: %s", err)
+		}
+	})
+}
@@ -0,0 +1,649 @@
+package edit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Snippets are templates inserted in place of a trigger word.  Their body uses
+// the syntax of TextMate and language server snippets:
+//
+//	$1, ${1}             a tab stop
+//	${1:text}            a field with a placeholder, which may contain fields
+//	${1|one,two,three|}  a field offering a choice of texts
+//	$0                   where the cursor ends, after the body if omitted
+//	$NAME, ${NAME:text}  a variable, e.g. TM_FILENAME, or text if unset
+//	\$, \}, \\           literal characters
+//
+// A field number used more than once makes mirrors, which follow the text
+// typed in the first one.  While a snippet is live, the "snippet" keymap comes
+// before the buffer keymaps so that keys can move between its fields.
+
+// snippetKeymap is the keymap consulted while a snippet is live.
+const snippetKeymap = "snippet"
+
+// allKinds is the kind of snippets available in buffers of every kind.
+const allKinds = ""
+
+// A Snippet is expanded in place of its trigger word.
+type Snippet struct {
+	Trigger     string
+	Body        string
+	Description string
+}
+
+// AddSnippet adds a snippet for buffers of kind, replacing the snippet with the
+// same trigger.  The empty kind adds it for all kinds.
+func (a *App) AddSnippet(kind string, s Snippet) {
+	if a.snippets[kind] == nil {
+		a.snippets[kind] = map[string]Snippet{}
+	}
+	a.snippets[kind][s.Trigger] = s
+}
+
+// FindSnippet returns the snippet with the trigger for buffers of kind.
+func (a *App) FindSnippet(kind, trigger string) (Snippet, bool) {
+	if s, ok := a.snippets[kind][trigger]; ok {
+		return s, true
+	}
+	s, ok := a.snippets[allKinds][trigger]
+	return s, ok
+}
+
+// LoadSnippets adds the snippets of a JSON file in the format used by VS Code:
+//
+//	{"For loop": {"prefix": "for", "body": ["for $1 {", "\t$0", "}"], "description": "..."}}
+//
+// The body is a string or a list of lines.
+func (a *App) LoadSnippets(kind, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var defs map[string]struct {
+		Prefix      json.RawMessage `json:"prefix"`
+		Body        json.RawMessage `json:"body"`
+		Description string          `json:"description"`
+	}
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	for name, def := range defs {
+		prefixes := jsonStrings(def.Prefix)
+		if len(prefixes) == 0 {
+			return fmt.Errorf("%s: snippet %q has no prefix", filename, name)
+		}
+		desc := def.Description
+		if desc == "" {
+			desc = name
+		}
+		body := strings.Join(jsonStrings(def.Body), "\n")
+		for _, prefix := range prefixes {
+			a.AddSnippet(kind, Snippet{Trigger: prefix, Body: body, Description: desc})
+		}
+	}
+	return nil
+}
+
+// jsonStrings decodes a string or a list of strings.
+func jsonStrings(data json.RawMessage) []string {
+	var list []string
+	if json.Unmarshal(data, &list) == nil {
+		return list
+	}
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		return []string{s}
+	}
+	return nil
+}
+
+// loadSnippetFiles loads the files kind.json in the snippets directory of the
+// configuration, all.json holding the snippets for all kinds.
+func (a *App) loadSnippetFiles(dir string) error {
+	files, _ := filepath.Glob(filepath.Join(dir, "snippets", "*.json"))
+	var lastErr error
+	for _, filename := range files {
+		kind := strings.TrimSuffix(filepath.Base(filename), ".json")
+		if kind == "all" {
+			kind = allKinds
+		}
+		if err := a.LoadSnippets(kind, filename); err != nil {
+			a.Logf("Error loading snippets: %s", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+//
+// Parsing
+//
+
+// A snippetField is the range of a field in the buffer.
+type snippetField struct {
+	num     int
+	l0, c0  int
+	l1, c1  int
+	choices []string
+}
+
+type snippetParser struct {
+	body    []rune
+	i       int
+	indent  string
+	vars    func(name string) (string, bool)
+	text    strings.Builder
+	l, c    int
+	fields  []*snippetField
+	discard int // text is not written while > 0
+}
+
+// parseSnippet returns the text of a snippet body and its fields, with
+// positions relative to the start of the text.  Lines after the first are
+// indented with indent.
+func parseSnippet(body, indent string, vars func(string) (string, bool)) (string, []*snippetField) {
+	p := &snippetParser{body: []rune(body), indent: indent, vars: vars}
+	p.parse(false)
+	hasEnd := false
+	for _, f := range p.fields {
+		if f.num == 0 {
+			hasEnd = true
+		}
+	}
+	if !hasEnd {
+		p.fields = append(p.fields, &snippetField{l0: p.l, c0: p.c, l1: p.l, c1: p.c})
+	}
+	// Mirrors follow the first field with a placeholder.
+	sort.SliceStable(p.fields, func(i, j int) bool {
+		return !p.fields[i].empty() && p.fields[j].empty()
+	})
+	return p.text.String(), p.fields
+}
+
+func (p *snippetParser) write(s string) {
+	if p.discard > 0 {
+		return
+	}
+	for _, r := range s {
+		p.text.WriteRune(r)
+		p.c++
+		if r == '\n' {
+			p.text.WriteString(p.indent)
+			p.l++
+			p.c = len([]rune(p.indent))
+		}
+	}
+}
+
+func (p *snippetParser) peek() rune {
+	if p.i < len(p.body) {
+		return p.body[p.i]
+	}
+	return 0
+}
+
+// parse parses text up to the end of the body, or to the closing brace of a
+// placeholder if inner is true.
+func (p *snippetParser) parse(inner bool) {
+	for p.i < len(p.body) {
+		r := p.body[p.i]
+		switch {
+		case r == '\\' && p.i+1 < len(p.body) && strings.ContainsRune(`\$}`, p.body[p.i+1]):
+			p.write(string(p.body[p.i+1]))
+			p.i += 2
+		case r == '}' && inner:
+			p.i++
+			return
+		case r == '$':
+			p.i++
+			p.dollar()
+		default:
+			p.write(string(r))
+			p.i++
+		}
+	}
+}
+
+func (p *snippetParser) readWhile(pred func(rune) bool) string {
+	start := p.i
+	for p.i < len(p.body) && pred(p.body[p.i]) {
+		p.i++
+	}
+	return string(p.body[start:p.i])
+}
+
+func isVarRune(r rune) bool {
+	return r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// dollar parses what follows a '$'.
+func (p *snippetParser) dollar() {
+	braced := p.peek() == '{'
+	if braced {
+		p.i++
+	}
+	if unicode.IsDigit(p.peek()) {
+		num, _ := strconv.Atoi(p.readWhile(unicode.IsDigit))
+		f := &snippetField{num: num, l0: p.l, c0: p.c}
+		switch {
+		case !braced:
+		case p.peek() == ':':
+			p.i++
+			p.parse(true)
+		case p.peek() == '|':
+			p.i++
+			f.choices = p.choices()
+			if len(f.choices) > 0 {
+				p.write(f.choices[0])
+			}
+		default:
+			p.i++ // the closing brace
+		}
+		f.l1, f.c1 = p.l, p.c
+		if p.discard == 0 {
+			p.fields = append(p.fields, f)
+		}
+		return
+	}
+	name := p.readWhile(isVarRune)
+	if name == "" {
+		p.write("$")
+		if braced {
+			p.write("{")
+		}
+		return
+	}
+	value, ok := p.vars(name)
+	if ok {
+		p.write(value)
+	}
+	switch {
+	case !braced:
+	case p.peek() == ':':
+		p.i++
+		if ok {
+			p.discard++
+		}
+		p.parse(true)
+		if ok {
+			p.discard--
+		}
+	default:
+		p.i++
+	}
+}
+
+// choices parses the choices of a field up to the closing "|}".
+func (p *snippetParser) choices() []string {
+	var choices []string
+	var cur strings.Builder
+	for p.i < len(p.body) {
+		r := p.body[p.i]
+		p.i++
+		switch {
+		case r == '\\' && p.i < len(p.body):
+			cur.WriteRune(p.body[p.i])
+			p.i++
+		case r == ',':
+			choices = append(choices, cur.String())
+			cur.Reset()
+		case r == '|' && p.peek() == '}':
+			p.i++
+			return append(choices, cur.String())
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(choices, cur.String())
+}
+
+// snippetVariables returns the values of the variables for a snippet expanded
+// in buf.
+func snippetVariables(buf Buffer, l int) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		filename := ""
+		if fb, ok := buf.(*FileBuffer); ok && fb.filename != "" {
+			filename, _ = filepath.Abs(fb.filename)
+		}
+		switch name {
+		case "TM_FILENAME":
+			return filepath.Base(filename), filename != ""
+		case "TM_FILENAME_BASE":
+			base := filepath.Base(filename)
+			return strings.TrimSuffix(base, filepath.Ext(base)), filename != ""
+		case "TM_FILEPATH":
+			return filename, filename != ""
+		case "TM_DIRECTORY":
+			return filepath.Dir(filename), filename != ""
+		case "TM_LINE_NUMBER":
+			return strconv.Itoa(l + 1), true
+		}
+		return "", false
+	}
+}
+
+//
+// Live snippets
+//
+
+// A snippetSession is a snippet expanded in a window, whose fields are still
+// visited with the snippet keymap.
+type snippetSession struct {
+	win     *Window
+	buf     Buffer
+	fields  []*snippetField
+	whole   snippetField // the range of the expanded text
+	nums    []int        // field numbers in the order they are visited, 0 last
+	current int          // index in nums
+
+	fresh   bool          // the current field still holds its placeholder
+	replace *snippetField // the placeholder to delete after text typed before it
+	syncing bool          // the session itself is changing the buffer
+}
+
+// ExpandSnippet replaces the trigger word before the cursor of w with the
+// snippet for the kind of its buffer.  It returns false if there is none.
+func (a *App) ExpandSnippet(w *Window) bool {
+	line, _ := w.buffer.GetLine(w.l, 0)
+	c0 := wordStart(line.Runes, w.c)
+	if c0 == w.c {
+		return false
+	}
+	snippet, ok := a.FindSnippet(w.buffer.Kind(), string(line.Runes[c0:w.c]))
+	if !ok {
+		return false
+	}
+	a.InsertSnippet(w, c0, snippet.Body)
+	return true
+}
+
+// InsertSnippet replaces the text of the cursor line of w from column c0 to
+// the cursor with the expansion of body, and moves the cursor to its first
+// field.
+func (a *App) InsertSnippet(w *Window, c0 int, body string) {
+	line, _ := w.buffer.GetLine(w.l, 0)
+	n := 0
+	for n < len(line.Runes) && (line.Runes[n] == ' ' || line.Runes[n] == '\t') {
+		n++
+	}
+	indent := line.Runes[:n]
+	text, fields := parseSnippet(body, string(indent), snippetVariables(w.buffer, w.l))
+	a.snippet = nil
+	l, c := w.l, c0
+	WithUndoGroup(w.buffer, func() {
+		if _, _, err := ReplaceRegion(w.buffer, l, c, l, w.c, text); err != nil {
+			a.ShowMessage("Cannot insert snippet: %s", err)
+			fields = nil
+		}
+	})
+	if fields == nil {
+		return
+	}
+	abs := func(fl, fc int) (int, int) {
+		if fl == 0 {
+			return l, c + fc
+		}
+		return l + fl, fc
+	}
+	s := &snippetSession{win: w, buf: w.buffer, fields: fields}
+	seen := map[int]bool{}
+	for _, f := range fields {
+		f.l0, f.c0 = abs(f.l0, f.c0)
+		f.l1, f.c1 = abs(f.l1, f.c1)
+		if !seen[f.num] {
+			seen[f.num] = true
+			s.nums = append(s.nums, f.num)
+		}
+	}
+	sort.Slice(s.nums, func(i, j int) bool {
+		ni, nj := s.nums[i], s.nums[j]
+		return ni != 0 && (nj == 0 || ni < nj)
+	})
+	s.whole.l0, s.whole.c0 = l, c
+	s.whole.l1, s.whole.c1 = abs(strings.Count(text, "\n"), lastLineLen(text))
+	a.listenToSnippetChanges(w.buffer)
+	a.snippet = s
+	s.syncMirrors()
+	s.visit(0)
+}
+
+func lastLineLen(s string) int {
+	return len([]rune(s[strings.LastIndexByte(s, '\n')+1:]))
+}
+
+// listenToSnippetChanges makes the fields of a live snippet in buf follow the
+// changes to buf.
+func (a *App) listenToSnippetChanges(buf Buffer) {
+	n, ok := buf.(ChangeNotifier)
+	if !ok || a.snippetBuffers[buf] {
+		return
+	}
+	a.snippetBuffers[buf] = true
+	n.AddChangeListener(func(change BufferChange) {
+		if s := a.snippet; s != nil && s.buf == buf {
+			s.changed(change)
+		}
+	})
+}
+
+// primary returns the first field with the current number, whose text the
+// mirrors follow.
+func (s *snippetSession) primary() *snippetField {
+	for _, f := range s.fields {
+		if f.num == s.nums[s.current] {
+			return f
+		}
+	}
+	return nil
+}
+
+// visit moves the cursor to the start of the ith field to visit.  Visiting the
+// last field, $0, ends the session.
+func (s *snippetSession) visit(i int) {
+	a := s.win.app
+	s.current = i
+	f := s.primary()
+	s.win.SetCursorPos(f.l0, f.c0)
+	s.fresh = !f.empty()
+	if f.num == 0 {
+		a.snippet = nil
+		s.win.SetCursorPos(f.l1, f.c1)
+		return
+	}
+	if len(f.choices) > 1 {
+		p := &completionPopup{win: s.win, line: f.l0, start: f.c0, col: -1}
+		for _, choice := range f.choices {
+			p.items = append(p.items, Completion{Text: choice, Start: f.c0})
+		}
+		a.completion = p
+		a.updateCompletion()
+	}
+}
+
+// changed moves the fields after a change to the buffer.
+func (s *snippetSession) changed(ch BufferChange) {
+	insertion := ch.Removed == "" && ch.Inserted != ""
+	typedOver := false
+	if !s.syncing && insertion && s.fresh {
+		f := s.primary()
+		typedOver = f.l0 == ch.L0 && f.c0 == ch.C0
+	}
+	if !s.syncing && (insertion || ch.Removed != "") {
+		s.fresh = false
+	}
+	if s.replace != nil {
+		s.replace.move(ch, false)
+	}
+	num := s.nums[s.current]
+	for _, f := range s.fields {
+		f.move(ch, f.num == num)
+	}
+	s.whole.move(ch, true)
+	if typedOver {
+		f := s.primary()
+		l, c := ch.InsertedEnd()
+		s.replace = &snippetField{l0: l, c0: c, l1: f.l1, c1: f.c1}
+	}
+}
+
+func (f *snippetField) empty() bool {
+	return f.l0 == f.l1 && f.c0 == f.c1
+}
+
+// move moves the range of a field after a change.  A field grows with text
+// inserted at its end if it is current, and moves with text inserted at its
+// start otherwise.
+func (f *snippetField) move(ch BufferChange, current bool) {
+	f.l0, f.c0 = movePos(f.l0, f.c0, ch, current)
+	f.l1, f.c1 = movePos(f.l1, f.c1, ch, !current)
+	if f.l1 < f.l0 || f.l1 == f.l0 && f.c1 < f.c0 {
+		f.l1, f.c1 = f.l0, f.c0
+	}
+}
+
+// movePos returns the position of (l, c) after a change.  Text inserted at the
+// position is before it unless sticky is true.
+func movePos(l, c int, ch BufferChange, sticky bool) (int, int) {
+	switch {
+	case l < ch.L0 || l == ch.L0 && c < ch.C0:
+		return l, c
+	case l == ch.L0 && c == ch.C0 && (sticky || ch.Inserted == ""):
+		return l, c
+	case l < ch.L1 || l == ch.L1 && c < ch.C1:
+		return ch.L0, ch.C0
+	}
+	el, ec := ch.InsertedEnd()
+	if l == ch.L1 {
+		return el, ec + c - ch.C1
+	}
+	return l + el - ch.L1, c
+}
+
+// update is called after each action: it deletes a placeholder which text was
+// typed over, copies the text of the current field to its mirrors and ends
+// the session if the cursor has left the snippet.
+func (s *snippetSession) update() {
+	a := s.win.app
+	w := s.win
+	if a.focusedWindow != w || w.buffer != s.buf {
+		a.snippet = nil
+		return
+	}
+	s.syncing = true
+	defer func() { s.syncing = false }()
+	if r := s.replace; r != nil {
+		s.replace = nil
+		w.buffer.DeleteRegion(r.l0, r.c0, r.l1, r.c1)
+	}
+	s.syncMirrors()
+	wh := s.whole
+	if w.l < wh.l0 || w.l == wh.l0 && w.c < wh.c0 || w.l > wh.l1 || w.l == wh.l1 && w.c > wh.c1 {
+		a.snippet = nil
+	}
+}
+
+// syncMirrors copies the text of each field to the following fields with the
+// same number.
+func (s *snippetSession) syncMirrors() {
+	s.syncing = true
+	defer func() { s.syncing = false }()
+	w := s.win
+	cursor := &snippetField{l0: w.l, c0: w.c, l1: w.l, c1: w.c}
+	texts := map[int]string{}
+	for _, f := range s.fields {
+		text, err := RegionString(w.buffer, f.l0, f.c0, f.l1, f.c1)
+		if err != nil {
+			continue
+		}
+		primary, ok := texts[f.num]
+		if !ok {
+			texts[f.num] = text
+			continue
+		}
+		if text == primary {
+			continue
+		}
+		l0, c0 := f.l0, f.c0
+		ch := BufferChange{L0: f.l0, C0: f.c0, L1: f.l1, C1: f.c1, Removed: text, Inserted: primary}
+		if _, _, err := ReplaceRegion(w.buffer, f.l0, f.c0, f.l1, f.c1, primary); err != nil {
+			continue
+		}
+		// The field was moved as if the text was inserted before it.
+		cursor.move(ch, false)
+		f.l0, f.c0 = l0, c0
+		f.l1, f.c1 = ch.InsertedEnd()
+	}
+	w.SetCursorPos(cursor.l0, cursor.c0)
+}
+
+// next visits the nth field after the current one, or before it if n < 0.
+func (s *snippetSession) next(n int) {
+	i := s.current + n
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.nums) {
+		i = len(s.nums) - 1
+	}
+	s.visit(i)
+}
+
+// snippetRanges returns the ranges of line l of the window in the fields of a
+// live snippet: the current field is reversed while it holds its placeholder,
+// other fields are underlined.
+func (w *Window) snippetRanges(l int) []styledRange {
+	s := w.app.snippet
+	if s == nil || s.win != w {
+		return nil
+	}
+	var ranges []styledRange
+	num := s.nums[s.current]
+	for _, f := range s.fields {
+		if f.num == 0 || l < f.l0 || l > f.l1 {
+			continue
+		}
+		r := styledRange{c1: -1, style: underline}
+		if l == f.l0 {
+			r.c0 = f.c0
+		}
+		if l == f.l1 {
+			r.c1 = f.c1
+		}
+		if f.num == num && s.fresh {
+			r.style = reverse
+		}
+		if r.c1 < 0 || r.c1 > r.c0 {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+func (a *App) updateSnippet() {
+	if a.snippet != nil {
+		a.snippet.update()
+	}
+}
+
+func CmdSnippetExpandOrTab(w *Window, n int) {
+	if !w.App().ExpandSnippet(w) {
+		CmdInsertRune('\t', n)(w)
+	}
+}
+
+func CmdSnippetNextField(w *Window, n int) {
+	if s := w.App().snippet; s != nil {
+		s.next(n)
+	}
+}
+
+func CmdSnippetPreviousField(w *Window, n int) { CmdSnippetNextField(w, -n) }
+
+func CmdSnippetExit(w *Window) { w.App().snippet = nil }
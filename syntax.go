@@ -0,0 +1,182 @@
+package edit
+
+import "strings"
+
+// A Syntax describes the lexical conventions of a buffer kind which commands
+// need to know about, e.g. to tell brackets in code from brackets in strings
+// and comments.
+type Syntax struct {
+	LineComment  string    // e.g. "//"
+	BlockComment [2]string // e.g. "/*" and "*/"
+	Quotes       string    // delimiters of strings ending with the line
+	RawQuotes    string    // delimiters of strings without escapes, which can span lines
+	Pairs        []string  // brackets, e.g. "()"
+	AutoPairs    []string  // pairs closed when the opener is typed, e.g. "()" or `""`
+}
+
+var (
+	defaultPairs     = []string{"()", "[]", "{}"}
+	defaultAutoPairs = []string{"()", "[]", "{}", `""`}
+)
+
+var defaultSyntaxes = map[string]*Syntax{
+	"c": {
+		LineComment:  "//",
+		BlockComment: [2]string{"/*", "*/"},
+		Quotes:       `"'`,
+		AutoPairs:    []string{"()", "[]", "{}", `""`, "''"},
+	},
+	"go": {
+		LineComment:  "//",
+		BlockComment: [2]string{"/*", "*/"},
+		Quotes:       `"'`,
+		RawQuotes:    "`",
+		AutoPairs:    []string{"()", "[]", "{}", `""`, "''", "``"},
+	},
+	"javascript": {
+		LineComment:  "//",
+		BlockComment: [2]string{"/*", "*/"},
+		Quotes:       `"'`,
+		RawQuotes:    "`",
+		AutoPairs:    []string{"()", "[]", "{}", `""`, "''", "``"},
+	},
+	"json": {
+		Quotes: `"`,
+	},
+	"lua": {
+		LineComment:  "--",
+		BlockComment: [2]string{"--[[", "]]"},
+		Quotes:       `"'`,
+		AutoPairs:    []string{"()", "[]", "{}", `""`, "''"},
+	},
+	"markdown": {
+		BlockComment: [2]string{"<!--", "-->"},
+	},
+	"python": {
+		LineComment: "#",
+		Quotes:      `"'`,
+		AutoPairs:   []string{"()", "[]", "{}", `""`, "''"},
+	},
+	"rust": {
+		LineComment:  "//",
+		BlockComment: [2]string{"/*", "*/"},
+		Quotes:       `"`,
+	},
+	"shell": {
+		LineComment: "#",
+		Quotes:      `"'`,
+		AutoPairs:   []string{"()", "[]", "{}", `""`, "''"},
+	},
+}
+
+func init() {
+	defaultSyntaxes["typescript"] = defaultSyntaxes["javascript"]
+}
+
+// SetSyntax sets the syntax of buffers of kind.  A nil syntax restores the
+// default one.
+func (a *App) SetSyntax(kind string, syn *Syntax) {
+	if syn == nil {
+		delete(a.syntaxes, kind)
+	} else {
+		a.syntaxes[kind] = syn
+	}
+}
+
+// Syntax returns the syntax of buffers of kind.  Kinds without a syntax have
+// brackets but no strings or comments.
+func (a *App) Syntax(kind string) *Syntax {
+	syn := a.syntaxes[kind]
+	if syn == nil {
+		syn = defaultSyntaxes[kind]
+	}
+	if syn == nil {
+		syn = &Syntax{}
+	}
+	if syn.Pairs == nil || syn.AutoPairs == nil {
+		s := *syn
+		if s.Pairs == nil {
+			s.Pairs = defaultPairs
+		}
+		if s.AutoPairs == nil {
+			s.AutoPairs = defaultAutoPairs
+		}
+		syn = &s
+	}
+	return syn
+}
+
+// maxScanLines limits how far scanning for matching brackets goes.
+const maxScanLines = 2000
+
+// scanCode calls f with the position of each rune of lines l0 to l1 of buf
+// which is not in a string or a comment, until f returns false.  Line l0 is
+// assumed to start outside strings and comments.
+func (syn *Syntax) scanCode(buf Buffer, l0, l1 int, f func(l, c int, r rune) bool) {
+	var quote rune
+	inBlock := false
+	hasPrefix := func(runes []rune, i int, s string) bool {
+		if s == "" {
+			return false
+		}
+		for _, r := range s {
+			if i >= len(runes) || runes[i] != r {
+				return false
+			}
+			i++
+		}
+		return true
+	}
+	for l := l0; l <= l1 && l < buf.LineCount(); l++ {
+		line, _ := buf.GetLine(l, 0)
+		runes := line.Runes
+		if !strings.ContainsRune(syn.RawQuotes, quote) {
+			quote = 0
+		}
+	scan:
+		for c := 0; c < len(runes); c++ {
+			r := runes[c]
+			switch {
+			case inBlock:
+				if hasPrefix(runes, c, syn.BlockComment[1]) {
+					c += len([]rune(syn.BlockComment[1])) - 1
+					inBlock = false
+				}
+			case quote != 0:
+				if r == '\\' && !strings.ContainsRune(syn.RawQuotes, quote) {
+					c++
+				} else if r == quote {
+					quote = 0
+				}
+			case hasPrefix(runes, c, syn.BlockComment[0]):
+				c += len([]rune(syn.BlockComment[0])) - 1
+				inBlock = true
+			case hasPrefix(runes, c, syn.LineComment):
+				break scan
+			case strings.ContainsRune(syn.Quotes, r) || strings.ContainsRune(syn.RawQuotes, r):
+				quote = r
+			default:
+				if !f(l, c, r) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// pairOf returns the pair of brackets r is part of, and true if r opens it.
+func pairOf(pairs []string, r rune) (string, bool, bool) {
+	for _, p := range pairs {
+		rp := []rune(p)
+		if len(rp) != 2 {
+			continue
+		}
+		if rp[0] == r {
+			return p, true, true
+		}
+		if rp[1] == r {
+			return p, false, true
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,32 @@
+package edit
+
+import "testing"
+
+func TestScanCode(t *testing.T) {
+	for _, test := range []struct {
+		kind, text, want string
+	}{
+		{"go", `f("(", ')') // (`, `f(,)`},
+		{"go", "a /* ( */ b\n/* (\n) */ c", "ab\n\nc"},
+		{"go", "x := `(\n)` + y", "x:=\n+y"},
+		{"lua", "a --[[ ( ]] b -- (", "ab"},
+		{"go", "a /", "a/"},
+		{"", "(\"\")", "(\"\")"},
+	} {
+		a, w := newTestApp(test.text)
+		var got []rune
+		line := 0
+		a.Syntax(test.kind).scanCode(w.buffer, 0, w.buffer.LineCount()-1, func(l, c int, r rune) bool {
+			for ; line < l; line++ {
+				got = append(got, '\n')
+			}
+			if r != ' ' {
+				got = append(got, r)
+			}
+			return true
+		})
+		if string(got) != test.want {
+			t.Errorf("%s %q: got %q want %q", test.kind, test.text, string(got), test.want)
+		}
+	}
+}
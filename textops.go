@@ -0,0 +1,324 @@
+package edit
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Commands which transform text: changing case, converting identifiers and
+// transposing characters, words or lines.  Like in emacs, word commands act on
+// the text from the cursor to the end of the next word, or on the highlighted
+// region if there is one.
+
+// TransformRegion replaces the text from (l0, c0) up to but not including (l1,
+// c1) with the result of f, and returns the position of its end.
+func (w *Window) TransformRegion(l0, c0, l1, c1 int, f func(string) string) (int, int, error) {
+	if l1 < l0 || (l0 == l1 && c1 < c0) {
+		l0, c0, l1, c1 = l1, c1, l0, c0
+	}
+	s, err := RegionString(w.buffer, l0, c0, l1, c1)
+	if err != nil {
+		return l0, c0, err
+	}
+	t := f(s)
+	if t == s {
+		return l1, c1, nil
+	}
+	var l, c int
+	WithUndoGroup(w.buffer, func() {
+		l, c, err = ReplaceRegion(w.buffer, l0, c0, l1, c1, t)
+	})
+	return l, c, err
+}
+
+// forwardWord returns the position of the end of the word after (l, c), like
+// forward-word in emacs.
+func (w *Window) forwardWord(l, c int) (int, int) {
+	ok := true
+	for ok && !isWordRune(w.runeAt(l, c)) {
+		l, c, ok = w.nextPos(l, c)
+	}
+	for ok && isWordRune(w.runeAt(l, c)) {
+		l, c, ok = w.nextPos(l, c)
+	}
+	return l, c
+}
+
+// backwardWord returns the position of the start of the word before (l, c),
+// like backward-word in emacs.
+func (w *Window) backwardWord(l, c int) (int, int) {
+	for {
+		l1, c1, ok := w.prevPos(l, c)
+		if !ok || isWordRune(w.runeAt(l1, c1)) {
+			break
+		}
+		l, c = l1, c1
+	}
+	for {
+		l1, c1, ok := w.prevPos(l, c)
+		if !ok || !isWordRune(w.runeAt(l1, c1)) {
+			return l, c
+		}
+		l, c = l1, c1
+	}
+}
+
+// TransformWords applies f to the highlighted region, or to the text from the
+// cursor to the end of the nth word after it and moves the cursor there.
+func (w *Window) TransformWords(n int, f func(string) string) error {
+	if l0, c0, l1, c1, err := w.highlightedRange(); err == nil {
+		_, _, err = w.TransformRegion(l0, c0, l1, c1, f)
+		return err
+	}
+	l, c := w.l, w.c
+	for i := 0; i < n; i++ {
+		l, c = w.forwardWord(l, c)
+	}
+	l, c, err := w.TransformRegion(w.l, w.c, l, c, f)
+	w.SetCursorPos(l, c)
+	return err
+}
+
+// swapCase makes upper case letters lower case and vice versa.
+func swapCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, s)
+}
+
+// capitalize makes the first letter of each word of s upper case and the
+// others lower case.
+func capitalize(s string) string {
+	runes := []rune(s)
+	inWord := false
+	for i, r := range runes {
+		if inWord {
+			runes[i] = unicode.ToLower(r)
+		} else {
+			runes[i] = unicode.ToTitle(r)
+		}
+		inWord = isWordRune(r)
+	}
+	return string(runes)
+}
+
+//
+// Identifiers
+//
+
+// identifier matches identifiers in any of the cases which can be converted.
+// As hyphens are also minus signs, a hyphenated word is only an identifier if
+// it is in kebab case, i.e. made of lower case words starting with a letter:
+// "foo-bar" is one identifier but "i-1" and "Foo-Bar" are two.
+var identifier = regexp.MustCompile(`\p{Ll}[\p{Ll}\pN]*(-\p{Ll}[\p{Ll}\pN]*)+|[\pL\pN_]+`)
+
+// hyphenatedWord matches a text which is a single word, hyphens included.
+var hyphenatedWord = regexp.MustCompile(`^[\pL\pN_]+(-[\pL\pN_]+)*$`)
+
+// identifierWords splits an identifier into its lower case words, e.g.
+// "parseHTTPHeader" into "parse", "http" and "header".
+func identifierWords(s string) []string {
+	var words []string
+	var word []rune
+	runes := []rune(s)
+	for i, r := range runes {
+		if r == '_' || r == '-' {
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(prev) || nextLower {
+				words = append(words, string(word))
+				word = nil
+			}
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// An IdentifierCase converts an identifier to a naming convention.
+type IdentifierCase func(string) string
+
+// CamelCase converts e.g. "parse_http_header" to "parseHttpHeader".
+func CamelCase(s string) string {
+	words := identifierWords(s)
+	for i := 1; i < len(words); i++ {
+		words[i] = capitalize(words[i])
+	}
+	return keepUnderscores(s, strings.Join(words, ""))
+}
+
+// SnakeCase converts e.g. "parseHTTPHeader" to "parse_http_header".
+func SnakeCase(s string) string {
+	return keepUnderscores(s, strings.Join(identifierWords(s), "_"))
+}
+
+// KebabCase converts e.g. "parseHTTPHeader" to "parse-http-header".
+func KebabCase(s string) string {
+	return keepUnderscores(s, strings.Join(identifierWords(s), "-"))
+}
+
+// keepUnderscores keeps the leading and trailing underscores of s around t,
+// as they usually matter.
+func keepUnderscores(s, t string) string {
+	if t == "" {
+		return s
+	}
+	trimmed := strings.Trim(s, "_")
+	start := strings.Index(s, trimmed)
+	return s[:start] + t + s[start+len(trimmed):]
+}
+
+// identifierBounds returns the bounds of the identifier at or just before
+// column c of line l, the end being exclusive.
+func (w *Window) identifierBounds(l, c int) (int, int, bool) {
+	line, _ := w.buffer.GetLine(l, 0)
+	s := string(line.Runes)
+	for _, m := range identifier.FindAllStringIndex(s, -1) {
+		c0 := len([]rune(s[:m[0]]))
+		c1 := c0 + len([]rune(s[m[0]:m[1]]))
+		if c0 <= c && c <= c1 {
+			return c0, c1, true
+		}
+	}
+	return 0, 0, false
+}
+
+// ConvertIdentifiers converts the identifiers in the highlighted region, or
+// the identifier at the cursor, with f.  A region which is a single hyphenated
+// word is converted as one identifier.
+func (w *Window) ConvertIdentifiers(f IdentifierCase) error {
+	convert := func(s string) string {
+		if hyphenatedWord.MatchString(s) {
+			return f(s)
+		}
+		return identifier.ReplaceAllStringFunc(s, f)
+	}
+	if l0, c0, l1, c1, err := w.highlightedRange(); err == nil {
+		_, _, err = w.TransformRegion(l0, c0, l1, c1, convert)
+		return err
+	}
+	c0, c1, ok := w.identifierBounds(w.l, w.c)
+	if !ok {
+		return errors.New("no identifier at the cursor")
+	}
+	_, c, err := w.TransformRegion(w.l, c0, w.l, c1, convert)
+	if w.c == c1 {
+		w.c = c
+	}
+	return err
+}
+
+//
+// Transpositions
+//
+
+// TransposeRunes swaps the runes before and after the cursor and moves the
+// cursor forward, or swaps the two runes before the cursor at the end of a
+// line.
+func (w *Window) TransposeRunes() error {
+	l, c := w.l, w.c
+	if c == w.lineLen(l) {
+		c--
+	}
+	if c < 1 {
+		return errors.New("nothing to transpose")
+	}
+	_, _, err := w.TransformRegion(l, c-1, l, c+1, func(s string) string {
+		r := []rune(s)
+		return string([]rune{r[1], r[0]})
+	})
+	w.SetCursorPos(l, c+1)
+	return err
+}
+
+// TransposeWords swaps the word before the cursor, or containing it, with the
+// next one and moves the cursor after them.
+func (w *Window) TransposeWords() error {
+	l, c := w.l, w.c
+	if l0, c0, ok := w.prevPos(l, c); ok && isWordRune(w.runeAt(l0, c0)) && isWordRune(w.runeAt(l, c)) {
+		l, c = w.forwardWord(l, c)
+	}
+	l2, c2 := w.forwardWord(l, c)
+	ls2, cs2 := w.backwardWord(l2, c2)
+	ls1, cs1 := w.backwardWord(l, c)
+	le1, ce1 := w.forwardWord(ls1, cs1)
+	if ls2 < le1 || ls2 == le1 && cs2 < ce1 || ls1 == ls2 && cs1 == cs2 {
+		return errors.New("no words to transpose")
+	}
+	word1, _ := RegionString(w.buffer, ls1, cs1, le1, ce1)
+	between, _ := RegionString(w.buffer, le1, ce1, ls2, cs2)
+	word2, _ := RegionString(w.buffer, ls2, cs2, l2, c2)
+	l, c, err := w.TransformRegion(ls1, cs1, l2, c2, func(string) string {
+		return word2 + between + word1
+	})
+	w.SetCursorPos(l, c)
+	return err
+}
+
+// TransposeLines swaps the cursor line with the previous one and moves the
+// cursor to the next line, like transpose-lines in emacs.
+func (w *Window) TransposeLines() error {
+	l := w.l
+	if l == 0 {
+		return errors.New("no previous line")
+	}
+	if err := w.MoveLines(l-1, l-1, 1); err != nil {
+		return err
+	}
+	w.SetCursorPos(l+1, 0)
+	return nil
+}
+
+func CmdTransformWords(f func(string) string) func(w *Window, n int) {
+	return func(w *Window, n int) {
+		showError(w, w.TransformWords(n, f))
+	}
+}
+
+func CmdConvertIdentifiers(f IdentifierCase) func(w *Window) {
+	return func(w *Window) {
+		showError(w, w.ConvertIdentifiers(f))
+	}
+}
+
+func CmdTransposeRunes(w *Window, n int) {
+	for i := 0; i < n; i++ {
+		if err := w.TransposeRunes(); err != nil {
+			showError(w, err)
+			return
+		}
+	}
+}
+
+func CmdTransposeWords(w *Window, n int) {
+	for i := 0; i < n; i++ {
+		if err := w.TransposeWords(); err != nil {
+			showError(w, err)
+			return
+		}
+	}
+}
+
+func CmdTransposeLines(w *Window, n int) {
+	for i := 0; i < n; i++ {
+		if err := w.TransposeLines(); err != nil {
+			showError(w, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdentifierWords(t *testing.T) {
+	for _, test := range []struct {
+		id   string
+		want []string
+	}{
+		{"parseHTTPHeader", []string{"parse", "http", "header"}},
+		{"ParseHeader", []string{"parse", "header"}},
+		{"parse_http_header", []string{"parse", "http", "header"}},
+		{"parse-http-header", []string{"parse", "http", "header"}},
+		{"HTTPServer2", []string{"http", "server2"}},
+		{"__init__", []string{"init"}},
+		{"URL", []string{"url"}},
+		{"a", []string{"a"}},
+		{"_", nil},
+	} {
+		if got := identifierWords(test.id); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q want %q", test.id, got, test.want)
+		}
+	}
+}
+
+func TestIdentifierCases(t *testing.T) {
+	for _, test := range []struct {
+		id                  string
+		camel, snake, kebab string
+	}{
+		{"parseHTTPHeader", "parseHttpHeader", "parse_http_header", "parse-http-header"},
+		{"parse_http_header", "parseHttpHeader", "parse_http_header", "parse-http-header"},
+		{"parse-http-header", "parseHttpHeader", "parse_http_header", "parse-http-header"},
+		{"ParseHeader", "parseHeader", "parse_header", "parse-header"},
+		{"_private_field", "_privateField", "_private_field", "_private-field"},
+		{"__init__", "__init__", "__init__", "__init__"},
+		{"__", "__", "__", "__"},
+	} {
+		for _, c := range []struct {
+			name string
+			f    IdentifierCase
+			want string
+		}{
+			{"camel", CamelCase, test.camel},
+			{"snake", SnakeCase, test.snake},
+			{"kebab", KebabCase, test.kebab},
+		} {
+			if got := c.f(test.id); got != c.want {
+				t.Errorf("%s case of %q: got %q want %q", c.name, test.id, got, c.want)
+			}
+		}
+	}
+}
+
+// Hyphens are minus signs unless the words around them are in kebab case or
+// selected on their own.
+func TestConvertIdentifiersHyphens(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		c0, c1 int // the selection, or the cursor if c1 < 0
+		f      IdentifierCase
+		want   string
+	}{
+		{"x := i-1", 0, 8, CamelCase, "x := i-1"},
+		{"x := i-1", 0, 8, SnakeCase, "x := i-1"},
+		{"x := i-1", 5, -1, SnakeCase, "x := i-1"},
+		{"n := a-b", 0, 8, CamelCase, "n := aB"},
+		{"n := Foo-Bar", 0, 12, SnakeCase, "n := foo-bar"},
+		{"Foo-Bar", 0, 7, SnakeCase, "foo_bar"},
+		{"x[i-1] = my-var", 9, -1, SnakeCase, "x[i-1] = my_var"},
+	} {
+		_, w := newTestApp(test.text)
+		w.SetCursorPos(0, test.c0)
+		if test.c1 >= 0 {
+			w.SetAnchor(false)
+			w.SetCursorPos(0, test.c1)
+			w.updateAnchoredRegion()
+		}
+		if err := w.ConvertIdentifiers(test.f); err != nil {
+			t.Fatal(err)
+		}
+		if got := bufText(w); got != test.want {
+			t.Errorf("%q: got %q want %q", test.text, got, test.want)
+		}
+	}
+}
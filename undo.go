@@ -0,0 +1,163 @@
+package edit
+
+// An Undoer is a Buffer which records its changes so that they can be undone.
+// Changes made between BeginUndoGroup and the matching EndUndoGroup are undone
+// as one step.  Groups can be nested, only the outermost one counts.
+type Undoer interface {
+	BeginUndoGroup()
+	EndUndoGroup()
+
+	// Undo undoes the last step and returns the position of the change.  It
+	// returns false if there is nothing to undo.
+	Undo() (l, c int, ok bool)
+
+	// Redo redoes the last undone step, if no change was made since.
+	Redo() (l, c int, ok bool)
+}
+
+var _ Undoer = (*FileBuffer)(nil)
+
+type undoHistory struct {
+	done   [][]BufferChange
+	undone [][]BufferChange
+
+	group []BufferChange // changes of the open group
+	depth int            // nesting level of groups
+
+	undoing, redoing bool
+}
+
+// maxUndoSteps is the number of steps kept in the history of a buffer.
+const maxUndoSteps = 1000
+
+// record adds a change to the history.
+func (h *undoHistory) record(change BufferChange) {
+	h.group = append(h.group, change)
+	if h.undoing || h.redoing {
+		return
+	}
+	h.undone = nil
+	if h.depth == 0 {
+		h.closeGroup(&h.done)
+	}
+}
+
+func (h *undoHistory) closeGroup(stack *[][]BufferChange) {
+	if len(h.group) == 0 {
+		return
+	}
+	*stack = append(*stack, h.group)
+	h.group = nil
+	if n := len(*stack); n > maxUndoSteps {
+		*stack = append([][]BufferChange(nil), (*stack)[n-maxUndoSteps:]...)
+	}
+}
+
+func (b *FileBuffer) BeginUndoGroup() {
+	b.history.depth++
+}
+
+func (b *FileBuffer) EndUndoGroup() {
+	h := &b.history
+	if h.depth == 0 {
+		return
+	}
+	h.depth--
+	if h.depth == 0 {
+		h.closeGroup(&h.done)
+	}
+}
+
+// Undo undoes the last step.  Called in a group, e.g. by an action, the
+// changes made so far in the group are a step of their own.
+func (b *FileBuffer) Undo() (int, int, bool) {
+	h := &b.history
+	h.closeGroup(&h.done)
+	if len(h.done) == 0 {
+		return 0, 0, false
+	}
+	changes := h.done[len(h.done)-1]
+	h.done = h.done[:len(h.done)-1]
+	h.undoing = true
+	l, c := b.revert(changes)
+	h.undoing = false
+	h.closeGroup(&h.undone)
+	return l, c, true
+}
+
+func (b *FileBuffer) Redo() (int, int, bool) {
+	h := &b.history
+	if len(h.group) > 0 || len(h.undone) == 0 {
+		return 0, 0, false
+	}
+	changes := h.undone[len(h.undone)-1]
+	h.undone = h.undone[:len(h.undone)-1]
+	h.redoing = true
+	l, c := b.revert(changes)
+	h.redoing = false
+	h.closeGroup(&h.done)
+	return l, c, true
+}
+
+// revert applies the inverse of changes in reverse order and returns the
+// position of the first change.
+func (b *FileBuffer) revert(changes []BufferChange) (l, c int) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if change.Inserted != "" {
+			l1, c1 := change.InsertedEnd()
+			b.DeleteRegion(change.L0, change.C0, l1, c1)
+		}
+		if change.Removed != "" {
+			b.InsertString(change.Removed, change.L0, change.C0)
+		}
+		l, c = change.L0, change.C0
+	}
+	return l, c
+}
+
+// WithUndoGroup calls f so that the changes it makes to buf are undone as one
+// step.
+func WithUndoGroup(buf Buffer, f func()) {
+	if u, ok := buf.(Undoer); ok {
+		u.BeginUndoGroup()
+		defer u.EndUndoGroup()
+	}
+	f()
+}
+
+// CmdUndo undoes the last change to the buffer of the window.
+func CmdUndo(w *Window) {
+	u, ok := w.buffer.(Undoer)
+	if !ok {
+		w.App().ShowMessage("Buffer cannot be undone")
+		return
+	}
+	if fb, ok := w.buffer.(*FileBuffer); ok && fb.readOnly {
+		w.App().ShowMessage("Buffer is read only")
+		return
+	}
+	if l, c, ok := u.Undo(); ok {
+		w.SetCursorPos(l, c)
+	} else {
+		w.App().ShowMessage("No further undo information")
+	}
+}
+
+// CmdRedo redoes the last change undone in the buffer of the window.
+func CmdRedo(w *Window) {
+	u, ok := w.buffer.(Undoer)
+	if !ok {
+		w.App().ShowMessage("Buffer cannot be undone")
+		return
+	}
+	if fb, ok := w.buffer.(*FileBuffer); ok && fb.readOnly {
+		w.App().ShowMessage("Buffer is read only")
+		return
+	}
+	if l, c, ok := u.Redo(); ok {
+		w.SetCursorPos(l, c)
+	} else {
+		w.App().ShowMessage("No further redo information")
+	}
+}
@@ -0,0 +1,103 @@
+package edit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUndoHistory(t *testing.T) {
+	// add types s at the end of the first line.
+	add := func(b *FileBuffer, s string) {
+		line, _ := b.GetLine(0, 0)
+		b.InsertString(s, 0, len(line.Runes))
+	}
+	undo := func(b *FileBuffer) bool { _, _, ok := b.Undo(); return ok }
+	redo := func(b *FileBuffer) bool { _, _, ok := b.Redo(); return ok }
+	for _, test := range []struct {
+		name   string
+		edit   func(b *FileBuffer) bool // returns the result of the last undo or redo
+		want   string
+		wantOK bool
+	}{
+		{"undo one change", func(b *FileBuffer) bool {
+			add(b, "a")
+			add(b, "b")
+			return undo(b)
+		}, "a", true},
+		{"undo a group", func(b *FileBuffer) bool {
+			add(b, "a")
+			b.BeginUndoGroup()
+			add(b, "b")
+			add(b, "c")
+			b.EndUndoGroup()
+			return undo(b)
+		}, "a", true},
+		{"nested groups are one step", func(b *FileBuffer) bool {
+			b.BeginUndoGroup()
+			add(b, "a")
+			b.BeginUndoGroup()
+			add(b, "b")
+			b.EndUndoGroup()
+			add(b, "c")
+			b.EndUndoGroup()
+			return undo(b)
+		}, "", true},
+		{"undo in a group", func(b *FileBuffer) bool {
+			add(b, "a")
+			b.BeginUndoGroup()
+			add(b, "b")
+			ok := undo(b)
+			add(b, "c")
+			b.EndUndoGroup()
+			return ok
+		}, "ac", true},
+		{"nothing to undo", func(b *FileBuffer) bool {
+			return undo(b)
+		}, "", false},
+		{"redo", func(b *FileBuffer) bool {
+			add(b, "a")
+			add(b, "b")
+			undo(b)
+			undo(b)
+			redo(b)
+			return redo(b)
+		}, "ab", true},
+		{"nothing to redo", func(b *FileBuffer) bool {
+			add(b, "a")
+			undo(b)
+			redo(b)
+			return redo(b)
+		}, "a", false},
+		{"a change invalidates redo", func(b *FileBuffer) bool {
+			add(b, "a")
+			add(b, "b")
+			undo(b)
+			add(b, "c")
+			return redo(b)
+		}, "ac", false},
+		{"undo a multiline change", func(b *FileBuffer) bool {
+			add(b, "a\nb\nc")
+			b.DeleteRegion(0, 1, 2, 0)
+			return undo(b)
+		}, "a\nb\nc", true},
+		{"the history is bounded", func(b *FileBuffer) bool {
+			for i := 0; i <= maxUndoSteps; i++ {
+				add(b, "a")
+			}
+			for undo(b) {
+			}
+			return undo(b)
+		}, "a", false},
+	} {
+		b := NewEmptyFileBuffer()
+		ok := test.edit(b)
+		var lines []string
+		for l := 0; l < b.LineCount(); l++ {
+			line, _ := b.GetLine(l, 0)
+			lines = append(lines, line.String())
+		}
+		if got := strings.Join(lines, "\n"); got != test.want || ok != test.wantOK {
+			t.Errorf("%s: got %q, %t want %q, %t", test.name, got, ok, test.want, test.wantOK)
+		}
+	}
+}
@@ -0,0 +1,648 @@
+package edit
+
+import (
+	"strings"
+	"time"
+)
+
+// Modal editing in the style of vi.
+//
+// The modes "normal", "insert" and "visual" each have an event handler which
+// takes precedence over the window and app handlers while the mode is active.
+// Events which a mode does not bind fall through to the default bindings, so
+// e.g. insert mode only needs to bind Esc.
+
+// A viMotion moves the cursor.  Motions can be used on their own, or following
+// an operator to define the text it operates on.
+type viMotion struct {
+	seq       string
+	move      func(w *Window, n int, args []interface{})
+	linewise  bool // operators act on whole lines
+	inclusive bool // operators include the character at the destination
+}
+
+// A viTextObject selects a region around the cursor, following an operator or
+// in visual mode.
+type viTextObject struct {
+	seq    string
+	region func(w *Window) (l0, c0, l1, c1 int, ok bool) // end is exclusive
+}
+
+// A viOperator acts on a region, the end being exclusive.
+type viOperator struct {
+	seq   string
+	name  string // name of the command applying the operator
+	apply func(w *Window, l0, c0, l1, c1 int, linewise bool)
+}
+
+// A viRegister holds text yanked or deleted by operators.
+type viRegister struct {
+	text     string
+	linewise bool
+}
+
+func repeatMove(f func(w *Window, l, c int) (int, int)) func(w *Window, n int, args []interface{}) {
+	return func(w *Window, n int, args []interface{}) {
+		for i := 0; i < n; i++ {
+			w.l, w.c = f(w, w.l, w.c)
+		}
+	}
+}
+
+func (w *Window) viMoveInLine(dc int) {
+	c := w.c + dc
+	if c < 0 {
+		c = 0
+	} else if n := w.lineLen(w.l); c > n {
+		c = n
+	}
+	w.c = c
+}
+
+// viFind moves to the nth occurrence of r on the current line, forwards if dir
+// is 1 and backwards if dir is -1.  If till is true, it stops before r.
+func (w *Window) viFind(r rune, n, dir int, till bool) {
+	line, _ := w.buffer.GetLine(w.l, 0)
+	c := w.c
+	if till {
+		c += dir
+	}
+	for c += dir; c >= 0 && c < line.Len(); c += dir {
+		if line.Runes[c] == r {
+			n--
+			if n == 0 {
+				if till {
+					c -= dir
+				}
+				w.c = c
+				return
+			}
+		}
+	}
+}
+
+func viFindMotion(seq string, dir int, till bool, inclusive bool) viMotion {
+	return viMotion{
+		seq: seq + " Rune.Rune",
+		move: func(w *Window, n int, args []interface{}) {
+			w.viFind(args[0].(rune), n, dir, till)
+		},
+		inclusive: inclusive,
+	}
+}
+
+var viMotions = []viMotion{
+	{seq: "h", move: func(w *Window, n int, args []interface{}) { w.viMoveInLine(-n) }},
+	{seq: "l", move: func(w *Window, n int, args []interface{}) { w.viMoveInLine(n) }},
+	{seq: "j", move: func(w *Window, n int, args []interface{}) { w.MoveCursor(n, 0) }, linewise: true},
+	{seq: "k", move: func(w *Window, n int, args []interface{}) { w.MoveCursor(-n, 0) }, linewise: true},
+	{seq: "w", move: repeatMove(func(w *Window, l, c int) (int, int) { return w.nextWordStart(l, c, false) })},
+	{seq: "W", move: repeatMove(func(w *Window, l, c int) (int, int) { return w.nextWordStart(l, c, true) })},
+	{seq: "b", move: repeatMove(func(w *Window, l, c int) (int, int) { return w.prevWordStart(l, c, false) })},
+	{seq: "B", move: repeatMove(func(w *Window, l, c int) (int, int) { return w.prevWordStart(l, c, true) })},
+	{seq: "e", move: repeatMove(func(w *Window, l, c int) (int, int) { return w.wordEnd(l, c, false) }), inclusive: true},
+	{seq: "E", move: repeatMove(func(w *Window, l, c int) (int, int) { return w.wordEnd(l, c, true) }), inclusive: true},
+	{seq: "0", move: func(w *Window, n int, args []interface{}) { w.MoveCursorToLineStart() }},
+	{seq: "^", move: func(w *Window, n int, args []interface{}) { w.c = w.firstNonBlank(w.l) }},
+	{seq: "$", move: func(w *Window, n int, args []interface{}) {
+		w.MoveCursor(n-1, 0)
+		w.MoveCursorToLineEnd()
+	}},
+	{seq: "g g", move: func(w *Window, n int, args []interface{}) {
+		w.SetCursorPos(n-1, 0)
+		w.c = w.firstNonBlank(w.l)
+	}, linewise: true},
+	// As the count is 1 when none is given, "1G" goes to the last line.
+	{seq: "G", move: func(w *Window, n int, args []interface{}) {
+		if n > 1 {
+			w.SetCursorPos(n-1, 0)
+		} else {
+			w.MoveCursorToEnd()
+		}
+		w.c = w.firstNonBlank(w.l)
+	}, linewise: true},
+	viFindMotion("f", 1, false, true),
+	viFindMotion("F", -1, false, false),
+	viFindMotion("t", 1, true, true),
+	viFindMotion("T", -1, true, false),
+}
+
+func viWordObject(seq string, big, around bool) viTextObject {
+	return viTextObject{
+		seq: seq,
+		region: func(w *Window) (int, int, int, int, bool) {
+			c0, c1 := w.wordBounds(w.l, w.c, big)
+			if c0 == c1 {
+				return 0, 0, 0, 0, false
+			}
+			if around {
+				line, _ := w.buffer.GetLine(w.l, 0)
+				n := c1
+				for n < line.Len() && runeClass(line.Runes[n], big) == 0 {
+					n++
+				}
+				if n > c1 {
+					c1 = n
+				} else {
+					for c0 > 0 && runeClass(line.Runes[c0-1], big) == 0 {
+						c0--
+					}
+				}
+			}
+			return w.l, c0, w.l, c1, true
+		},
+	}
+}
+
+func viQuoteObject(seq string, q rune, around bool) viTextObject {
+	return viTextObject{
+		seq: seq,
+		region: func(w *Window) (int, int, int, int, bool) {
+			line, _ := w.buffer.GetLine(w.l, 0)
+			runes := line.Runes
+			q0 := -1
+			for i := w.c; i >= 0 && i < len(runes); i-- {
+				if runes[i] == q {
+					q0 = i
+					break
+				}
+			}
+			if q0 == -1 {
+				for i := w.c; i < len(runes); i++ {
+					if runes[i] == q {
+						q0 = i
+						break
+					}
+				}
+			}
+			if q0 == -1 {
+				return 0, 0, 0, 0, false
+			}
+			q1 := -1
+			for i := q0 + 1; i < len(runes); i++ {
+				if runes[i] == q {
+					q1 = i
+					break
+				}
+			}
+			if q1 == -1 {
+				return 0, 0, 0, 0, false
+			}
+			if around {
+				return w.l, q0, w.l, q1 + 1, true
+			}
+			return w.l, q0 + 1, w.l, q1, true
+		},
+	}
+}
+
+func viBracketObject(seq string, open, close rune, around bool) viTextObject {
+	return viTextObject{
+		seq: seq,
+		region: func(w *Window) (int, int, int, int, bool) {
+			l, c := w.l, w.c
+			if w.runeAt(l, c) == close {
+				l, c, _ = w.prevPos(l, c)
+			}
+			l0, c0, ok := w.findOpenBracket(l, c, open, close)
+			if !ok {
+				return 0, 0, 0, 0, false
+			}
+			l1, c1, _ := w.nextPos(l0, c0)
+			l1, c1, ok = w.findCloseBracket(l1, c1, open, close)
+			if !ok {
+				return 0, 0, 0, 0, false
+			}
+			if around {
+				l1, c1, _ = w.nextPos(l1, c1)
+			} else {
+				l0, c0, _ = w.nextPos(l0, c0)
+			}
+			return l0, c0, l1, c1, true
+		},
+	}
+}
+
+var viTextObjects = []viTextObject{
+	viWordObject("i w", false, false),
+	viWordObject("a w", false, true),
+	viWordObject("i W", true, false),
+	viWordObject("a W", true, true),
+	viQuoteObject("i \"", '"', false),
+	viQuoteObject("a \"", '"', true),
+	viQuoteObject("i '", '\'', false),
+	viQuoteObject("a '", '\'', true),
+	viQuoteObject("i `", '`', false),
+	viQuoteObject("a `", '`', true),
+	viBracketObject("i (", '(', ')', false),
+	viBracketObject("a (", '(', ')', true),
+	viBracketObject("i )", '(', ')', false),
+	viBracketObject("a )", '(', ')', true),
+	viBracketObject("i b", '(', ')', false),
+	viBracketObject("a b", '(', ')', true),
+	viBracketObject("i [", '[', ']', false),
+	viBracketObject("a [", '[', ']', true),
+	viBracketObject("i ]", '[', ']', false),
+	viBracketObject("a ]", '[', ']', true),
+	viBracketObject("i {", '{', '}', false),
+	viBracketObject("a {", '{', '}', true),
+	viBracketObject("i }", '{', '}', false),
+	viBracketObject("a }", '{', '}', true),
+	viBracketObject("i B", '{', '}', false),
+	viBracketObject("a B", '{', '}', true),
+	viBracketObject("i <", '<', '>', false),
+	viBracketObject("a <", '<', '>', true),
+	viBracketObject("i >", '<', '>', false),
+	viBracketObject("a >", '<', '>', true),
+}
+
+var viOperators = []viOperator{
+	{seq: "d", name: "vi-delete", apply: func(w *Window, l0, c0, l1, c1 int, linewise bool) {
+		w.viYank(l0, c0, l1, c1, linewise)
+		w.viDelete(l0, c0, l1, c1, linewise)
+	}},
+	{seq: "c", name: "vi-change", apply: func(w *Window, l0, c0, l1, c1 int, linewise bool) {
+		w.viYank(l0, c0, l1, c1, linewise)
+		if linewise {
+			w.DeleteRegion(l0, 0, l1, w.lineLen(l1))
+		} else {
+			w.DeleteRegion(l0, c0, l1, c1)
+		}
+		w.App().SetMode("insert")
+	}},
+	{seq: "y", name: "vi-yank", apply: func(w *Window, l0, c0, l1, c1 int, linewise bool) {
+		w.viYank(l0, c0, l1, c1, linewise)
+		if linewise {
+			c0 = w.c
+		}
+		w.SetCursorPos(l0, c0)
+	}},
+	viCaseOperator("g u", "vi-downcase", strings.ToLower),
+	viCaseOperator("g U", "vi-upcase", strings.ToUpper),
+	viCaseOperator("g ~", "vi-swap-case", swapCase),
+	{seq: "g c", name: "vi-comment", apply: func(w *Window, l0, c0, l1, c1 int, linewise bool) {
+		if c1 == 0 && l1 > l0 && !linewise {
+			l1--
+		}
+		if err := w.ToggleComment(l0, l1); err != nil {
+			w.App().ShowMessage("Cannot toggle comment: %s", err)
+		}
+	}},
+}
+
+// viCaseOperator returns an operator changing the case of text with f.
+func viCaseOperator(seq, name string, f func(string) string) viOperator {
+	return viOperator{seq: seq, name: name, apply: func(w *Window, l0, c0, l1, c1 int, linewise bool) {
+		if linewise {
+			c0, c1 = 0, w.lineLen(l1)
+		}
+		if _, _, err := w.TransformRegion(l0, c0, l1, c1, f); err != nil {
+			w.App().ShowMessage("%s", err)
+		}
+		w.SetCursorPos(l0, c0)
+	}}
+}
+
+func findViMotion(seq string) viMotion {
+	for _, m := range viMotions {
+		if m.seq == seq {
+			return m
+		}
+	}
+	panic("unknown motion " + seq)
+}
+
+func findViOperator(seq string) viOperator {
+	for _, op := range viOperators {
+		if op.seq == seq {
+			return op
+		}
+	}
+	panic("unknown operator " + seq)
+}
+
+// viLastLine returns the last line of a count of n lines starting at the
+// cursor.
+func (w *Window) viLastLine(n int) int {
+	l := w.l + n - 1
+	if max := w.buffer.LineCount() - 1; l > max {
+		l = max
+	}
+	return l
+}
+
+// viMotionRegion returns the region between the cursor and the destination of
+// the motion, in order.
+func (w *Window) viMotionRegion(m viMotion, n int, args []interface{}) (int, int, int, int) {
+	l0, c0 := w.l, w.c
+	m.move(w, n, args)
+	l1, c1 := w.l, w.c
+	w.l, w.c = l0, c0
+	if l1 < l0 || (l0 == l1 && c1 < c0) {
+		l0, c0, l1, c1 = l1, c1, l0, c0
+	}
+	if m.inclusive {
+		l1, c1 = w.buffer.AdvancePos(l1, c1, 0, 1)
+	}
+	return l0, c0, l1, c1
+}
+
+// viYank copies a region into the register.
+func (w *Window) viYank(l0, c0, l1, c1 int, linewise bool) {
+	var text string
+	var err error
+	if linewise {
+		text, err = w.RegionString(l0, 0, l1, w.lineLen(l1))
+	} else {
+		text, err = w.RegionString(l0, c0, l1, c1)
+	}
+	if err != nil {
+		w.App().Logf("Error yanking: %s", err)
+		return
+	}
+	w.App().register = viRegister{text: text, linewise: linewise}
+	w.App().CopyToClipboard(text)
+}
+
+// viDelete deletes a region.  If linewise is true, whole lines are deleted.
+func (w *Window) viDelete(l0, c0, l1, c1 int, linewise bool) {
+	if !linewise {
+		w.DeleteRegion(l0, c0, l1, c1)
+		return
+	}
+	switch {
+	case l1+1 < w.buffer.LineCount():
+		w.DeleteRegion(l0, 0, l1+1, 0)
+	case l0 > 0:
+		w.DeleteRegion(l0-1, w.lineLen(l0-1), l1, w.lineLen(l1))
+		w.l = l0 - 1
+	default:
+		w.DeleteRegion(0, 0, l1, w.lineLen(l1))
+	}
+	w.c = w.firstNonBlank(w.l)
+}
+
+// viPut inserts the contents of the register after the cursor, or before if
+// before is true.
+func (w *Window) viPut(n int, before bool) {
+	reg := w.App().register
+	if reg.text == "" {
+		return
+	}
+	text := strings.Repeat(reg.text, n)
+	if reg.linewise {
+		text = strings.Repeat(reg.text+"\n", n)
+		l := w.l
+		if !before {
+			l++
+		}
+		if l >= w.buffer.LineCount() {
+			w.MoveCursorToEnd()
+			w.PasteString("\n" + text[:len(text)-1])
+		} else {
+			w.SetCursorPos(l, 0)
+			w.PasteString(text)
+		}
+		w.SetCursorPos(l, 0)
+		w.c = w.firstNonBlank(l)
+		return
+	}
+	if !before && w.c < w.lineLen(w.l) {
+		w.c++
+	}
+	w.PasteString(text)
+	w.MoveCursor(0, -1)
+}
+
+// viEnterInsert switches to insert mode.
+func viEnterInsert(w *Window) {
+	w.App().SetMode("insert")
+}
+
+func viEnterVisual(linewise bool) Action {
+	return func(w *Window) {
+		w.SetAnchor(linewise)
+		w.App().SetMode("visual")
+	}
+}
+
+func viExitVisual(w *Window) {
+	w.ClearAnchor()
+	w.App().SetMode("normal")
+}
+
+// viVisualOperator applies an operator to the visual selection.
+func viVisualOperator(op viOperator) Action {
+	return func(w *Window) {
+		l0, c0, l1, c1 := w.anchorL, w.anchorC, w.l, w.c
+		linewise := w.anchorLinewise
+		viExitVisual(w)
+		if l1 < l0 || (l0 == l1 && c1 < c0) {
+			l0, c0, l1, c1 = l1, c1, l0, c0
+		}
+		l1, c1 = w.buffer.AdvancePos(l1, c1, 0, 1)
+		op.apply(w, l0, c0, l1, c1, linewise)
+	}
+}
+
+var viNormalBindings = []struct {
+	seq    string
+	name   string
+	action ActionMaker
+}{
+	// Stop runes from being inserted
+	{seq: "Rune", name: "vi-ignore", action: SimpleActionMaker(func(w *Window) {})},
+	{seq: "Esc", name: "vi-ignore", action: SimpleActionMaker(func(w *Window) {})},
+	{seq: "i", name: "vi-insert", action: SimpleActionMaker(viEnterInsert)},
+	{seq: "a", name: "vi-append", action: SimpleActionMaker(func(w *Window) {
+		w.viMoveInLine(1)
+		viEnterInsert(w)
+	})},
+	{seq: "I", name: "vi-insert-line-start", action: SimpleActionMaker(func(w *Window) {
+		w.c = w.firstNonBlank(w.l)
+		viEnterInsert(w)
+	})},
+	{seq: "A", name: "vi-append-line-end", action: SimpleActionMaker(func(w *Window) {
+		w.MoveCursorToLineEnd()
+		viEnterInsert(w)
+	})},
+	{seq: "o", name: "vi-open-line-below", action: SimpleActionMaker(func(w *Window) {
+		w.MoveCursorToLineEnd()
+		w.SplitLine(true)
+		viEnterInsert(w)
+	})},
+	{seq: "O", name: "vi-open-line-above", action: SimpleActionMaker(func(w *Window) {
+		w.MoveCursorToLineStart()
+		w.SplitLine(false)
+		viEnterInsert(w)
+	})},
+	{seq: "x", name: "vi-delete-char", action: CountActionMaker(func(w *Window, n int) {
+		c1 := w.c + n
+		if c1 > w.lineLen(w.l) {
+			c1 = w.lineLen(w.l)
+		}
+		w.viYank(w.l, w.c, w.l, c1, false)
+		w.DeleteRegion(w.l, w.c, w.l, c1)
+	})},
+	{seq: "X", name: "vi-delete-char-backward", action: CountActionMaker(func(w *Window, n int) {
+		c0 := w.c - n
+		if c0 < 0 {
+			c0 = 0
+		}
+		w.viYank(w.l, c0, w.l, w.c, false)
+		w.DeleteRegion(w.l, c0, w.l, w.c)
+	})},
+	{seq: "D", name: "vi-delete-line-end", action: SimpleActionMaker(func(w *Window) {
+		w.viYank(w.l, w.c, w.l, w.lineLen(w.l), false)
+		w.DeleteRegion(w.l, w.c, w.l, w.lineLen(w.l))
+	})},
+	{seq: "C", name: "vi-change-line-end", action: SimpleActionMaker(func(w *Window) {
+		w.viYank(w.l, w.c, w.l, w.lineLen(w.l), false)
+		w.DeleteRegion(w.l, w.c, w.l, w.lineLen(w.l))
+		viEnterInsert(w)
+	})},
+	{seq: "Y", name: "vi-yank-lines", action: CountActionMaker(func(w *Window, n int) {
+		w.viYank(w.l, 0, w.viLastLine(n), 0, true)
+	})},
+	{seq: "p", name: "vi-put", action: CountActionMaker(func(w *Window, n int) { w.viPut(n, false) })},
+	{seq: "P", name: "vi-put-before", action: CountActionMaker(func(w *Window, n int) { w.viPut(n, true) })},
+	{seq: "r Rune.Rune", name: "vi-replace-char", action: func(args []interface{}, count int) Action {
+		return func(w *Window) {
+			if w.c+count > w.lineLen(w.l) {
+				return
+			}
+			w.DeleteRegion(w.l, w.c, w.l, w.c+count)
+			w.PasteString(strings.Repeat(string(args[0].(rune)), count))
+			w.MoveCursor(0, -1)
+		}
+	}},
+	{seq: "u", name: "undo", action: SimpleActionMaker(CmdUndo)},
+	{seq: "K", name: "lsp-hover", action: SimpleActionMaker(CmdHover)},
+	{seq: "g d", name: "lsp-definition", action: SimpleActionMaker(CmdGotoDefinition)},
+	{seq: "Ctrl-R", name: "redo", action: SimpleActionMaker(CmdRedo)},
+	{seq: "J", name: "join-lines", action: CountActionMaker(CmdJoinLines)},
+	{seq: "g c c", name: "toggle-comment", action: SimpleActionMaker(CmdToggleComment)},
+	{seq: "z a", name: "toggle-fold", action: SimpleActionMaker(CmdToggleFold)},
+	{seq: "z R", name: "open-all-folds", action: SimpleActionMaker(CmdOpenAllFolds)},
+	{seq: "z M", name: "close-all-folds", action: SimpleActionMaker(CmdCloseAllFolds)},
+	{seq: "v", name: "vi-visual", action: SimpleActionMaker(viEnterVisual(false))},
+	{seq: "V", name: "vi-visual-lines", action: SimpleActionMaker(viEnterVisual(true))},
+}
+
+var viVisualBindings = []struct {
+	seq    string
+	name   string
+	action ActionMaker
+}{
+	{seq: "Rune", name: "vi-ignore", action: SimpleActionMaker(func(w *Window) {})},
+	{seq: "Esc", name: "vi-exit-visual", action: SimpleActionMaker(viExitVisual)},
+	{seq: "v", name: "vi-exit-visual", action: SimpleActionMaker(viExitVisual)},
+	{seq: "V", name: "vi-toggle-visual-lines", action: SimpleActionMaker(func(w *Window) {
+		w.anchorLinewise = !w.anchorLinewise
+	})},
+	{seq: "o", name: "vi-swap-anchor", action: SimpleActionMaker(func(w *Window) { w.SwapAnchor() })},
+	{seq: "x", name: "vi-delete", action: SimpleActionMaker(viVisualOperator(findViOperator("d")))},
+	{seq: "J", name: "join-lines", action: SimpleActionMaker(func(w *Window) {
+		CmdJoinLines(w, 1)
+		viExitVisual(w)
+	})},
+}
+
+// EnableModalEditing switches to vi style modal editing, starting in normal
+// mode.
+func (a *App) EnableModalEditing() {
+	if !a.viBindingsRegistered {
+		a.registerViBindings()
+		a.viBindingsRegistered = true
+	}
+	a.SetMode("normal")
+}
+
+// DisableModalEditing goes back to modeless editing.
+func (a *App) DisableModalEditing() {
+	a.focusedWindow.ClearAnchor()
+	a.SetMode("")
+}
+
+// ToggleModalEditing enables modal editing if it is disabled, and disables it
+// otherwise.
+func (a *App) ToggleModalEditing() {
+	if a.mode == "" {
+		a.EnableModalEditing()
+	} else {
+		a.DisableModalEditing()
+	}
+}
+
+// registerViBindings sets up the event handlers of the vi modes.
+func (a *App) registerViBindings() {
+	normal := a.GetEventHandler(modeHandlerName("normal"))
+	insert := a.GetEventHandler(modeHandlerName("insert"))
+	visual := a.GetEventHandler(modeHandlerName("visual"))
+	normal.BareDigits = true
+	visual.BareDigits = true
+	insert.Timeout = time.Second
+	insert.Fallthrough = true
+	register := func(h *EventHandler, seq, name string, action ActionMaker) {
+		if err := h.registerAction(seq, name, action); err != nil {
+			a.Logf("Unable to register %s: %s", seq, err)
+		}
+	}
+	for _, b := range viNormalBindings {
+		register(normal, b.seq, b.name, b.action)
+	}
+	for _, b := range viVisualBindings {
+		register(visual, b.seq, b.name, b.action)
+	}
+	register(insert, "Esc", "vi-normal", SimpleActionMaker(func(w *Window) {
+		w.viMoveInLine(-1)
+		w.App().SetMode("normal")
+	}))
+	for _, m := range viMotions {
+		m := m
+		move := func(args []interface{}, count int) Action {
+			return func(w *Window) { m.move(w, count, args) }
+		}
+		register(normal, m.seq, "vi-move", move)
+		register(visual, m.seq, "vi-move", move)
+	}
+	for _, op := range viOperators {
+		op := op
+		for _, m := range viMotions {
+			m := m
+			seq := op.seq + " " + m.seq
+			if op.seq == "c" && (m.seq == "w" || m.seq == "W") {
+				// Like vi, "cw" changes to the end of the word.
+				m = findViMotion(map[string]string{"w": "e", "W": "E"}[m.seq])
+			}
+			register(normal, seq, op.name, func(args []interface{}, count int) Action {
+				return func(w *Window) {
+					l0, c0, l1, c1 := w.viMotionRegion(m, count, args)
+					op.apply(w, l0, c0, l1, c1, m.linewise)
+				}
+			})
+		}
+		// Doubling the operator acts on whole lines
+		register(normal, op.seq+" "+op.seq, op.name+"-lines", CountActionMaker(func(w *Window, n int) {
+			op.apply(w, w.l, w.c, w.viLastLine(n), 0, true)
+		}))
+		for _, obj := range viTextObjects {
+			obj := obj
+			register(normal, op.seq+" "+obj.seq, op.name, SimpleActionMaker(func(w *Window) {
+				if l0, c0, l1, c1, ok := obj.region(w); ok {
+					op.apply(w, l0, c0, l1, c1, false)
+				}
+			}))
+		}
+		register(visual, op.seq, op.name, SimpleActionMaker(viVisualOperator(op)))
+	}
+	for _, obj := range viTextObjects {
+		obj := obj
+		register(visual, obj.seq, "vi-select-object", SimpleActionMaker(func(w *Window) {
+			if l0, c0, l1, c1, ok := obj.region(w); ok {
+				w.anchorL, w.anchorC = l0, c0
+				w.l, w.c, _ = w.prevPos(l1, c1)
+			}
+		}))
+	}
+}
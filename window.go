@@ -15,6 +15,7 @@ type Window struct {
 	topLine, leftCol int // Index of the topmost visible line, column of the leftmost visibile column
 	tabSize          int
 	width, height    int
+	top              int // screen row of the top of the window
 	eventHandler     *EventHandler
 	app              *App
 
@@ -23,6 +24,17 @@ type Window struct {
 
 	regionFirstL, regionFirstC int
 	regionLastL, regionLastC   int
+
+	// While there is an anchor, the highlighted region extends from the anchor
+	// to the cursor.
+	anchorL, anchorC int
+	anchorLinewise   bool
+
+	folds       []FoldRange // closed folds
+	brackets    []bracketMark
+	autoClosers []autoCloser
+
+	listening map[Buffer]bool // buffers whose changes are followed
 }
 
 func NewWindow(buf Buffer) *Window {
@@ -33,6 +45,8 @@ func NewWindow(buf Buffer) *Window {
 		copyStartC: -1,
 		copyEndL:   -1,
 		copyEndC:   -1,
+		anchorL:    -1,
+		anchorC:    -1,
 	}
 }
 
@@ -43,6 +57,40 @@ func NewWindow(buf Buffer) *Window {
 func (w *Window) RegisterWithApp(app *App) {
 	w.eventHandler = app.GetEventHandler(w.buffer.Kind())
 	w.app = app
+	app.noteBuffer(w.buffer)
+}
+
+// SetBuffer makes the window show buf from its start.
+func (w *Window) SetBuffer(buf Buffer) {
+	w.buffer = buf
+	w.l, w.c = 0, 0
+	w.topLine, w.leftCol = 0, 0
+	w.folds = nil
+	w.autoClosers = nil
+	w.ClearAnchor()
+	if w.app != nil {
+		w.RegisterWithApp(w.app)
+	}
+}
+
+// listenToBuffer makes the positions kept by the window, e.g. of folds,
+// follow the changes to its buffer.
+func (w *Window) listenToBuffer() {
+	buf := w.buffer
+	n, ok := buf.(ChangeNotifier)
+	if !ok || w.listening[buf] {
+		return
+	}
+	if w.listening == nil {
+		w.listening = map[Buffer]bool{}
+	}
+	w.listening[buf] = true
+	n.AddChangeListener(func(ch BufferChange) {
+		if w.buffer == buf {
+			w.moveFolds(ch)
+			w.moveAutoClosers(ch)
+		}
+	})
 }
 
 func (w *Window) App() *App {
@@ -50,9 +98,6 @@ func (w *Window) App() *App {
 }
 
 func (w *Window) HandleEvent(evt Event) (Action, error) {
-	if evt.EventType == Key || evt.EventType == Rune {
-		w.ResetHighlightRegion()
-	}
 	return w.eventHandler.HandleEvent(evt)
 }
 
@@ -60,9 +105,31 @@ func (w *Window) HandleEvent(evt Event) (Action, error) {
 // Movement methods
 //
 
-// MoveCursor moves the cursor by a number of lines and columns.
+// MoveCursor moves the cursor by a number of lines and columns.  Lines hidden
+// by folds are skipped.
 func (w *Window) MoveCursor(dl, dc int) {
-	w.l, w.c = w.buffer.AdvancePos(w.l, w.c, dl, dc)
+	if len(w.folds) == 0 {
+		w.l, w.c = w.buffer.AdvancePos(w.l, w.c, dl, dc)
+		return
+	}
+	l, c := w.buffer.AdvancePos(w.l, w.c, 0, dc)
+	if f, ok := w.foldHiding(l); ok {
+		if dc > 0 && f.Last+1 < w.buffer.LineCount() {
+			l, c = f.Last+1, 0
+		} else {
+			line, _ := w.buffer.GetLine(f.First, 0)
+			l, c = f.First, line.Len()
+		}
+	}
+	l, rest := w.visibleLine(l, dl)
+	switch {
+	case rest < 0:
+		l, c = 0, 0
+	case rest > 0:
+		line, _ := w.buffer.GetLine(l, 0)
+		c = line.Len()
+	}
+	w.l, w.c = w.buffer.AdvancePos(l, c, 0, 0)
 }
 
 // MoveCursorTo moves the cursor to a given screen position.
@@ -71,14 +138,30 @@ func (w *Window) MoveCursorTo(x, y int) {
 }
 
 func (w *Window) GetLineCol(x, y int) (int, int) {
-	l := y + w.topLine
-	if l >= w.buffer.LineCount() {
-		l = w.buffer.LineCount() - 1
-	}
+	x -= w.gutterWidth()
+	l, _ := w.visibleLine(w.topLine, y)
 	line, _ := w.buffer.GetLine(l, 0)
 	return w.buffer.AdvancePos(l, w.getPrinter().LineIndex(line, x), 0, 0)
 }
 
+// SetCursorPos moves the cursor to the given line and column, adjusted to be a
+// valid position in the buffer.
+func (w *Window) SetCursorPos(l, c int) {
+	switch {
+	case l < 0:
+		l, c = 0, 0
+	case l >= w.buffer.LineCount():
+		l, c = w.buffer.EndPos()
+	}
+	line, _ := w.buffer.GetLine(l, 0)
+	if c > line.Len() {
+		c = line.Len()
+	} else if c < 0 {
+		c = 0
+	}
+	w.l, w.c = l, c
+}
+
 // MoveCursorToLineStart moves the cursor to the start of the current line.
 func (w *Window) MoveCursorToLineStart() {
 	w.l, w.c = w.buffer.AdvancePos(w.l, 0, 0, 0)
@@ -112,12 +195,8 @@ func (w *Window) ScrollDown(n int) {
 	if n <= 0 {
 		return
 	}
-	w.topLine += n
-	maxTopLine := w.buffer.LineCount() - 1
-	if w.topLine > maxTopLine {
-		w.topLine = maxTopLine
-	}
-	dl := w.l - w.topLine
+	w.topLine, _ = w.visibleLine(w.topLine, n)
+	dl := w.lineRow(w.l)
 	if dl < 0 {
 		w.MoveCursor(-dl, 0)
 	}
@@ -129,11 +208,8 @@ func (w *Window) ScrollUp(n int) {
 	if n <= 0 {
 		return
 	}
-	w.topLine -= n
-	if w.topLine < 0 {
-		w.topLine = 0
-	}
-	dl := w.topLine + w.height - 1 - w.l
+	w.topLine, _ = w.visibleLine(w.topLine, -n)
+	dl := w.height - 1 - w.lineRow(w.l)
 	if dl < 0 {
 		w.MoveCursor(dl, 0)
 	}
@@ -162,6 +238,63 @@ func (w *Window) ResetHighlightRegion() {
 	w.copyEndL, w.copyEndC = -1, -1
 }
 
+// HighlightedRegion returns the ordered bounds of the highlighted region, if
+// there is one.
+func (w *Window) HighlightedRegion() (l0, c0, l1, c1 int, ok bool) {
+	if w.copyEndC == -1 {
+		return
+	}
+	w.orderRegion()
+	return w.regionFirstL, w.regionFirstC, w.regionLastL, w.regionLastC, true
+}
+
+// SetAnchor drops an anchor at the cursor position.  If linewise is true, the
+// highlighted region always covers whole lines.
+func (w *Window) SetAnchor(linewise bool) {
+	w.anchorL, w.anchorC = w.l, w.c
+	w.anchorLinewise = linewise
+	w.updateAnchoredRegion()
+}
+
+// ClearAnchor removes the anchor and the highlighted region.
+func (w *Window) ClearAnchor() {
+	w.anchorL, w.anchorC = -1, -1
+	w.ResetHighlightRegion()
+}
+
+// HasAnchor returns true if the window has an anchor.
+func (w *Window) HasAnchor() bool {
+	return w.anchorL >= 0
+}
+
+// SwapAnchor exchanges the positions of the anchor and the cursor.
+func (w *Window) SwapAnchor() {
+	if !w.HasAnchor() {
+		return
+	}
+	w.anchorL, w.anchorC, w.l, w.c = w.l, w.c, w.anchorL, w.anchorC
+	w.updateAnchoredRegion()
+}
+
+// updateAnchoredRegion makes the highlighted region extend from the anchor to
+// the cursor.
+func (w *Window) updateAnchoredRegion() {
+	if !w.HasAnchor() {
+		return
+	}
+	l0, c0, l1, c1 := w.anchorL, w.anchorC, w.l, w.c
+	if w.anchorLinewise {
+		if l1 < l0 {
+			l0, l1 = l1, l0
+		}
+		c0 = 0
+		line, _ := w.buffer.GetLine(l1, 0)
+		c1 = line.Len()
+	}
+	w.copyStartL, w.copyStartC = l0, c0
+	w.copyEndL, w.copyEndC = l1, c1
+}
+
 func (w *Window) GetHighlightedString() (string, error) {
 	if w.copyEndC == -1 {
 		return "", fmt.Errorf("no highlight region")
@@ -204,6 +337,25 @@ func (w *Window) DeleteRune() (err error) {
 	return
 }
 
+// DeleteRegion deletes the text from (l0, c0) up to but not including (l1, c1)
+// and moves the cursor to the start of the region.
+func (w *Window) DeleteRegion(l0, c0, l1, c1 int) error {
+	if l1 < l0 || (l0 == l1 && c1 < c0) {
+		l0, c0, l1, c1 = l1, c1, l0, c0
+	}
+	if err := w.buffer.DeleteRegion(l0, c0, l1, c1); err != nil {
+		return err
+	}
+	w.l, w.c = w.buffer.AdvancePos(l0, c0, 0, 0)
+	return nil
+}
+
+// RegionString returns the text from (l0, c0) up to but not including (l1,
+// c1).
+func (w *Window) RegionString(l0, c0, l1, c1 int) (string, error) {
+	return RegionString(w.buffer, l0, c0, l1, c1)
+}
+
 // SplitLine splits the current line at the cursor position. If move is true,
 // the cursor is moved down otherwise it stays in the same position.
 func (w *Window) SplitLine(move bool) error {
@@ -252,33 +404,43 @@ func (w *Window) Resize(width, height int) {
 // Draw draws the contents of the window on the screen.
 func (w *Window) Draw(screen ScreenWriter) {
 	w.orderRegion()
+	w.updateBrackets()
+	w.drawGutter(screen)
+	screen = w.textScreen(screen)
 	sh := screen.Size().H
-	linesAvail := w.buffer.LineCount() - w.topLine
-	if linesAvail <= 0 {
+	if w.topLine >= w.buffer.LineCount() {
 		return
 	}
-	if sh > linesAvail {
-		sh = linesAvail
-	}
 	lp := w.getPrinter()
+	l := w.topLine
 	for p := (Position{}); p.Y < sh; p.Y++ {
-		lp.Print(screen, p, w.StyledLineIter(p.Y+w.topLine, 0))
+		iter := w.StyledLineIter(l, 0)
+		if f, ok := w.closedFoldAt(l); ok {
+			iter = &foldSummaryIter{iter: iter, summary: foldSummary(f)}
+		}
+		lp.Print(screen, p, iter)
+		var rest int
+		if l, rest = w.visibleLine(l, 1); rest > 0 {
+			break
+		}
 	}
 }
 
 // DrawCursor highlights the cursor if it is visible.
 func (w *Window) DrawCursor(screen ScreenWriter) {
+	screen = w.textScreen(screen)
 	line, _ := w.buffer.GetLine(w.l, w.c)
 	screen.Reverse(Position{
 		X: w.getPrinter().LineCol(line, w.c),
-		Y: w.l - w.topLine,
+		Y: w.lineRow(w.l),
 	})
 }
 
 // FocusCursor adjusts the visible rectangle of the window if necessary to make
 // the cursor visible.
 func (w *Window) FocusCursor(screen ScreenWriter) {
-	sz := screen.Size()
+	w.revealCursor()
+	sz := w.textScreen(screen).Size()
 	line, _ := w.buffer.GetLine(w.l, w.c)
 	x := w.getPrinter().LineCol(line, w.c)
 	if x < 0 {
@@ -286,11 +448,11 @@ func (w *Window) FocusCursor(screen ScreenWriter) {
 	} else if x >= sz.W {
 		w.leftCol += x - sz.W + 1
 	}
-	y := w.l - w.topLine
+	y := w.lineRow(w.l)
 	if y < 0 {
 		w.topLine = w.l
 	} else if y >= sz.H {
-		w.topLine = w.l - sz.H + 1
+		w.topLine, _ = w.visibleLine(w.l, 1-sz.H)
 	}
 }
 
@@ -322,17 +484,41 @@ func (w *Window) StyledLineIter(l, c int) StyledLineIter {
 			c2 = w.regionLastC - c
 		}
 		iter = &highlightIter{
-			iter: iter,
-			c1:   c1,
-			c2:   c2,
+			iter:  iter,
+			c1:    c1,
+			c2:    c2,
+			style: reverse,
+		}
+	}
+	for _, r := range append(w.snippetRanges(l), w.bracketRanges(l)...) {
+		c2 := math.MaxInt
+		if r.c1 >= 0 {
+			c2 = r.c1 - c - 1
+		}
+		iter = &highlightIter{
+			iter:  iter,
+			c1:    r.c0 - c,
+			c2:    c2,
+			style: r.style,
 		}
 	}
 	return iter
 }
 
+// A styledRange is a range of columns of a line drawn in a different style.  c1
+// is excluded, or -1 for the end of the line.
+type styledRange struct {
+	c0, c1 int
+	style  func(Style) Style
+}
+
+func reverse(s Style) Style   { return s.Reverse(true) }
+func underline(s Style) Style { return s.Underline(true) }
+
 type highlightIter struct {
 	iter   StyledLineIter
 	c1, c2 int
+	style  func(Style) Style
 }
 
 var _ StyledLineIter = (*highlightIter)(nil)
@@ -340,7 +526,7 @@ var _ StyledLineIter = (*highlightIter)(nil)
 func (i *highlightIter) Next() (rune, Style) {
 	r, s := i.iter.Next()
 	if i.c1 <= 0 && i.c2 >= 0 {
-		s = s.Reverse(true)
+		s = i.style(s)
 	}
 	i.c1--
 	i.c2--